@@ -4,11 +4,13 @@ package flags
 
 import (
 	"flag"
+	"time"
 )
 
 var (
 	Version     = flag.Bool("version", false, "Print version and exit")
-	Listen      = flag.String("listen", "/var/run/infra.sock", "The listen socket, e.g. /var/run/infra.sock")
+	Providers   = flag.Bool("list-providers", false, "Print the pod and image providers built in, marking the configured ones, and exit")
+	Listen      = flag.String("listen", "/var/run/infra.sock", "The listen address, a unix socket (e.g. /var/run/infra.sock) or host:port")
 	ConfigFile  = flag.String("config", "", "Configuration file")
 	PodProvider = flag.String("podprovider", "virtualbox", "Pod Provider to use")
 	ImgProvider = flag.String("imgprovider", "docker", "Container Image Provider to use")
@@ -17,4 +19,31 @@ var (
 	ClusterCIDR = flag.String("cluster-cidr", "", "The CIDR range of pods in the cluster. It is used to bridge traffic coming from outside of the cluster. If not provided, no off-cluster bridging will be performed.")
 	Kubeconfig  = flag.String("kubeconfig", "/var/lib/kube-proxy/kubeconfig", "Path to kubeconfig file with authorization information (the master location is set by the master flag")
 	IPBase      = flag.String("base-ip", "", "First 3 octets of the IP address")
+	StateFile   = flag.String("state-file", "", "If set, sandboxes are saved to this file and reloaded from it on restart")
+	StateStore  = flag.String("state-store", "file", "Where sandboxes are saved, file (see --state-file) or etcd (see --state-etcd-endpoints)")
+	StateEtcd   = flag.String("state-etcd-endpoints", "", "Comma separated etcd endpoints (e.g. http://10.0.0.1:2379) sandboxes are saved to with --state-store=etcd")
+	StatePrefix = flag.String("state-etcd-prefix", "/infranetes/", "etcd key prefix sandboxes are saved under, managers failing over for each other have to share it")
+	LogFormat   = flag.String("log-format", "glog", "Format of the per request logs, glog or json")
+	MetricsAddr = flag.String("metrics-addr", "", "If set, prometheus metrics are served on this address, e.g. :9090, along with the VMs and pods at /debug/vms and the pod provider's health at /healthz")
+	DestroyVMs  = flag.Bool("destroy-on-shutdown", false, "Destroy every pod's VM when stopped with SIGINT or SIGTERM, instead of leaving them running to be imported again on restart")
+	Colocation  = flag.Bool("colocation", false, "Pack pods with the same infranetes.colocate annotation (per namespace) onto a single VM")
+)
+
+var (
+	VMConnectTimeout     = flag.Duration("vm-connect-timeout", 10*time.Second, "How long a single attempt to reach a VM's vmserver may take")
+	VMConnectWindow      = flag.Duration("vm-connect-window", 2*time.Minute, "How long to keep trying to reach a newly booted VM's vmserver before failing the pod")
+	VMReadyTimeout       = flag.Duration("vm-ready-timeout", time.Minute, "How long a newly booted VM's vmserver has to be able to run containers before failing the pod")
+	ReconcileInterval    = flag.Duration("reconcile-interval", 0, "If set, how often every pod's VM is checked in the background, marking dead pods not ready and dropping ones whose VM is gone")
+	ReadyCache           = flag.Duration("ready-cache-interval", 30*time.Second, "How long a VM's vmserver being ready is trusted before it's asked again, providers may override it")
+	HealthCache          = flag.Duration("health-cache-interval", 30*time.Second, "How long the pod provider's health check result is trusted before Status or /healthz asks the cloud API again")
+	StopGracePeriod      = flag.Duration("stop-grace-period", time.Minute, "How long each container gets to exit when its pod sandbox is stopped before it is killed")
+	VMCallTimeout        = flag.Duration("vm-call-timeout", 30*time.Second, "How long a call to a VM's vmserver may take before it fails with DeadlineExceeded, i.e. status and list calls")
+	VMCreateTimeout      = flag.Duration("vm-create-timeout", 2*time.Minute, "How long a call that does real work in the VM may take before it fails with DeadlineExceeded, i.e. creating or starting a container")
+	SandboxCreateTimeout = flag.Duration("sandbox-create-timeout", 0, "If set, how long RunPodSandbox may take before it fails with DeadlineExceeded, a VM that still comes up after that is destroyed")
+)
+
+var (
+	TLSCert     = flag.String("tls-cert", "", "If set along with --tls-key, the CRI server is served over TLS with this certificate")
+	TLSKey      = flag.String("tls-key", "", "Key of --tls-cert")
+	TLSClientCA = flag.String("tls-client-ca", "", "If set, CRI clients have to present a certificate signed by this CA")
 )
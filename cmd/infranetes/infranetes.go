@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/golang/glog"
 
@@ -16,9 +18,15 @@ import (
 
 	//Registered Providers
 	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/aws"
+	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/digitalocean"
 	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/docker"
+	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/equinix"
 	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/fake"
 	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/gcp"
+	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/hetzner"
+	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/libvirt"
+	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/linode"
+	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/scaleway"
 	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/virtualbox"
 	_ "github.com/apporbit/infranetes/pkg/infranetes/provider/vsphere"
 )
@@ -63,6 +71,12 @@ func main() {
 		json.Unmarshal(file, &conf)
 	}
 
+	if *flags.Providers {
+		printProviders("pod", provider.PodProviders.List(), conf.Cloud)
+		printProviders("image", provider.ImageProviders.List(), conf.Image)
+		os.Exit(0)
+	}
+
 	podProvider, err := provider.NewPodProvider(conf.Cloud)
 	if err != nil {
 		fmt.Printf("Couldn't create pod provider: %v\n", err)
@@ -79,11 +93,66 @@ func main() {
 		fmt.Printf("%v container image provider is not compatible with %v pod provider\n", conf.Image, imgProvider)
 	}
 
-	server, err := infranetes.NewInfranetesManager(podProvider, imgProvider)
+	var server *infranetes.Manager
+	if *flags.TLSCert != "" || *flags.TLSKey != "" {
+		server, err = infranetes.NewInfranetesManagerTLS(podProvider, imgProvider, *flags.TLSCert, *flags.TLSKey, *flags.TLSClientCA)
+	} else {
+		if *flags.TLSClientCA != "" {
+			fmt.Println("--tls-client-ca needs --tls-cert and --tls-key")
+			os.Exit(1)
+		}
+		server, err = infranetes.NewInfranetesManager(podProvider, imgProvider)
+	}
 	if err != nil {
 		fmt.Println("Initialize infranetes server failed: ", err)
 		os.Exit(1)
 	}
 
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	stopping := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		<-sigs
+		close(stopping)
+		server.Shutdown()
+		close(stopped)
+	}()
+
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for range hups {
+			reloader, ok := podProvider.(provider.ConfigReloader)
+			if !ok {
+				glog.Warningf("SIGHUP: %v pod provider can't reload its config, restart infranetes instead", conf.Cloud)
+				continue
+			}
+			if err := reloader.ReloadConfig(); err != nil {
+				glog.Errorf("SIGHUP: couldn't reload config, keeping the old one: %v", err)
+				continue
+			}
+			glog.Infof("SIGHUP: reloaded %v config", conf.Cloud)
+		}
+	}()
+
 	fmt.Println(server.Serve(*flags.Listen))
+
+	// Serve returns as soon as Shutdown stops the grpc server, the rest of it (i.e. destroying VMs) still has to finish
+	select {
+	case <-stopping:
+		<-stopped
+	default:
+	}
+}
+
+func printProviders(kind string, names []string, active string) {
+	fmt.Printf("%v providers:\n", kind)
+	for _, name := range names {
+		if name == active {
+			fmt.Printf("  * %v\n", name)
+		} else {
+			fmt.Printf("    %v\n", name)
+		}
+	}
 }
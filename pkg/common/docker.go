@@ -3,6 +3,8 @@
 package common
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -221,3 +223,65 @@ func ToRuntimeAPIImage(image *dockertypes.Image) (*kubeapi.Image, error) {
 		Size_:       size,
 	}, nil
 }
+
+const (
+	// RegistryAuthAnnotation carries the docker registry credentials for a container's image from infranetes to
+	// vmserver, as the CRI has no room for them on CreateContainer.  vmserver takes it out of the container's
+	// annotations before they become labels.
+	RegistryAuthAnnotation = "infranetes.registry-auth"
+)
+
+// EncodeRegistryAuth turns the kubelet's credentials into the base64 json docker wants as RegistryAuth, "" if there are
+// none
+func EncodeRegistryAuth(auth *kubeapi.AuthConfig) (string, error) {
+	if auth == nil {
+		return "", nil
+	}
+
+	dockerAuth := dockertypes.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		Auth:          auth.Auth,
+		ServerAddress: auth.ServerAddress,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken,
+	}
+
+	buf, err := json.Marshal(dockerAuth)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(buf), nil
+}
+
+// PopRegistryAuth removes and returns the RegistryAuthAnnotation from the container's config
+func PopRegistryAuth(config *kubeapi.ContainerConfig) string {
+	if config == nil || config.Annotations == nil {
+		return ""
+	}
+
+	auth := config.Annotations[RegistryAuthAnnotation]
+	delete(config.Annotations, RegistryAuthAnnotation)
+
+	return auth
+}
+
+// RedactRegistryAuth returns req with the registry credentials blanked out, for logging
+func RedactRegistryAuth(req *kubeapi.CreateContainerRequest) *kubeapi.CreateContainerRequest {
+	if _, ok := req.GetConfig().GetAnnotations()[RegistryAuthAnnotation]; !ok {
+		return req
+	}
+
+	config := *req.Config
+	config.Annotations = make(map[string]string)
+	for k, v := range req.Config.Annotations {
+		config.Annotations[k] = v
+	}
+	config.Annotations[RegistryAuthAnnotation] = "<redacted>"
+
+	redacted := *req
+	redacted.Config = &config
+
+	return &redacted
+}
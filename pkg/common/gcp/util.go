@@ -37,6 +37,8 @@ type GceConfig struct {
 	AuthFile    string
 	Network     string
 	Subnet      string
+	// Preemptible boots pods on preemptible instances, pods can opt in or out with the infranetes.gcp.preemptible annotation
+	Preemptible bool
 }
 
 type account struct {
@@ -208,19 +210,32 @@ func delRoute(vm *gcp.VM) error {
 	return s.DelRoute(vm.Name)
 }
 
-func (s *GcpSvcWrapper) TagNewInstance(name string) error {
+// TagNewInstance labels the instance as ours, along with labels
+func (s *GcpSvcWrapper) TagNewInstance(name string, labels map[string]string) error {
 	i, err := s.Service.Instances.Get(s.Project, s.Zone, name).Do()
 	if err != nil {
 		return fmt.Errorf("TagNewInstance: Couldn't get instance: %v: %v", name, err)
 	}
 
+	newLabels := map[string]string{infranetesLabelKey: infranetesLabelValue}
+	for k, v := range labels {
+		if k != infranetesLabelKey {
+			newLabels[k] = v
+		}
+	}
+
 	req := &googlecloud.InstancesSetLabelsRequest{
 		LabelFingerprint: i.LabelFingerprint,
-		Labels:           map[string]string{infranetesLabelKey: infranetesLabelValue},
+		Labels:           newLabels,
 	}
 
 	op, err := s.Service.Instances.SetLabels(s.Project, s.Zone, name, req).Do()
-	err = s.waitForGlobalOperationReady(op.Name)
+	if err != nil {
+		return fmt.Errorf("TagNewInstance failed: %v", err)
+	}
+
+	// SetLabels is a zonal operation
+	err = s.waitForZoneOperationReady(op.Name)
 	if err != nil {
 		return fmt.Errorf("TagNewInstance failed: %v", err)
 	}
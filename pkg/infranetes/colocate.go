@@ -0,0 +1,249 @@
+/* Co-location: with --colocation, pods with the same infranetes.colocate annotation are packed onto a single VM */
+
+package infranetes
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	colocateAnnotation = "infranetes.colocate"
+)
+
+// sharedVM is a VM the pods of a colocation group are packed onto.  It is kept until the last of its sandboxes is
+// removed, even if that isn't the one it was booted for.
+//
+// The sandboxes on it share its ip, so they can't listen on the same ports.  Only the owner is saved by saveState, the
+// others aren't picked back up after a restart and the kubelet recreates them.
+type sharedVM struct {
+	group string
+	// the sandbox the VM was booted for, its VM, client and provider data are the ones every sandbox on it uses
+	owner     *common.PodData
+	client    common.Client
+	sandboxes map[string]bool
+}
+
+// colocationGroup is the group the pod asked to be packed into, "" if none or colocation isn't enabled.  Groups don't
+// span namespaces.
+func colocationGroup(meta *kubeapi.PodSandboxMetadata, annotations map[string]string) string {
+	if !*flags.Colocation {
+		return ""
+	}
+
+	group := annotations[colocateAnnotation]
+	if group == "" {
+		return ""
+	}
+
+	return meta.GetNamespace() + "/" + group
+}
+
+// trackSharedLocked makes a booted sandbox its group's VM if the group doesn't have one yet.  Expects vmMapLock to
+// already be held, see addSandboxLocked().
+func (m *Manager) trackSharedLocked(podData *common.PodData) {
+	group := colocationGroup(podData.Metadata, podData.Annotations)
+	if group == "" || !podData.Booted || podData.Client == nil {
+		return
+	}
+
+	if _, ok := m.vmOf[podData.Id]; ok {
+		return
+	}
+	if _, ok := m.colocated[group]; ok {
+		return
+	}
+
+	vm := &sharedVM{
+		group:     group,
+		owner:     podData,
+		client:    podData.Client,
+		sandboxes: map[string]bool{podData.Id: true},
+	}
+	m.colocated[group] = vm
+	m.vmOf[podData.Id] = vm
+
+	glog.Infof("trackSharedLocked: %v is the VM of colocation group %v", podData.Id, group)
+}
+
+// joinSharedVM returns a sandbox for req on its colocation group's VM, and the VM, nil if there is none to join (or it
+// isn't answering), in which case a VM is booted for it as usual.  The caller adds it to the VM, see addGuestLocked().
+func (m *Manager) joinSharedVM(req *kubeapi.RunPodSandboxRequest) (*common.PodData, *sharedVM) {
+	config := req.GetConfig()
+
+	group := colocationGroup(config.GetMetadata(), config.GetAnnotations())
+	if group == "" {
+		return nil, nil
+	}
+
+	m.vmMapLock.RLock()
+	vm := m.colocated[group]
+	m.vmMapLock.RUnlock()
+
+	if vm == nil {
+		return nil, nil
+	}
+
+	if err := vm.client.Ready(); err != nil {
+		glog.Warningf("joinSharedVM: VM of colocation group %v isn't ready, booting one for %v: %v", group, config.GetMetadata().GetName(), err)
+		return nil, nil
+	}
+
+	owner := vm.owner
+	owner.RLock()
+	defer owner.RUnlock()
+
+	id := fmt.Sprintf("colocated-%v-%d", config.GetMetadata().GetUid(), config.GetMetadata().GetAttempt())
+	booted := true
+	podData := common.NewPodData(owner.VM, id, config.Metadata, config.Annotations, config.Labels, owner.Ip, config.Linux, vm.client, booted, owner.ProviderData)
+	podData.ReadyCacheInterval = owner.ReadyCacheInterval
+
+	glog.Infof("joinSharedVM: putting %v on the VM of %v (colocation group %v)", id, owner.Id, group)
+
+	return podData, vm
+}
+
+// addGuestLocked adds a sandbox from joinSharedVM() to its VM, failing if the VM was removed in the meantime.  Expects
+// vmMapLock to already be held.
+func (m *Manager) addGuestLocked(podData *common.PodData, vm *sharedVM) error {
+	if m.colocated[vm.group] != vm {
+		return fmt.Errorf("VM of colocation group %v was removed while adding %v to it", vm.group, podData.Id)
+	}
+
+	vm.sandboxes[podData.Id] = true
+	m.vmOf[podData.Id] = vm
+
+	return nil
+}
+
+func (m *Manager) sharedVMOf(id string) *sharedVM {
+	m.vmMapLock.RLock()
+	defer m.vmMapLock.RUnlock()
+
+	return m.vmOf[id]
+}
+
+// isGuest is whether the sandbox is on a VM that was booted for another one
+func (m *Manager) isGuest(podData *common.PodData) bool {
+	vm := m.sharedVMOf(podData.Id)
+
+	return vm != nil && vm.owner != podData
+}
+
+// stopsVM is whether stopping the sandbox should have the provider stop its VM, i.e. not while other sandboxes are on it
+func (m *Manager) stopsVM(podData *common.PodData) bool {
+	m.vmMapLock.RLock()
+	defer m.vmMapLock.RUnlock()
+
+	vm, ok := m.vmOf[podData.Id]
+
+	return !ok || (vm.owner == podData && len(vm.sandboxes) == 1)
+}
+
+// ownContainers narrows a list request down to the sandbox's own containers if it is on a shared VM, so they aren't
+// listed once for every sandbox on it
+func (m *Manager) ownContainers(req *kubeapi.ListContainersRequest, id string) *kubeapi.ListContainersRequest {
+	if m.sharedVMOf(id) == nil || req.GetFilter().GetPodSandboxId() != "" {
+		return req
+	}
+
+	filter := kubeapi.ContainerFilter{}
+	if req.Filter != nil {
+		filter = *req.Filter
+	}
+	filter.PodSandboxId = id
+
+	return &kubeapi.ListContainersRequest{Filter: &filter}
+}
+
+func (m *Manager) ownContainerStats(req *kubeapi.ListContainerStatsRequest, id string) *kubeapi.ListContainerStatsRequest {
+	if m.sharedVMOf(id) == nil || req.GetFilter().GetPodSandboxId() != "" {
+		return req
+	}
+
+	filter := kubeapi.ContainerStatsFilter{}
+	if req.Filter != nil {
+		filter = *req.Filter
+	}
+	filter.PodSandboxId = id
+
+	return &kubeapi.ListContainerStatsRequest{Filter: &filter}
+}
+
+// leaveSharedVM takes the sandbox off its shared VM, returning the VM (nil if it wasn't on one) and whether it was the
+// last sandbox on it, in which case the VM is now the caller's to get rid of
+func (m *Manager) leaveSharedVM(id string) (*sharedVM, bool) {
+	m.vmMapLock.Lock()
+	defer m.vmMapLock.Unlock()
+
+	vm, ok := m.vmOf[id]
+	if !ok {
+		return nil, false
+	}
+
+	delete(vm.sandboxes, id)
+	delete(m.vmOf, id)
+
+	last := len(vm.sandboxes) == 0
+	if last && m.colocated[vm.group] == vm {
+		delete(m.colocated, vm.group)
+	}
+
+	return vm, last
+}
+
+// rejoinSharedVM undoes leaveSharedVM when removing the sandbox failed
+func (m *Manager) rejoinSharedVM(id string, vm *sharedVM) {
+	m.vmMapLock.Lock()
+	defer m.vmMapLock.Unlock()
+
+	vm.sandboxes[id] = true
+	m.vmOf[id] = vm
+	if _, ok := m.colocated[vm.group]; !ok {
+		m.colocated[vm.group] = vm
+	}
+}
+
+// removeFromSharedVM is RemovePod() for a sandbox on a shared VM.  Only the last sandbox on the VM destroys it (unless
+// destroy is false, i.e. it is already gone), closes its client and has the provider let go of it, the others just
+// drop their reference to it.  Returns false if the sandbox isn't on a shared VM.  Expects podData's lock to be held.
+func (m *Manager) removeFromSharedVM(podData *common.PodData, destroy bool) (bool, error) {
+	vm, last := m.leaveSharedVM(podData.Id)
+	if vm == nil {
+		return false, nil
+	}
+
+	if !last {
+		glog.Infof("removeFromSharedVM: leaving the VM of colocation group %v to its %d other sandboxes", vm.group, len(vm.sandboxes))
+		podData.Client = nil
+		return true, nil
+	}
+
+	owner := vm.owner
+	if owner != podData {
+		owner.Lock()
+		defer owner.Unlock()
+	}
+
+	if destroy {
+		if err := m.destroyVM(owner); err != nil {
+			m.rejoinSharedVM(podData.Id, vm)
+			return true, err
+		}
+	}
+
+	vm.client.Close()
+	podData.Client = nil
+	owner.Client = nil
+	m.podProvider.RemovePodSandbox(owner)
+
+	glog.Infof("removeFromSharedVM: %v was the last sandbox on the VM of colocation group %v, removed it", podData.Id, vm.group)
+
+	return true, nil
+}
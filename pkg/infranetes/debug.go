@@ -0,0 +1,94 @@
+package infranetes
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+)
+
+type debugPod struct {
+	Id        string
+	Namespace string
+	Name      string
+	State     string
+	Ip        string
+	Booted    bool
+	// as the provider's GetVMList calls it
+	VM string `json:",omitempty"`
+	// i.e. the EC2 instance id, for providers that save one.  CRI v1alpha1's ListPodSandbox has no verbose Info to put
+	// this in, so tooling has to ask here.
+	InstanceId string `json:",omitempty"`
+}
+
+type debugVMList struct {
+	Provider string
+	// every VM the provider has, nil if it can't list them
+	VMs      []string `json:",omitempty"`
+	VMsError string   `json:",omitempty"`
+	// VMs no pod is on, i.e. left behind by a crash or a failed remove
+	Orphaned []string `json:",omitempty"`
+	Pods     []debugPod
+}
+
+// debugVMs serves /debug/vms, the provider's VMs next to the pods we know about, as json.  Pod states are the last ones
+// seen, no VM is asked.
+func (m *Manager) debugVMs(w http.ResponseWriter, r *http.Request) {
+	resp := &debugVMList{
+		Provider: *flags.PodProvider,
+		Pods:     []debugPod{},
+	}
+
+	lister, _ := m.podProvider.(provider.VMLister)
+	restorer, _ := m.podProvider.(provider.PodRestorer)
+
+	used := make(map[string]bool)
+	for _, podData := range m.copyVMMap() {
+		podData.RLock()
+		pod := debugPod{
+			Id:        podData.Id,
+			Namespace: podData.Metadata.GetNamespace(),
+			Name:      podData.Metadata.GetName(),
+			State:     podData.PodState.String(),
+			Ip:        podData.Ip,
+			Booted:    podData.Booted,
+		}
+		if lister != nil && !isVMless(podData) {
+			pod.VM = lister.VMName(podData)
+		}
+		if restorer != nil && !isVMless(podData) {
+			pod.InstanceId = restorer.InstanceId(podData)
+		}
+		podData.RUnlock()
+
+		used[pod.VM] = true
+		resp.Pods = append(resp.Pods, pod)
+	}
+
+	sort.Slice(resp.Pods, func(i, j int) bool { return resp.Pods[i].Id < resp.Pods[j].Id })
+
+	if lister == nil {
+		resp.VMsError = *flags.PodProvider + " pod provider can't list its VMs"
+	} else if vms, err := lister.GetVMList(); err != nil {
+		resp.VMsError = err.Error()
+	} else {
+		sort.Strings(vms)
+		resp.VMs = vms
+		for _, vm := range vms {
+			if !used[vm] {
+				resp.Orphaned = append(resp.Orphaned, vm)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		glog.Warningf("debugVMs: %v", err)
+	}
+}
@@ -3,13 +3,23 @@ package infranetes
 import (
 	"errors"
 	"fmt"
+	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"github.com/docker/docker/pkg/mount"
 
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+	icommon "github.com/apporbit/infranetes/pkg/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/types"
 
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
@@ -18,6 +28,22 @@ var (
 	supportedNetworkMounts = map[string]bool{"nfs4": true}
 )
 
+const (
+	// max number of sandboxes listPodSandbox checks at once
+	listPodSandboxWorkers = 10
+	// max number of VMs listContainers asks at once
+	listContainersWorkers = 10
+
+	// creating and starting containers get more than one reconnect, a VM that just booted can take a moment to be
+	// reachable for good
+	containerCallAttempts = 3
+	containerCallBackoff  = time.Second
+
+	// discardSandbox has no kubelet to retry it, so it retries destroying the VM itself
+	discardAttempts = 3
+	discardBackoff  = 10 * time.Second
+)
+
 func (m *Manager) importSandboxes() {
 	podDatas, err := m.podProvider.ListInstances()
 
@@ -29,21 +55,111 @@ func (m *Manager) importSandboxes() {
 	defer m.vmMapLock.Unlock()
 
 	for _, podData := range podDatas {
-		m.vmMap[podData.Id] = podData
+		m.addSandboxLocked(podData)
+	}
+}
+
+// sandboxKey identifies a sandbox the way the kubelet does, a retried RunPodSandbox has the same key while a new attempt
+// at the pod doesn't.  "" if the sandbox can't be identified.
+func sandboxKey(meta *kubeapi.PodSandboxMetadata) string {
+	if meta == nil || meta.Uid == "" {
+		return ""
 	}
+
+	return fmt.Sprintf("%v_%d", meta.Uid, meta.Attempt)
 }
 
-func (m *Manager) createSandbox(req *kubeapi.RunPodSandboxRequest) (*kubeapi.RunPodSandboxResponse, error) {
+// addSandboxLocked and removeSandboxLocked expect vmMapLock to already be held
+func (m *Manager) addSandboxLocked(podData *common.PodData) {
+	m.vmMap[podData.Id] = podData
+	if key := sandboxKey(podData.Metadata); key != "" {
+		m.sandboxIndex[key] = podData.Id
+	}
+	m.trackSharedLocked(podData)
+}
+
+func (m *Manager) removeSandboxLocked(id string, meta *kubeapi.PodSandboxMetadata) {
+	delete(m.vmMap, id)
+	if key := sandboxKey(meta); key != "" && m.sandboxIndex[key] == id {
+		delete(m.sandboxIndex, key)
+	}
+}
+
+// createSandbox returns the existing sandbox if the kubelet retries a RunPodSandbox (i.e. after timing out on a slow
+// boot), waiting for it if it is still being created, rather than booting a second VM for the same pod
+func (m *Manager) createSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest) (*kubeapi.RunPodSandboxResponse, error) {
 	resp := &kubeapi.RunPodSandboxResponse{}
 
-	volumes := m.volumeMap[req.Config.Metadata.Uid]
+	if *flags.SandboxCreateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *flags.SandboxCreateTimeout)
+		defer cancel()
+	}
 
-	podData, err := m.podProvider.RunPodSandbox(req, volumes)
-	if err == nil {
+	key := sandboxKey(req.Config.Metadata)
+
+	for key != "" {
 		m.vmMapLock.Lock()
-		defer m.vmMapLock.Unlock()
+		if id, ok := m.sandboxIndex[key]; ok {
+			m.vmMapLock.Unlock()
+			glog.Infof("createSandbox: %v already has sandbox %v", key, id)
+			resp.PodSandboxId = id
+			return resp, nil
+		}
 
-		m.vmMap[podData.Id] = podData
+		done, ok := m.creating[key]
+		if !ok {
+			m.creating[key] = make(chan struct{})
+			m.vmMapLock.Unlock()
+			// however we return, a recovered panic in the provider included, so no retry waits on it forever
+			defer m.doneCreating(key)
+			break
+		}
+		m.vmMapLock.Unlock()
+
+		glog.Infof("createSandbox: %v is already being created, waiting for it", key)
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("createSandbox: gave up waiting for %v: %v", key, ctx.Err())
+		}
+	}
+
+	volumes := m.podVolumes(req.Config.Metadata.Uid)
+
+	var (
+		podData *common.PodData
+		oldMeta *kubeapi.PodSandboxMetadata
+		vm      *sharedVM
+		err     error
+	)
+	if wantsNoVM(req.GetConfig().GetAnnotations()) {
+		podData, err = createVMlessSandbox(req)
+	} else {
+		podData, oldMeta = m.resumeSandbox(ctx, req, volumes)
+
+		if podData == nil {
+			podData, vm = m.joinSharedVM(req)
+		}
+
+		if podData == nil {
+			podData, err = m.runPodSandbox(ctx, req, volumes)
+		}
+	}
+
+	m.vmMapLock.Lock()
+	defer m.vmMapLock.Unlock()
+
+	if err == nil && vm != nil {
+		err = m.addGuestLocked(podData, vm)
+	}
+
+	if err == nil {
+		// a resumed sandbox is no longer its old attempt's
+		if oldMeta != nil {
+			m.removeSandboxLocked(podData.Id, oldMeta)
+		}
+		m.addSandboxLocked(podData)
 
 		resp.PodSandboxId = podData.Id
 	}
@@ -51,7 +167,192 @@ func (m *Manager) createSandbox(req *kubeapi.RunPodSandboxRequest) (*kubeapi.Run
 	return resp, err
 }
 
-func (m *Manager) stopSandbox(req *kubeapi.StopPodSandboxRequest) (*kubeapi.StopPodSandboxResponse, error) {
+// doneCreating wakes up the retries waiting on createSandbox's attempt at key, which is in sandboxIndex by now if it
+// succeeded.  A failed attempt lets them try for themselves.
+func (m *Manager) doneCreating(key string) {
+	m.vmMapLock.Lock()
+	defer m.vmMapLock.Unlock()
+
+	if done, ok := m.creating[key]; ok {
+		delete(m.creating, key)
+		close(done)
+	}
+}
+
+// runPodSandbox is the pod provider's RunPodSandbox, but gives up after --sandbox-create-timeout even if the provider
+// doesn't watch ctx (i.e. is stuck in libretto's Provision()).  A sandbox the provider still returns after that is torn
+// down again, the kubelet won't ever hear of it.  The kubelet giving up doesn't count, its retry waits for this attempt.
+func (m *Manager) runPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+	timeout := *flags.SandboxCreateTimeout
+	if timeout <= 0 {
+		return m.podProvider.RunPodSandbox(ctx, req, volumes)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	type result struct {
+		podData *common.PodData
+		err     error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		podData, err := m.podProvider.RunPodSandbox(ctx, req, volumes)
+		done <- result{podData, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil && ctx.Err() == context.DeadlineExceeded {
+			return nil, grpc.Errorf(codes.DeadlineExceeded, "RunPodSandbox: %v", r.err)
+		}
+		return r.podData, r.err
+	case <-timer.C:
+	}
+
+	go func() {
+		r := <-done
+		if r.err == nil && r.podData != nil {
+			glog.Warningf("runPodSandbox: %v came up after RunPodSandbox gave up on it, destroying it", r.podData.Id)
+			m.discardSandbox(r.podData)
+		}
+	}()
+
+	return nil, grpc.Errorf(codes.DeadlineExceeded, "RunPodSandbox: timed out after %v", timeout)
+}
+
+// discardSandbox destroys a sandbox that never made it into vmMap, like removePodSandbox would.  If its VM can't be
+// destroyed the provider isn't told the sandbox is gone, so its ip isn't handed out again, and the VM is left for
+// /debug/vms to show as orphaned.
+func (m *Manager) discardSandbox(podData *common.PodData) {
+	podData.Lock()
+	defer podData.Unlock()
+
+	if podData.Booted && podData.VM != nil {
+		var err error
+		for attempt := 1; attempt <= discardAttempts; attempt++ {
+			if err = m.destroyVM(podData); err == nil {
+				break
+			}
+			glog.Warningf("discardSandbox: attempt %d to destroy the VM of %v failed: %v", attempt, podData.Id, err)
+			if attempt < discardAttempts {
+				time.Sleep(discardBackoff)
+			}
+		}
+		if err != nil {
+			glog.Errorf("discardSandbox: gave up on destroying the VM of %v, it has to be deleted by hand", podData.Id)
+			return
+		}
+	}
+
+	podData.RemovePod()
+	m.podProvider.RemovePodSandbox(podData)
+}
+
+// removeUnknownSandbox handles the removal of a sandbox that isn't in vmMap, i.e. one already removed or whose
+// RunPodSandbox failed part way.  CRI has removing be idempotent, so it succeeds unless the provider finds a VM for it
+// that it can't destroy.
+func (m *Manager) removeUnknownSandbox(id string) error {
+	remover, ok := m.podProvider.(provider.OrphanRemover)
+	if !ok {
+		glog.Infof("removeUnknownSandbox: %v isn't a sandbox we know, nothing to remove", id)
+		return nil
+	}
+
+	found, err := remover.RemoveOrphan(id)
+	if err != nil {
+		return fmt.Errorf("removePodSandbox: couldn't remove the VM left behind by %v: %v", id, err)
+	}
+
+	if found {
+		glog.Warningf("removeUnknownSandbox: destroyed the VM left behind by %v", id)
+	} else {
+		glog.Infof("removeUnknownSandbox: %v isn't a sandbox we know, and has no VM", id)
+	}
+
+	return nil
+}
+
+// destroyVM destroys the VM of a sandbox being removed.  A failed Destroy only counts as destroyed when the provider
+// confirms the VM is gone (i.e. an earlier attempt got it), otherwise the sandbox has to be kept so its VM isn't lost
+// track of while it still exists.
+func (m *Manager) destroyVM(podData *common.PodData) error {
+	err := podData.VM.Destroy()
+	if err == nil {
+		return nil
+	}
+
+	if checker, ok := m.podProvider.(provider.VMChecker); ok {
+		if exists, cerr := checker.VMExists(podData); cerr == nil && !exists {
+			glog.Infof("destroyVM: destroying the VM of %v failed, but it is gone: %v", podData.Id, err)
+			return nil
+		}
+	}
+
+	if explainer, ok := m.podProvider.(provider.DestroyErrorExplainer); ok {
+		err = explainer.ExplainDestroyError(podData, err)
+	}
+
+	return err
+}
+
+// resumeSandbox has the pod provider start the VM of a stopped earlier attempt of the pod back up, if it can, making that
+// sandbox, id and all, the new attempt.  Returns the resumed sandbox and the metadata it had before, nil if there was
+// none to resume.  If resuming fails, a new sandbox is created as usual and the stopped one is left to be removed.
+func (m *Manager) resumeSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, *kubeapi.PodSandboxMetadata) {
+	resumer, ok := m.podProvider.(provider.PodResumer)
+	if !ok {
+		return nil, nil
+	}
+
+	meta := req.GetConfig().GetMetadata()
+
+	var stopped *common.PodData
+	for _, podData := range m.copyVMMap() {
+		// a shared VM is never stopped
+		if m.sharedVMOf(podData.Id) != nil {
+			continue
+		}
+
+		podData.RLock()
+		if podData.Booted && podData.Client != nil && podData.PodState == kubeapi.PodSandboxState_SANDBOX_NOTREADY &&
+			podData.Metadata.GetUid() == meta.GetUid() && podData.Metadata.GetAttempt() < meta.GetAttempt() &&
+			(stopped == nil || podData.Metadata.GetAttempt() > stopped.Metadata.GetAttempt()) {
+			stopped = podData
+		}
+		podData.RUnlock()
+	}
+
+	if stopped == nil {
+		return nil, nil
+	}
+
+	stopped.Lock()
+	defer stopped.Unlock()
+
+	// removed or resumed while we weren't holding its lock
+	if stopped.Client == nil || stopped.PodState != kubeapi.PodSandboxState_SANDBOX_NOTREADY {
+		return nil, nil
+	}
+
+	oldMeta := stopped.Metadata
+
+	resumed, err := resumer.ResumePodSandbox(ctx, stopped, req, volumes)
+	if err != nil {
+		glog.Warningf("resumeSandbox: couldn't resume %v, creating a new sandbox: %v", stopped.Id, err)
+		return nil, nil
+	}
+	if !resumed {
+		return nil, nil
+	}
+
+	glog.Infof("resumeSandbox: resumed %v for attempt %d", stopped.Id, meta.GetAttempt())
+
+	return stopped, oldMeta
+}
+
+func (m *Manager) stopSandbox(ctx context.Context, req *kubeapi.StopPodSandboxRequest) (*kubeapi.StopPodSandboxResponse, error) {
 	podId := req.GetPodSandboxId()
 
 	podData, err := m.getPodData(podId)
@@ -72,7 +373,13 @@ func (m *Manager) stopSandbox(req *kubeapi.StopPodSandboxRequest) (*kubeapi.Stop
 		return nil, fmt.Errorf(msg)
 	}
 
-	contResp, err := client.ListContainers(&kubeapi.ListContainersRequest{})
+	// StopPodSandbox can be called more than once, and the provider may have already shut the VM down
+	if podData.PodState == kubeapi.PodSandboxState_SANDBOX_NOTREADY {
+		glog.Infof("stopSandbox: %s is already stopped", podId)
+		return &kubeapi.StopPodSandboxResponse{}, nil
+	}
+
+	contResp, err := client.ListContainers(m.ownContainers(&kubeapi.ListContainersRequest{}, podId))
 	if err != nil {
 		msg := fmt.Sprintf("stopSandbox: ListContainers failed for %s: %v", podId, err)
 		glog.Infof(msg)
@@ -80,7 +387,7 @@ func (m *Manager) stopSandbox(req *kubeapi.StopPodSandboxRequest) (*kubeapi.Stop
 	}
 
 	for _, cont := range contResp.Containers {
-		timeout := int64(60)
+		timeout := int64(flags.StopGracePeriod.Seconds())
 		contReq := &kubeapi.StopContainerRequest{
 			ContainerId: cont.Id,
 			Timeout:     timeout,
@@ -92,7 +399,18 @@ func (m *Manager) stopSandbox(req *kubeapi.StopPodSandboxRequest) (*kubeapi.Stop
 	}
 
 	podData.StopPod()
-	m.podProvider.StopPodSandbox(podData)
+	if isVMless(podData) {
+		return &kubeapi.StopPodSandboxResponse{}, nil
+	}
+	if !m.stopsVM(podData) {
+		glog.Infof("stopSandbox: leaving the VM of %s running for the other sandboxes on it", podId)
+		return &kubeapi.StopPodSandboxResponse{}, nil
+	}
+	if err := m.podProvider.StopPodSandbox(ctx, podData); err != nil {
+		msg := fmt.Sprintf("stopSandbox: provider failed to stop %s: %v", podId, err)
+		glog.Warning(msg)
+		return nil, errors.New(msg)
+	}
 
 	resp := &kubeapi.StopPodSandboxResponse{}
 
@@ -102,7 +420,7 @@ func (m *Manager) stopSandbox(req *kubeapi.StopPodSandboxRequest) (*kubeapi.Stop
 func (m *Manager) removePodSandbox(req *kubeapi.RemovePodSandboxRequest) error {
 	podData, err := m.getPodData(req.GetPodSandboxId())
 	if err != nil {
-		return fmt.Errorf("removePodSandbox: %v", err)
+		return m.removeUnknownSandbox(req.GetPodSandboxId())
 	}
 
 	podData.Lock()
@@ -111,20 +429,27 @@ func (m *Manager) removePodSandbox(req *kubeapi.RemovePodSandboxRequest) error {
 	sandboxId := req.GetPodSandboxId()
 	uuid := podData.Metadata.Uid
 
-	if podData.Booted {
-		if err := podData.VM.Destroy(); err != nil {
-			return fmt.Errorf("removePodSandbox: %v", err)
+	if shared, err := m.removeFromSharedVM(podData, true); err != nil {
+		return fmt.Errorf("removePodSandbox: %v", err)
+	} else if !shared {
+		if podData.Booted {
+			// the kubelet retries the remove, until then the sandbox stays listed
+			if err := m.destroyVM(podData); err != nil {
+				return fmt.Errorf("removePodSandbox: %v", err)
+			}
 		}
-	}
 
-	podData.RemovePod()
-	m.podProvider.RemovePodSandbox(podData)
+		podData.RemovePod()
+		if !isVMless(podData) {
+			m.podProvider.RemovePodSandbox(podData)
+		}
+	}
 
 	m.vmMapLock.Lock()
 	defer m.vmMapLock.Unlock()
 
-	delete(m.vmMap, sandboxId)
-	delete(m.volumeMap, uuid)
+	m.removeSandboxLocked(sandboxId, podData.Metadata)
+	m.forgetVolumes(uuid)
 
 	return nil
 }
@@ -149,14 +474,39 @@ func (m *Manager) podSandboxStatus(req *kubeapi.PodSandboxStatusRequest) (*kubea
 func (m *Manager) listPodSandbox(req *kubeapi.ListPodSandboxRequest) (*kubeapi.ListPodSandboxResponse, error) {
 	sandboxes := []*kubeapi.PodSandbox{}
 
-	glog.V(1).Infof("listPodSandbox: len of vmMap = %v", len(m.vmMap))
+	podDatas := m.copyVMMap()
 
-	for _, podData := range m.copyVMMap() {
-		// podData lock is taken and released in filter
-		if sandbox, ok := m.filter(podData, req.Filter); ok {
-			glog.V(1).Infof("listPodSandbox Appending a sandbox for %v to sandboxes", podData.Id)
-			sandboxes = append(sandboxes, sandbox)
-		}
+	glog.V(1).Infof("listPodSandbox: len of vmMap = %v", len(podDatas))
+
+	// filter checks each VM's readiness, so run them in parallel instead of paying for every slow VM in turn
+	work := make(chan *common.PodData)
+	results := make(chan *kubeapi.PodSandbox, len(podDatas))
+
+	var wg sync.WaitGroup
+	for i := 0; i < listPodSandboxWorkers && i < len(podDatas); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for podData := range work {
+				// podData lock is taken and released in filter
+				if sandbox, ok := m.filter(podData, req.Filter); ok {
+					glog.V(1).Infof("listPodSandbox Appending a sandbox for %v to sandboxes", podData.Id)
+					results <- sandbox
+				}
+			}
+		}()
+	}
+
+	for _, podData := range podDatas {
+		work <- podData
+	}
+	close(work)
+
+	wg.Wait()
+	close(results)
+
+	for sandbox := range results {
+		sandboxes = append(sandboxes, sandbox)
 	}
 
 	glog.V(1).Infof("ListPodSandbox: len of sandboxes returning = %v", len(sandboxes))
@@ -184,11 +534,16 @@ func (m *Manager) filter(podData *common.PodData, reqFilter *kubeapi.PodSandboxF
 	return sandbox, true
 }
 
-func (m *Manager) preCreateContainer(data *common.PodData, req *kubeapi.CreateContainerRequest) error {
+func (m *Manager) preCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest) error {
 	data.RLock()
 	defer data.RUnlock()
 
-	return m.podProvider.PreCreateContainer(data, req, m.contProvider.ImageStatus)
+	// i.e. the aws and gcp image providers would boot a VM for it here
+	if isVMless(data) {
+		return nil
+	}
+
+	return m.podProvider.PreCreateContainer(ctx, data, req, m.contProvider.ImageStatus)
 }
 
 func isReadOnly(opts string) bool {
@@ -204,8 +559,8 @@ func isReadOnly(opts string) bool {
 	return ret
 }
 
-func (m *Manager) createContainer(podData *common.PodData, req *kubeapi.CreateContainerRequest) (*kubeapi.CreateContainerResponse, error) {
-	if err := m.preCreateContainer(podData, req); err != nil {
+func (m *Manager) createContainer(ctx context.Context, podData *common.PodData, req *kubeapi.CreateContainerRequest) (*kubeapi.CreateContainerResponse, error) {
+	if err := m.preCreateContainer(ctx, podData, req); err != nil {
 		return nil, fmt.Errorf("CreateContainer: %v", err)
 	}
 
@@ -260,7 +615,37 @@ func (m *Manager) createContainer(podData *common.PodData, req *kubeapi.CreateCo
 		}
 	}
 
-	return client.CreateContainer(req)
+	var resp *kubeapi.CreateContainerResponse
+	sent := false
+	err = callWithRetry(podData, client, containerCallAttempts, containerCallBackoff, func() (err error) {
+		// creating containers isn't idempotent, a retry must not make a second one
+		if sent {
+			id, err := existingContainer(client, req)
+			if err != nil {
+				return err
+			}
+			if id != "" {
+				glog.Infof("createContainer: %v was created by the attempt that failed", id)
+				resp = &kubeapi.CreateContainerResponse{ContainerId: id}
+				return nil
+			}
+		}
+
+		sent = true
+		resp, err = client.CreateContainer(req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// every later call on the container finds its VM through the sandbox id in the container's id, they'd go to another
+	// pod's VM (or nowhere) if the vmserver didn't put ours there
+	if sandboxId, _, err := icommon.ParseContainer(resp.GetContainerId()); err != nil || sandboxId != req.GetPodSandboxId() {
+		return nil, fmt.Errorf("createContainer: vmserver returned container id %q, not one of sandbox %v", resp.GetContainerId(), req.GetPodSandboxId())
+	}
+
+	return resp, nil
 }
 
 func isFlexVolMnt(mount string, mounts map[string]string) (string, bool) {
@@ -282,10 +667,61 @@ func isFlexVolMnt(mount string, mounts map[string]string) (string, bool) {
 func (m *Manager) listContainers(req *kubeapi.ListContainersRequest) (*kubeapi.ListContainersResponse, error) {
 	results := []*kubeapi.Container{}
 
-	for _, podData := range m.copyVMMap() {
-		if containers, ok := listSandbox(req, podData); ok {
-			results = append(results, containers...)
+	filter := req.GetFilter()
+
+	// the kubelet mostly asks about a single pod, which only needs that pod's VM, as does a container id
+	sandboxId := filter.GetPodSandboxId()
+	if id := filter.GetId(); id != "" {
+		podId, _, err := icommon.ParseContainer(id)
+		if err != nil || (sandboxId != "" && sandboxId != podId) {
+			return &kubeapi.ListContainersResponse{Containers: results}, nil
 		}
+		sandboxId = podId
+	}
+
+	if sandboxId != "" {
+		if podData, err := m.getPodData(sandboxId); err == nil {
+			if containers, ok := listSandbox(req, podData); ok {
+				results = filterContainers(containers, filter)
+			}
+		}
+
+		return &kubeapi.ListContainersResponse{Containers: results}, nil
+	}
+
+	podDatas := m.copyVMMap()
+
+	// ask the VMs in parallel, so a slow or dead one only holds up its own part of the list
+	type work struct {
+		id      string
+		podData *common.PodData
+	}
+	works := make(chan work)
+	lists := make(chan []*kubeapi.Container, len(podDatas))
+
+	var wg sync.WaitGroup
+	for i := 0; i < listContainersWorkers && i < len(podDatas); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range works {
+				if containers, ok := listSandbox(m.ownContainers(req, w.id), w.podData); ok {
+					lists <- containers
+				}
+			}
+		}()
+	}
+
+	for id, podData := range podDatas {
+		works <- work{id: id, podData: podData}
+	}
+	close(works)
+
+	wg.Wait()
+	close(lists)
+
+	for containers := range lists {
+		results = append(results, filterContainers(containers, filter)...)
 	}
 
 	resp := &kubeapi.ListContainersResponse{
@@ -295,6 +731,36 @@ func (m *Manager) listContainers(req *kubeapi.ListContainersRequest) (*kubeapi.L
 	return resp, nil
 }
 
+// filterContainers drops the containers that don't match filter's state and labels.  vmserver filters too, but not
+// every container provider in it can do so exactly (i.e. docker has no status for CONTAINER_UNKNOWN).
+func filterContainers(containers []*kubeapi.Container, filter *kubeapi.ContainerFilter) []*kubeapi.Container {
+	if filter == nil {
+		return containers
+	}
+
+	matched := []*kubeapi.Container{}
+	for _, c := range containers {
+		if filter.State != nil && c.GetState() != filter.GetState().GetState() {
+			continue
+		}
+
+		labelsMatch := true
+		for key, val := range filter.GetLabelSelector() {
+			if c.GetLabels()[key] != val {
+				labelsMatch = false
+				break
+			}
+		}
+		if !labelsMatch {
+			continue
+		}
+
+		matched = append(matched, c)
+	}
+
+	return matched
+}
+
 func listSandbox(req *kubeapi.ListContainersRequest, podData *common.PodData) ([]*kubeapi.Container, bool) {
 	podData.RLock()
 	defer podData.RUnlock()
@@ -312,15 +778,203 @@ func listSandbox(req *kubeapi.ListContainersRequest, podData *common.PodData) ([
 		return nil, false
 	}
 
-	resp, err := client.ListContainers(req)
+	var resp *kubeapi.ListContainersResponse
+	err := callWithReconnect(client, func() (err error) {
+		resp, err = client.ListContainers(req)
+		return err
+	})
 	if err != nil {
-		glog.Warningf("listContainers: grpc ListContainers failed: %v", err)
+		glog.Warningf("listContainers: skipping %v, grpc ListContainers failed: %v", podData.Id, err)
 		return nil, false
 	}
 
 	return resp.Containers, true
 }
 
+func (m *Manager) listContainerStats(req *kubeapi.ListContainerStatsRequest) (*kubeapi.ListContainerStatsResponse, error) {
+	results := []*kubeapi.ContainerStats{}
+
+	filter := req.GetFilter()
+	unfiltered := filter.GetId() == "" && filter.GetPodSandboxId() == "" && len(filter.GetLabelSelector()) == 0
+
+	// a container id names its sandbox, there is no need to ask every other one
+	if id := filter.GetId(); id != "" {
+		podId, _, err := icommon.ParseContainer(id)
+		if err != nil || (filter.GetPodSandboxId() != "" && filter.GetPodSandboxId() != podId) {
+			return &kubeapi.ListContainerStatsResponse{Stats: results}, nil
+		}
+
+		narrowed := *filter
+		narrowed.PodSandboxId = podId
+		req = &kubeapi.ListContainerStatsRequest{Filter: &narrowed}
+	}
+
+	now := time.Now()
+	for id, podData := range m.copyVMMap() {
+		if stats, ok := sandboxStats(m.ownContainerStats(req, id), podData); ok {
+			for _, s := range stats {
+				m.recordCPU(id, s, now)
+			}
+			results = append(results, stats...)
+		}
+	}
+
+	// only a full list tells which containers are gone
+	var listed map[string]bool
+	if unfiltered {
+		listed = make(map[string]bool)
+		for _, s := range results {
+			listed[s.GetAttributes().GetId()] = true
+		}
+	}
+	m.pruneCPUSamples(listed, now)
+
+	resp := &kubeapi.ListContainerStatsResponse{
+		Stats: results,
+	}
+
+	return resp, nil
+}
+
+func sandboxStats(req *kubeapi.ListContainerStatsRequest, podData *common.PodData) ([]*kubeapi.ContainerStats, bool) {
+	podData.RLock()
+	defer podData.RUnlock()
+
+	sandboxId := ""
+	if req.Filter != nil {
+		sandboxId = req.Filter.GetPodSandboxId()
+	}
+	if sandboxId != "" && sandboxId != podData.Id {
+		return nil, false
+	}
+
+	client := podData.Client
+	if client == nil { // This sandbox has been removed
+		return nil, false
+	}
+
+	var resp *kubeapi.ListContainerStatsResponse
+	err := callWithReconnect(client, func() (err error) {
+		resp, err = client.ListContainerStats(req)
+		return err
+	})
+	if err != nil {
+		glog.Warningf("listContainerStats: grpc ListContainerStats failed for %v: %v", podData.Id, err)
+		return nil, false
+	}
+
+	return resp.Stats, true
+}
+
+// callWithReconnect runs call and, if the vmserver couldn't be reached (i.e. the VM rebooted or the network blipped),
+// redials the client at the pod's ip and runs it once more
+func callWithReconnect(client common.Client, call func() error) error {
+	return callWithRetry(nil, client, 2, 0, call)
+}
+
+// callWithRetry is callWithReconnect for up to attempts tries, waiting backoff (doubled every time) before each
+// reconnect.  Any error but Unavailable is returned right away, the vmserver got the call and said no.  Unless podData is
+// nil it has to be read locked by the caller, and is unlocked while backing off and reconnecting so stopping or removing
+// the pod isn't held up, failing the call if the pod was removed meanwhile.
+func callWithRetry(podData *common.PodData, client common.Client, attempts int, backoff time.Duration, call func() error) error {
+	err := call()
+	for attempt := 1; attempt < attempts && grpc.Code(err) == codes.Unavailable; attempt++ {
+		glog.Warningf("callWithRetry: vmserver at %v unavailable (%v), reconnecting for attempt %d of %d", client.IP(), err, attempt+1, attempts)
+
+		if podData != nil {
+			podData.RUnlock()
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+
+		rerr := client.Reconnect()
+
+		if podData != nil {
+			podData.RLock()
+			if podData.Client != client {
+				// the remove closed the client before we reconnected it, or while we were
+				client.Close()
+				return fmt.Errorf("callWithRetry: sandbox %v was removed while reconnecting to its vmserver", podData.Id)
+			}
+		}
+
+		if rerr != nil {
+			glog.Warningf("callWithRetry: %v", rerr)
+			continue
+		}
+
+		err = call()
+	}
+
+	return err
+}
+
+// existingContainer returns the id of the container of req's sandbox with req's metadata, "" if there isn't one.  A
+// CreateContainer that failed with Unavailable may have been made by the vmserver anyway, i.e. when the connection went
+// away before the response came back.
+func existingContainer(client common.Client, req *kubeapi.CreateContainerRequest) (string, error) {
+	resp, err := client.ListContainers(&kubeapi.ListContainersRequest{
+		Filter: &kubeapi.ContainerFilter{PodSandboxId: req.GetPodSandboxId()},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	meta := req.GetConfig().GetMetadata()
+	for _, c := range resp.GetContainers() {
+		if c.GetMetadata().GetName() == meta.GetName() && c.GetMetadata().GetAttempt() == meta.GetAttempt() {
+			return c.GetId(), nil
+		}
+	}
+
+	return "", nil
+}
+
+// updatePodCIDR keeps the pod CIDR the kubelet sent, once the pod provider (if it wants it) has accepted it, so a CIDR
+// it failed on is handed to it again when the kubelet retries
+func (m *Manager) updatePodCIDR(cidr string) error {
+	// the kubelet sends an empty CIDR until the node has been assigned one
+	if cidr == "" {
+		return nil
+	}
+
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return fmt.Errorf("updatePodCIDR: invalid pod CIDR %q: %v", cidr, err)
+	}
+
+	m.podCIDRLock.Lock()
+	defer m.podCIDRLock.Unlock()
+
+	if cidr == m.podCIDR {
+		return nil
+	}
+
+	if p, ok := m.podProvider.(provider.PodCIDRUpdater); ok {
+		if err := p.UpdatePodCIDR(cidr); err != nil {
+			return fmt.Errorf("updatePodCIDR: %v pod provider couldn't use %v: %v", *flags.PodProvider, cidr, err)
+		}
+	}
+
+	m.podCIDR = cidr
+	glog.Infof("updatePodCIDR: pod CIDR is now %v", cidr)
+
+	return nil
+}
+
+func (m *Manager) capabilities() provider.Capabilities {
+	if reporter, ok := m.podProvider.(provider.CapabilityReporter); ok {
+		return reporter.Capabilities()
+	}
+
+	return provider.AllCapabilities
+}
+
+// unsupported is the error for an operation the pod provider's capabilities say it can't do
+func unsupported(op string) error {
+	return grpc.Errorf(codes.Unimplemented, "%v: not supported by the %v pod provider", op, *flags.PodProvider)
+}
+
 /* Must be at least holding the vmmap RLock */
 func (m *Manager) getPodData(id string) (*common.PodData, error) {
 	m.vmMapLock.RLock()
@@ -340,16 +994,35 @@ func (m *Manager) getClient(podName string) (common.Client, error) {
 	return m.getClientLocked(podName)
 }
 
+// getClientLocked expects vmMapLock to already be held, so it can't go through getPodData, which would take it again
+// and deadlock against a writer waiting between the two
 func (m *Manager) getClientLocked(podName string) (common.Client, error) {
-	podData, err := m.getPodData(podName)
-
-	if err != nil {
+	podData, ok := m.vmMap[podName]
+	if !ok {
 		return nil, fmt.Errorf("%v unknown pod name", podName)
 	}
 
 	return podData.Client, nil
 }
 
+// getIP returns the pod's ip.  Providers hand their pods to the manager rather than keeping them, so this lives here
+// instead of on every PodProvider.
+func (m *Manager) getIP(podId string) (string, error) {
+	podData, err := m.getPodData(podId)
+	if err != nil {
+		return "", err
+	}
+
+	podData.RLock()
+	defer podData.RUnlock()
+
+	if podData.Client == nil {
+		return "", fmt.Errorf("%v has been removed", podId)
+	}
+
+	return podData.Ip, nil
+}
+
 func (m *Manager) getVMList() []string {
 	m.vmMapLock.RLock()
 	defer m.vmMapLock.RUnlock()
@@ -375,6 +1048,21 @@ func (m *Manager) copyVMMap() map[string]*common.PodData {
 	return ret
 }
 
+func (m *Manager) podVolumes(uuid string) []*types.Volume {
+	m.mountMapLock.Lock()
+	defer m.mountMapLock.Unlock()
+
+	return m.volumeMap[uuid]
+}
+
+func (m *Manager) forgetVolumes(uuid string) {
+	m.mountMapLock.Lock()
+	defer m.mountMapLock.Unlock()
+
+	delete(m.volumeMap, uuid)
+}
+
+/* Expect the pod's lock to already be taken */
 func (m *Manager) updatePodState(data *common.PodData) {
 	if data.Booted {
 		data.UpdatePodState()
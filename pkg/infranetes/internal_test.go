@@ -0,0 +1,482 @@
+package infranetes
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/fake"
+	"github.com/apporbit/infranetes/pkg/infranetes/types"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// newTestManager is newManager without the grpc server, the state file or the sandboxes ListInstances() would import
+func newTestManager(podProvider provider.PodProvider) *Manager {
+	return &Manager{
+		podProvider:  podProvider,
+		vmMap:        make(map[string]*common.PodData),
+		sandboxIndex: make(map[string]string),
+		creating:     make(map[string]chan struct{}),
+		colocated:    make(map[string]*sharedVM),
+		vmOf:         make(map[string]*sharedVM),
+		volumeMap:    make(map[string][]*types.Volume),
+		mountMap:     make(map[string]string),
+		saved:        make(map[string][]byte),
+		cpuSamples:   make(map[string]*cpuSample),
+		log:          glogLogger{},
+	}
+}
+
+func newFakeProvider(t *testing.T) provider.PodProvider {
+	podProvider, err := fake.NewFakePodProvider()
+	if err != nil {
+		t.Fatalf("NewFakePodProvider failed: %v", err)
+	}
+
+	return podProvider
+}
+
+func runRequest(uid string, attempt uint32) *kubeapi.RunPodSandboxRequest {
+	return &kubeapi.RunPodSandboxRequest{
+		Config: &kubeapi.PodSandboxConfig{
+			Metadata: &kubeapi.PodSandboxMetadata{
+				Name:      "pod",
+				Namespace: "default",
+				Uid:       uid,
+				Attempt:   attempt,
+			},
+		},
+	}
+}
+
+// createTestSandbox creates a sandbox for a new pod, failing the test if it can't
+func createTestSandbox(t *testing.T, m *Manager, uid string) string {
+	resp, err := m.createSandbox(context.Background(), runRequest(uid, 0))
+	if err != nil {
+		t.Fatalf("createSandbox failed: %v", err)
+	}
+
+	return resp.PodSandboxId
+}
+
+func TestCreateSandboxDedup(t *testing.T) {
+	podProvider := newFakeProvider(t)
+	m := newTestManager(podProvider)
+
+	var (
+		lock    sync.Mutex
+		runs    int
+		started = make(chan struct{}, 2)
+		release = make(chan struct{})
+	)
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{
+		RunPodSandbox: func(req *kubeapi.RunPodSandboxRequest) error {
+			lock.Lock()
+			runs++
+			lock.Unlock()
+
+			started <- struct{}{}
+			<-release
+			return nil
+		},
+	})
+
+	type result struct {
+		id  string
+		err error
+	}
+	results := make(chan result, 2)
+	create := func() {
+		resp, err := m.createSandbox(context.Background(), runRequest("uid", 0))
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		results <- result{id: resp.PodSandboxId}
+	}
+
+	go create()
+	<-started
+	// the kubelet's retry of a RunPodSandbox that is still booting
+	go create()
+	close(release)
+
+	first, second := <-results, <-results
+	if first.err != nil || second.err != nil {
+		t.Fatalf("createSandbox failed: %v, %v", first.err, second.err)
+	}
+	if first.id != second.id {
+		t.Errorf("retried createSandbox returned %v, expected %v", second.id, first.id)
+	}
+
+	// once created, a retry gets it right away
+	if id := createTestSandbox(t, m, "uid"); id != first.id {
+		t.Errorf("createSandbox of an existing sandbox returned %v, expected %v", id, first.id)
+	}
+
+	if runs != 1 {
+		t.Errorf("provider's RunPodSandbox was called %d times, expected 1", runs)
+	}
+
+	// a new attempt at the pod is a new sandbox
+	resp, err := m.createSandbox(context.Background(), runRequest("uid", 1))
+	if err != nil {
+		t.Fatalf("createSandbox of attempt 1 failed: %v", err)
+	}
+	if resp.PodSandboxId == first.id {
+		t.Errorf("attempt 1 got the sandbox of attempt 0")
+	}
+}
+
+func TestCreateSandboxRetryAfterFailure(t *testing.T) {
+	podProvider := newFakeProvider(t)
+	m := newTestManager(podProvider)
+
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{
+		RunPodSandbox: func(req *kubeapi.RunPodSandboxRequest) error {
+			return errors.New("no capacity")
+		},
+	})
+
+	if _, err := m.createSandbox(context.Background(), runRequest("uid", 0)); err == nil {
+		t.Fatalf("createSandbox succeeded with a failing provider")
+	}
+
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{})
+
+	// the failed attempt doesn't leave the retry waiting for it
+	id := createTestSandbox(t, m, "uid")
+	if _, err := m.getPodData(id); err != nil {
+		t.Errorf("retried sandbox isn't known: %v", err)
+	}
+}
+
+func TestCreateSandboxPanic(t *testing.T) {
+	podProvider := newFakeProvider(t)
+	m := newTestManager(podProvider)
+
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{
+		RunPodSandbox: func(req *kubeapi.RunPodSandboxRequest) error {
+			panic("provider bug")
+		},
+	})
+
+	// what recoverUnary does for the grpc server
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("createSandbox didn't panic")
+			}
+		}()
+		m.createSandbox(context.Background(), runRequest("uid", 0))
+	}()
+
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{})
+
+	// the retry doesn't wait on the attempt that panicked
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := m.createSandbox(ctx, runRequest("uid", 0)); err != nil {
+		t.Errorf("createSandbox after a panic failed: %v", err)
+	}
+}
+
+func TestStopSandboxProviderFailure(t *testing.T) {
+	podProvider := newFakeProvider(t)
+	m := newTestManager(podProvider)
+
+	id := createTestSandbox(t, m, "uid")
+
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{
+		StopPodSandbox: func(podData *common.PodData) error {
+			return errors.New("stop failed")
+		},
+	})
+
+	if _, err := m.stopSandbox(context.Background(), &kubeapi.StopPodSandboxRequest{PodSandboxId: id}); err == nil {
+		t.Errorf("stopSandbox succeeded with a failing provider")
+	}
+}
+
+func TestRemoveSandboxDestroyFailure(t *testing.T) {
+	podProvider := newFakeProvider(t)
+	m := newTestManager(podProvider)
+
+	id := createTestSandbox(t, m, "uid")
+
+	destroyErr := errors.New("destroy failed")
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{
+		Destroy: func(podId string) error {
+			return destroyErr
+		},
+	})
+
+	if err := m.removePodSandbox(&kubeapi.RemovePodSandboxRequest{PodSandboxId: id}); err == nil {
+		t.Fatalf("removePodSandbox succeeded though the VM couldn't be destroyed")
+	}
+
+	// kept, so the VM isn't lost track of and the kubelet's retry can get it
+	podData, err := m.getPodData(id)
+	if err != nil {
+		t.Fatalf("sandbox whose VM couldn't be destroyed was removed: %v", err)
+	}
+	if podData.Client == nil {
+		t.Errorf("sandbox whose VM couldn't be destroyed had its client closed")
+	}
+
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{})
+
+	if err := m.removePodSandbox(&kubeapi.RemovePodSandboxRequest{PodSandboxId: id}); err != nil {
+		t.Fatalf("retried removePodSandbox failed: %v", err)
+	}
+	if _, err := m.getPodData(id); err == nil {
+		t.Errorf("sandbox is still known after being removed")
+	}
+}
+
+func TestRemoveSandboxDestroyFailureVMGone(t *testing.T) {
+	podProvider := newFakeProvider(t)
+	m := newTestManager(podProvider)
+
+	id := createTestSandbox(t, m, "uid")
+
+	podProvider.(fake.Controller).SetHooks(fake.Hooks{
+		Destroy: func(podId string) error {
+			return errors.New("instance not found")
+		},
+	})
+	if err := podProvider.(fake.Controller).DeleteVM(id); err != nil {
+		t.Fatalf("DeleteVM failed: %v", err)
+	}
+
+	// the provider confirms the VM is gone, so the failure doesn't matter
+	if err := m.removePodSandbox(&kubeapi.RemovePodSandboxRequest{PodSandboxId: id}); err != nil {
+		t.Fatalf("removePodSandbox failed though the VM is gone: %v", err)
+	}
+	if _, err := m.getPodData(id); err == nil {
+		t.Errorf("sandbox is still known after being removed")
+	}
+}
+
+// orphanProvider is the fake provider as a provider.OrphanRemover
+type orphanProvider struct {
+	provider.PodProvider
+
+	found bool
+	err   error
+	ids   []string
+}
+
+func (p *orphanProvider) RemoveOrphan(id string) (bool, error) {
+	p.ids = append(p.ids, id)
+
+	return p.found, p.err
+}
+
+func TestRemoveUnknownSandbox(t *testing.T) {
+	req := &kubeapi.RemovePodSandboxRequest{PodSandboxId: "unknown"}
+
+	m := newTestManager(newFakeProvider(t))
+	if err := m.removePodSandbox(req); err != nil {
+		t.Errorf("removePodSandbox of an unknown sandbox failed without an OrphanRemover: %v", err)
+	}
+
+	for _, test := range []struct {
+		found   bool
+		err     error
+		wantErr bool
+	}{
+		{found: false},
+		{found: true},
+		{err: errors.New("terminate failed"), wantErr: true},
+	} {
+		remover := &orphanProvider{PodProvider: newFakeProvider(t), found: test.found, err: test.err}
+		m := newTestManager(remover)
+
+		err := m.removePodSandbox(req)
+		if test.wantErr && err == nil {
+			t.Errorf("removePodSandbox succeeded though RemoveOrphan failed")
+		} else if !test.wantErr && err != nil {
+			t.Errorf("removePodSandbox failed with found = %v: %v", test.found, err)
+		}
+
+		if len(remover.ids) != 1 || remover.ids[0] != req.PodSandboxId {
+			t.Errorf("RemoveOrphan was called with %v, expected [%v]", remover.ids, req.PodSandboxId)
+		}
+	}
+}
+
+// reconnectClient counts Reconnect()s and Close()s, onReconnect is called by the former
+type reconnectClient struct {
+	common.Client
+
+	reconnects  int
+	closes      int
+	onReconnect func()
+}
+
+func (c *reconnectClient) Reconnect() error {
+	c.reconnects++
+	if c.onReconnect != nil {
+		c.onReconnect()
+	}
+
+	return nil
+}
+
+func (c *reconnectClient) Close() {
+	c.closes++
+}
+
+func newReconnectClient(t *testing.T) *reconnectClient {
+	client, err := common.CreateFakeClient()
+	if err != nil {
+		t.Fatalf("CreateFakeClient failed: %v", err)
+	}
+
+	return &reconnectClient{Client: client}
+}
+
+// failingCall fails with the given errors, one per call, and succeeds after
+func failingCall(calls *int, errs ...error) func() error {
+	return func() error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}
+}
+
+func TestCallWithRetryUnavailable(t *testing.T) {
+	unavailable := grpc.Errorf(codes.Unavailable, "connection refused")
+
+	client := newReconnectClient(t)
+	calls := 0
+	if err := callWithRetry(nil, client, 3, 0, failingCall(&calls, unavailable, unavailable)); err != nil {
+		t.Errorf("callWithRetry failed: %v", err)
+	}
+	if calls != 3 || client.reconnects != 2 {
+		t.Errorf("callWithRetry made %d calls and %d reconnects, expected 3 and 2", calls, client.reconnects)
+	}
+
+	// out of attempts
+	client = newReconnectClient(t)
+	calls = 0
+	if err := callWithRetry(nil, client, 2, 0, failingCall(&calls, unavailable, unavailable)); grpc.Code(err) != codes.Unavailable {
+		t.Errorf("callWithRetry returned %v, expected the Unavailable error", err)
+	}
+	if calls != 2 {
+		t.Errorf("callWithRetry made %d calls, expected 2", calls)
+	}
+}
+
+func TestCallWithRetryOtherError(t *testing.T) {
+	client := newReconnectClient(t)
+	calls := 0
+
+	err := callWithRetry(nil, client, 3, 0, failingCall(&calls, grpc.Errorf(codes.InvalidArgument, "bad container config")))
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("callWithRetry returned %v, expected the InvalidArgument error", err)
+	}
+	if calls != 1 || client.reconnects != 0 {
+		t.Errorf("callWithRetry made %d calls and %d reconnects, expected 1 and none", calls, client.reconnects)
+	}
+}
+
+func TestCallWithRetryPodRemoved(t *testing.T) {
+	client := newReconnectClient(t)
+	podData := common.NewPodData(nil, "pod", &kubeapi.PodSandboxMetadata{}, nil, nil, "", nil, client, true, nil)
+
+	// removing the pod needs its write lock, which callWithRetry mustn't hold while reconnecting
+	client.onReconnect = func() {
+		podData.Lock()
+		podData.Client = nil
+		podData.Unlock()
+	}
+
+	calls := 0
+
+	podData.RLock()
+	err := callWithRetry(podData, client, 3, 0, failingCall(&calls, grpc.Errorf(codes.Unavailable, "connection refused")))
+	podData.RUnlock()
+
+	if err == nil {
+		t.Errorf("callWithRetry succeeded though the pod was removed")
+	}
+	if calls != 1 {
+		t.Errorf("callWithRetry made %d calls, expected 1", calls)
+	}
+	if client.closes != 1 {
+		t.Errorf("callWithRetry closed the client %d times, expected once", client.closes)
+	}
+}
+
+func TestUpdatePodCIDR(t *testing.T) {
+	m := newTestManager(newFakeProvider(t))
+
+	if err := m.updatePodCIDR("10.1.2.0/24"); err != nil {
+		t.Fatalf("updatePodCIDR failed: %v", err)
+	}
+
+	podData, err := m.getPodData(createTestSandbox(t, m, "uid"))
+	if err != nil {
+		t.Fatalf("new sandbox isn't known: %v", err)
+	}
+	if !strings.HasPrefix(podData.Ip, "10.1.2.") {
+		t.Errorf("sandbox got ip %v, expected one from the pod CIDR", podData.Ip)
+	}
+
+	if err := m.updatePodCIDR("bogus"); err == nil {
+		t.Errorf("updatePodCIDR accepted an invalid CIDR")
+	}
+}
+
+// cidrProvider is the fake provider as a provider.PodCIDRUpdater that fails with err
+type cidrProvider struct {
+	provider.PodProvider
+
+	err   error
+	cidrs []string
+}
+
+func (p *cidrProvider) UpdatePodCIDR(cidr string) error {
+	p.cidrs = append(p.cidrs, cidr)
+
+	return p.err
+}
+
+func TestUpdatePodCIDRProviderFailure(t *testing.T) {
+	updater := &cidrProvider{PodProvider: newFakeProvider(t), err: errors.New("route failed")}
+	m := newTestManager(updater)
+
+	if err := m.updatePodCIDR("10.1.2.0/24"); err == nil {
+		t.Fatalf("updatePodCIDR succeeded though the provider failed")
+	}
+
+	// the kubelet's retry with the same CIDR has to reach the provider again
+	updater.err = nil
+	if err := m.updatePodCIDR("10.1.2.0/24"); err != nil {
+		t.Fatalf("retried updatePodCIDR failed: %v", err)
+	}
+	if len(updater.cidrs) != 2 {
+		t.Errorf("provider's UpdatePodCIDR was called %d times, expected 2", len(updater.cidrs))
+	}
+
+	// once accepted, it isn't handed over again
+	if err := m.updatePodCIDR("10.1.2.0/24"); err != nil {
+		t.Fatalf("updatePodCIDR of the same CIDR failed: %v", err)
+	}
+	if len(updater.cidrs) != 2 {
+		t.Errorf("provider's UpdatePodCIDR was called %d times for an unchanged CIDR, expected 2", len(updater.cidrs))
+	}
+}
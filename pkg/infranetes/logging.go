@@ -0,0 +1,144 @@
+package infranetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+
+	icommon "github.com/apporbit/infranetes/pkg/common"
+)
+
+// opLogger logs the request and response of each Manager RPC.  The cookie ties the two together.
+type opLogger interface {
+	Request(level glog.Level, op string, cookie int, req interface{})
+	Response(level glog.Level, op string, cookie int, req interface{}, resp interface{}, err error)
+}
+
+// lastCookie is the cookie handed out by the last nextCookie() call
+var lastCookie int64
+
+// nextCookie returns the cookie of a new RPC.  They count up from 1, so the order calls came in can be read off the
+// logs, and unlike rand.Int() they can't collide.
+func nextCookie() int {
+	return int(atomic.AddInt64(&lastCookie, 1))
+}
+
+// logPrefix is what the lines logged for an RPC start with, so a pod's calls can be grepped out of the log, i.e.
+// "42: CreateContainer [pod-id]: "
+func logPrefix(op string, cookie int, podId string) string {
+	if podId == "" {
+		return fmt.Sprintf("%d: %s: ", cookie, op)
+	}
+
+	return fmt.Sprintf("%d: %s [%s]: ", cookie, op, podId)
+}
+
+// podIdOf returns the sandbox id of the first of msgs that has one, directly or as part of a container id
+func podIdOf(msgs ...interface{}) string {
+	for _, msg := range msgs {
+		if m, ok := msg.(interface {
+			GetPodSandboxId() string
+		}); ok && m.GetPodSandboxId() != "" {
+			return m.GetPodSandboxId()
+		}
+		if m, ok := msg.(interface {
+			GetContainerId() string
+		}); ok && m.GetContainerId() != "" {
+			if podId, _, err := icommon.ParseContainer(m.GetContainerId()); err == nil {
+				return podId
+			}
+		}
+	}
+
+	return ""
+}
+
+func newOpLogger(format string) (opLogger, error) {
+	switch format {
+	case "", "glog":
+		return glogLogger{}, nil
+	case "json":
+		return &jsonLogger{out: os.Stderr}, nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %q, must be glog or json", format)
+}
+
+type glogLogger struct{}
+
+func (glogLogger) Request(level glog.Level, op string, cookie int, req interface{}) {
+	glog.V(level).Infof("%sreq = %+v", logPrefix(op, cookie, podIdOf(req)), req)
+}
+
+func (glogLogger) Response(level glog.Level, op string, cookie int, req interface{}, resp interface{}, err error) {
+	glog.V(level).Infof("%sresp = %+v, err = %v", logPrefix(op, cookie, podIdOf(req, resp)), resp, err)
+}
+
+// jsonLogger writes one JSON object per line so log aggregators can index the fields
+type jsonLogger struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+type logRecord struct {
+	Time        string      `json:"time"`
+	Op          string      `json:"op"`
+	Cookie      int         `json:"cookie"`
+	PodId       string      `json:"pod_id,omitempty"`
+	ContainerId string      `json:"container_id,omitempty"`
+	Req         interface{} `json:"req,omitempty"`
+	Resp        interface{} `json:"resp,omitempty"`
+	Err         string      `json:"err,omitempty"`
+}
+
+func (l *jsonLogger) Request(level glog.Level, op string, cookie int, req interface{}) {
+	if !glog.V(level) {
+		return
+	}
+
+	l.write(&logRecord{Op: op, Cookie: cookie, Req: req}, req)
+}
+
+func (l *jsonLogger) Response(level glog.Level, op string, cookie int, req interface{}, resp interface{}, err error) {
+	if !glog.V(level) {
+		return
+	}
+
+	record := &logRecord{Op: op, Cookie: cookie, Resp: resp}
+	if err != nil {
+		record.Err = err.Error()
+	}
+
+	l.write(record, req, resp)
+}
+
+// write fills in the ids from the first of msgs that has them, e.g. the response's id for RunPodSandbox
+func (l *jsonLogger) write(record *logRecord, msgs ...interface{}) {
+	record.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	record.PodId = podIdOf(msgs...)
+	for _, msg := range msgs {
+		if m, ok := msg.(interface {
+			GetContainerId() string
+		}); ok && record.ContainerId == "" {
+			record.ContainerId = m.GetContainerId()
+		}
+	}
+
+	buf, err := json.Marshal(record)
+	if err != nil {
+		glog.Warningf("jsonLogger: couldn't marshal log record for %v: %v", record.Op, err)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.out.Write(append(buf, '\n'))
+}
@@ -3,18 +3,21 @@ package infranetes
 import (
 	"errors"
 	"fmt"
-	"math/rand"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
 	icommon "github.com/apporbit/infranetes/pkg/common"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
@@ -34,37 +37,115 @@ type Manager struct {
 
 	vmMap     map[string]*common.PodData //maps internal pod sandbox id to PodData
 	vmMapLock sync.RWMutex
-
+	// also guarded by vmMapLock, see createSandbox()
+	sandboxIndex map[string]string        // sandboxKey() to pod sandbox id
+	creating     map[string]chan struct{} // sandboxKey() of sandboxes being created, closed once done
+	// also guarded by vmMapLock, see colocate.go
+	colocated map[string]*sharedVM // colocation group to its VM
+	vmOf      map[string]*sharedVM // pod sandbox id to the shared VM it is on, its containers are there too
+
+	// mountMapLock guards volumeMap too
 	mountMap     map[string]string
 	mountMapLock sync.Mutex
 	volumeMap    map[string][]*types.Volume
+
+	serving int32 // set atomically once the grpc server is accepting connections
+
+	// nil if sandboxes aren't persisted.  stateLock guards saved, the last record written for each sandbox.
+	store     common.StateStore
+	stateLock sync.Mutex
+	saved     map[string][]byte
+
+	log opLogger
+
+	// last CPU samples of containers by id, see stats.go
+	cpuSamples    map[string]*cpuSample
+	cpuSampleLock sync.Mutex
+
+	podCIDRLock sync.Mutex
+	podCIDR     string
+
+	// cached result of the last pod provider HealthCheck(), see healthCheck()
+	healthLock    sync.Mutex
+	healthChecked time.Time
+	healthErr     error
+
+	tls bool
 }
 
 func NewInfranetesManager(podProvider provider.PodProvider, contProvider provider.ImageProvider) (*Manager, error) {
+	return newManager(podProvider, contProvider)
+}
+
+// NewInfranetesManagerTLS is NewInfranetesManager for a server that isn't only reachable through a unix socket.  If caFile
+// is set, clients have to present a certificate signed by it.
+func NewInfranetesManagerTLS(podProvider provider.PodProvider, contProvider provider.ImageProvider, certFile, keyFile, caFile string) (*Manager, error) {
+	creds, err := serverTLSCreds(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	manager, err := newManager(podProvider, contProvider, grpc.Creds(creds))
+	if err != nil {
+		return nil, err
+	}
+	manager.tls = true
+
+	return manager, nil
+}
+
+func newManager(podProvider provider.PodProvider, contProvider provider.ImageProvider, opts ...grpc.ServerOption) (*Manager, error) {
+	log, err := newOpLogger(*flags.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, grpc.UnaryInterceptor(recoverUnary), grpc.StreamInterceptor(recoverStream))
+
 	manager := &Manager{
-		server:       grpc.NewServer(),
+		server:       grpc.NewServer(opts...),
 		podProvider:  podProvider,
 		contProvider: contProvider,
 		vmMap:        make(map[string]*common.PodData),
+		sandboxIndex: make(map[string]string),
+		creating:     make(map[string]chan struct{}),
+		colocated:    make(map[string]*sharedVM),
+		vmOf:         make(map[string]*sharedVM),
 		volumeMap:    make(map[string][]*types.Volume),
 		mountMap:     make(map[string]string),
+		saved:        make(map[string][]byte),
+		cpuSamples:   make(map[string]*cpuSample),
+		log:          log,
+	}
+
+	manager.store, err = newStateStore()
+	if err != nil {
+		return nil, err
 	}
 
 	manager.importSandboxes()
+	manager.restoreState()
 
 	manager.registerServer()
+	manager.registerMetrics()
 
 	return manager, nil
 }
 
+// Serve listens on addr, either a unix socket path or host:port
 func (s *Manager) Serve(addr string) error {
 	glog.V(1).Infof("Start infranetes at %s", addr)
 
-	if err := syscall.Unlink(addr); err != nil && !os.IsNotExist(err) {
-		return err
+	network, address := parseListenAddr(addr)
+	if network == "unix" {
+		if err := syscall.Unlink(address); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if !s.tls {
+		glog.Warningf("Serve: listening on %v without TLS, anyone who can reach it controls this node's pods", address)
 	}
 
-	lis, err := net.Listen("unix", addr)
+	lis, err := net.Listen(network, address)
 
 	if err != nil {
 		glog.Fatalf("Failed to listen %s: %v", addr, err)
@@ -72,9 +153,98 @@ func (s *Manager) Serve(addr string) error {
 	}
 
 	defer lis.Close()
+
+	if *flags.MetricsAddr != "" {
+		go s.serveMetrics(*flags.MetricsAddr)
+	}
+
+	if *flags.ReconcileInterval > 0 {
+		go s.reconcile(*flags.ReconcileInterval)
+	}
+
+	atomic.StoreInt32(&s.serving, 1)
+	defer atomic.StoreInt32(&s.serving, 0)
+
 	return s.server.Serve(lis)
 }
 
+// parseListenAddr splits --listen into what net.Listen wants.  Anything that isn't host:port (or explicitly tcp://) is a
+// unix socket path, as it always has been.
+func parseListenAddr(addr string) (string, string) {
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		return "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "unix://"):
+		return "unix", strings.TrimPrefix(addr, "unix://")
+	}
+
+	if !strings.Contains(addr, "/") {
+		if _, port, err := net.SplitHostPort(addr); err == nil && port != "" {
+			return "tcp", addr
+		}
+	}
+
+	return "unix", addr
+}
+
+// Shutdown stops serving and lets the pod provider release anything it holds outside of pods.  Pods themselves are left
+// running so they can be imported again on restart, unless --destroy-on-shutdown is set.
+func (s *Manager) Shutdown() {
+	glog.Infof("Shutting down infranetes")
+
+	s.server.Stop()
+
+	if *flags.DestroyVMs {
+		if err := s.DestroyAll(); err != nil {
+			glog.Warningf("Shutdown: %v", err)
+		}
+	}
+
+	if p, ok := s.podProvider.(provider.Shutdowner); ok {
+		p.Shutdown()
+	}
+}
+
+// DestroyAll removes every pod sandbox the way RemovePodSandbox does, destroying their VMs.  It is meant for when the
+// manager is going away for good, as the kubelet still thinks the pods exist.
+func (s *Manager) DestroyAll() error {
+	podDatas := s.copyVMMap()
+
+	glog.Infof("DestroyAll: removing %d pod sandboxes", len(podDatas))
+
+	work := make(chan string)
+	var failed int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < listPodSandboxWorkers && i < len(podDatas); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range work {
+				if err := s.removePodSandbox(&kubeapi.RemovePodSandboxRequest{PodSandboxId: id}); err != nil {
+					glog.Warningf("DestroyAll: couldn't remove %v: %v", id, err)
+					atomic.AddInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+
+	for id := range podDatas {
+		work <- id
+	}
+	close(work)
+
+	wg.Wait()
+
+	s.saveState()
+
+	if failed > 0 {
+		return fmt.Errorf("DestroyAll: couldn't remove %d of %d pod sandboxes", failed, len(podDatas))
+	}
+
+	return nil
+}
+
 func (s *Manager) registerServer() {
 	kubeapi.RegisterRuntimeServiceServer(s.server, s)
 	kubeapi.RegisterImageServiceServer(s.server, s)
@@ -97,8 +267,8 @@ func (s *Manager) Version(ctx context.Context, req *kubeapi.VersionRequest) (*ku
 }
 
 func (m *Manager) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest) (*kubeapi.RunPodSandboxResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: RunPodSandbox: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "RunPodSandbox", cookie, req)
 	vcpu, err := common.GetCpuLimitFromCgroup(req.GetConfig().GetLinux().GetCgroupParent())
 	if err != nil {
 		glog.Infof("Couldn't parse cpu limits: %v", err)
@@ -113,91 +283,124 @@ func (m *Manager) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxR
 		glog.Infof("MEM Limit = %v", mem)
 	}
 
-	resp, err := m.createSandbox(req)
+	start := time.Now()
+	resp, err := m.createSandbox(ctx, req)
+	m.observe("RunPodSandbox", start, err)
+	if err == nil {
+		m.saveState()
+	}
 
-	glog.Infof("%d: RunPodSandbox: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "RunPodSandbox", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) StopPodSandbox(ctx context.Context, req *kubeapi.StopPodSandboxRequest) (*kubeapi.StopPodSandboxResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: StopPodSandbox: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "StopPodSandbox", cookie, req)
 
-	resp, err := m.stopSandbox(req)
+	start := time.Now()
+	resp, err := m.stopSandbox(ctx, req)
+	m.observe("StopPodSandbox", start, err)
+	if err == nil {
+		m.saveState()
+	}
 
-	glog.Infof("%d: StopPodSandbox: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "StopPodSandbox", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) RemovePodSandbox(ctx context.Context, req *kubeapi.RemovePodSandboxRequest) (*kubeapi.RemovePodSandboxResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: RemovePodSandbox: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "RemovePodSandbox", cookie, req)
 
+	start := time.Now()
 	err := m.removePodSandbox(req)
+	m.observe("RemovePodSandbox", start, err)
+	if err == nil {
+		m.saveState()
+	}
 
 	resp := &kubeapi.RemovePodSandboxResponse{}
 
-	glog.Infof("%d: RemovePodSandbox: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "RemovePodSandbox", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) PodSandboxStatus(ctx context.Context, req *kubeapi.PodSandboxStatusRequest) (*kubeapi.PodSandboxStatusResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: PodSandboxStatus: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "PodSandboxStatus", cookie, req)
 
+	start := time.Now()
 	resp, err := m.podSandboxStatus(req)
+	m.observe("PodSandboxStatus", start, err)
 
-	glog.Infof("%d: PodSandboxStatus: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "PodSandboxStatus", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) ListPodSandbox(ctx context.Context, req *kubeapi.ListPodSandboxRequest) (*kubeapi.ListPodSandboxResponse, error) {
-	cookie := rand.Int()
-	glog.V(1).Infof("%d: ListPodSandbox: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(1, "ListPodSandbox", cookie, req)
 
+	start := time.Now()
 	resp, err := m.listPodSandbox(req)
+	m.observe("ListPodSandbox", start, err)
 
-	glog.V(1).Infof("%d: ListPodSandbox: resp = %+v, err = %v", cookie, resp, nil)
+	m.log.Response(1, "ListPodSandbox", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) CreateContainer(ctx context.Context, req *kubeapi.CreateContainerRequest) (*kubeapi.CreateContainerResponse, error) {
-	glog.Infof("CreateContainer: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "CreateContainer", cookie, req)
 
 	podId := req.GetPodSandboxId()
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("createContainer: failed to get podData for sandbox %v", podId)
+		glog.Infof("%sfailed to get podData", logPrefix("CreateContainer", cookie, podId))
 		return nil, fmt.Errorf("Failed to get client for sandbox %v: %v", podId, err)
 	}
 
-	logpath := filepath.Join(req.GetSandboxConfig().GetLogDirectory(), req.GetConfig().GetLogPath())
-
 	translatedImage, err := m.contProvider.Translate(req.Config.Image)
 	if err != nil {
-		glog.Infof("createContainer: %v", err)
+		glog.Infof("%s%v", logPrefix("CreateContainer", cookie, podId), err)
 		return nil, fmt.Errorf("%v", err)
 	}
 	req.Config.Image.Image = translatedImage
 
-	resp, err := m.createContainer(podData, req)
+	if auther, ok := m.contProvider.(provider.RegistryAuther); ok {
+		if auth := auther.RegistryAuth(translatedImage); auth != "" {
+			if req.Config.Annotations == nil {
+				req.Config.Annotations = make(map[string]string)
+			}
+			req.Config.Annotations[icommon.RegistryAuthAnnotation] = auth
+		}
+	}
 
-	podData.AddContLogPath(resp.GetContainerId(), logpath)
+	start := time.Now()
+	resp, err := m.createContainer(ctx, podData, req)
+	m.observe("CreateContainer", start, err)
 
-	glog.Infof("CreateContainer: resp = %+v, err = %v", resp, err)
+	// without a log path the kubelet isn't expecting logs, i.e. when run by hand with crictl
+	if err == nil && req.GetConfig().GetLogPath() != "" {
+		logpath := filepath.Join(req.GetSandboxConfig().GetLogDirectory(), req.GetConfig().GetLogPath())
+		podData.AddContLogPath(resp.GetContainerId(), logpath)
+	}
+
+	m.log.Response(0, "CreateContainer", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) StartContainer(ctx context.Context, req *kubeapi.StartContainerRequest) (*kubeapi.StartContainerResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: StartContainer: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "StartContainer", cookie, req)
 
 	podId, contId, err := icommon.ParseContainer(req.GetContainerId())
 	if err != nil {
@@ -206,7 +409,7 @@ func (m *Manager) StartContainer(ctx context.Context, req *kubeapi.StartContaine
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("%d: StartContainer: failed to get podData for sandbox %v", cookie, podId)
+		glog.Infof("%sfailed to get podData", logPrefix("StartContainer", cookie, podId))
 		return nil, fmt.Errorf("Failed to get podData for sandbox %v: %v", podId, err)
 	}
 
@@ -218,7 +421,13 @@ func (m *Manager) StartContainer(ctx context.Context, req *kubeapi.StartContaine
 		return nil, errors.New("CreateContainer: nil client, must be a removed pod sandbox?")
 	}
 
-	resp, err := client.StartContainer(req)
+	start := time.Now()
+	var resp *kubeapi.StartContainerResponse
+	err = callWithRetry(podData, client, containerCallAttempts, containerCallBackoff, func() (err error) {
+		resp, err = client.StartContainer(req)
+		return err
+	})
+	m.observe("StartContainer", start, err)
 	if err == nil { // start worked, start logging
 		go func() {
 			path, ok := podData.GetContLogPath(req.GetContainerId())
@@ -231,14 +440,18 @@ func (m *Manager) StartContainer(ctx context.Context, req *kubeapi.StartContaine
 		}()
 	}
 
-	glog.Infof("%d: StartContainer: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "StartContainer", cookie, req, resp, err)
 
 	return resp, err
 }
 
+// NOTE: the v1alpha1 CRI we are built against has no ReopenContainerLog, so the kubelet can't tell us it rotated a log.
+// Container logs are written on this host by Client.SaveLogs, which notices on its own when its file has been renamed or
+// removed and starts a new one.  When moving to a CRI version with the RPC, it should take the pod from the container
+// id with icommon.ParseContainer() and call Client.ReopenContainerLog().
 func (m *Manager) StopContainer(ctx context.Context, req *kubeapi.StopContainerRequest) (*kubeapi.StopContainerResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: StopContainer: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "StopContainer", cookie, req)
 
 	podId, _, err := icommon.ParseContainer(req.GetContainerId())
 	if err != nil {
@@ -247,7 +460,7 @@ func (m *Manager) StopContainer(ctx context.Context, req *kubeapi.StopContainerR
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("%d: StopContainer: failed to get podData for sandbox %v", cookie, podId)
+		glog.Infof("%sfailed to get podData", logPrefix("StopContainer", cookie, podId))
 		return nil, fmt.Errorf("Failed to get podData for sandbox %v: %v", podId, err)
 	}
 
@@ -259,16 +472,22 @@ func (m *Manager) StopContainer(ctx context.Context, req *kubeapi.StopContainerR
 		return nil, errors.New("CreateContainer: nil client, must be a removed pod sandbox?")
 	}
 
-	resp, err := client.StopContainer(req)
+	start := time.Now()
+	var resp *kubeapi.StopContainerResponse
+	err = callWithReconnect(client, func() (err error) {
+		resp, err = client.StopContainer(req)
+		return err
+	})
+	m.observe("StopContainer", start, err)
 
-	glog.Infof("%d: StopContainer: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "StopContainer", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) RemoveContainer(ctx context.Context, req *kubeapi.RemoveContainerRequest) (*kubeapi.RemoveContainerResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: RemoveContainer: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "RemoveContainer", cookie, req)
 
 	podId, _, err := icommon.ParseContainer(req.GetContainerId())
 	if err != nil {
@@ -277,7 +496,7 @@ func (m *Manager) RemoveContainer(ctx context.Context, req *kubeapi.RemoveContai
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("%d: RemoveContainer: failed to get podData for sandbox %v", cookie, podId)
+		glog.Infof("%sfailed to get podData", logPrefix("RemoveContainer", cookie, podId))
 		return nil, fmt.Errorf("Failed to get podData for sandbox %v: %v", podId, err)
 	}
 
@@ -289,27 +508,39 @@ func (m *Manager) RemoveContainer(ctx context.Context, req *kubeapi.RemoveContai
 		return nil, errors.New("CreateContainer: nil client, must be a removed pod sandbox?")
 	}
 
-	resp, err := client.RemoveContainer(req)
+	start := time.Now()
+	var resp *kubeapi.RemoveContainerResponse
+	err = callWithReconnect(client, func() (err error) {
+		resp, err = client.RemoveContainer(req)
+		return err
+	})
+	m.observe("RemoveContainer", start, err)
 
-	glog.Infof("%d: RemoveContainer: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "RemoveContainer", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) ListContainers(ctx context.Context, req *kubeapi.ListContainersRequest) (*kubeapi.ListContainersResponse, error) {
-	cookie := rand.Int()
-	glog.V(1).Infof("%d: ListContainers: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(1, "ListContainers", cookie, req)
 
+	start := time.Now()
 	resp, err := m.listContainers(req)
+	m.observe("ListContainers", start, err)
 
-	glog.V(1).Infof("%d: ListContainers: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(1, "ListContainers", cookie, req, resp, err)
 
 	return resp, err
 }
 
+// NOTE: there is no UpdateContainerResources here as the v1alpha1 CRI we are built against doesn't have that RPC, so the
+// kubelet can't ask us to resize a container.  When moving to a CRI version that does, it should resolve the client with
+// getClient() and pass the LinuxContainerResources through to vmserver, which applies them to the container's cgroups
+// inside the VM.
 func (m *Manager) ContainerStatus(ctx context.Context, req *kubeapi.ContainerStatusRequest) (*kubeapi.ContainerStatusResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: ContainerStatus: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "ContainerStatus", cookie, req)
 
 	podId, _, err := icommon.ParseContainer(req.GetContainerId())
 	if err != nil {
@@ -318,7 +549,7 @@ func (m *Manager) ContainerStatus(ctx context.Context, req *kubeapi.ContainerSta
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("%d: ContainerStatus: failed to get podData for sandbox %v", cookie, podId)
+		glog.Infof("%sfailed to get podData", logPrefix("ContainerStatus", cookie, podId))
 		return nil, fmt.Errorf("failed to get podData for sandbox %v", podId)
 	}
 
@@ -330,23 +561,33 @@ func (m *Manager) ContainerStatus(ctx context.Context, req *kubeapi.ContainerSta
 		return nil, errors.New("CreateContainer: nil client, must be a removed pod sandbox?")
 	}
 
-	resp, err := client.ContainerStatus(req)
+	var resp *kubeapi.ContainerStatusResponse
+	err = callWithReconnect(client, func() (err error) {
+		resp, err = client.ContainerStatus(req)
+		return err
+	})
 
-	glog.Infof("%d: ContainerStatus: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "ContainerStatus", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) ExecSync(ctx context.Context, req *kubeapi.ExecSyncRequest) (*kubeapi.ExecSyncResponse, error) {
-	cookie := rand.Int()
-	glog.Infof("%d: ExecSync: req = %+v", cookie, req)
+	cookie := nextCookie()
+	m.log.Request(0, "ExecSync", cookie, req)
+
+	if !m.capabilities().ExecSync {
+		return nil, unsupported("ExecSync")
+	}
 
-	splits := strings.Split(req.GetContainerId(), ":")
-	podId := splits[0]
+	podId, _, err := icommon.ParseContainer(req.GetContainerId())
+	if err != nil {
+		return nil, fmt.Errorf("ExecSync: failed: %v", err)
+	}
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("%d: ExecSync: failed to get podData for sandbox %v", cookie, podId)
+		glog.Infof("%sfailed to get podData", logPrefix("ExecSync", cookie, podId))
 		return nil, fmt.Errorf("failed to get podData for sandbox %v", podId)
 	}
 
@@ -358,15 +599,30 @@ func (m *Manager) ExecSync(ctx context.Context, req *kubeapi.ExecSyncRequest) (*
 		return nil, errors.New("ExecSync: nil client, must be a removed pod sandbox?")
 	}
 
-	resp, err := client.ExecSync(req)
+	var resp *kubeapi.ExecSyncResponse
+	err = callWithReconnect(client, func() (err error) {
+		resp, err = client.ExecSync(req)
+		return err
+	})
 
-	glog.Infof("%d: ExecSync: resp = %+v, err = %v", cookie, resp, err)
+	m.log.Response(0, "ExecSync", cookie, req, resp, err)
 
 	return resp, err
 }
 
+// Exec doesn't stream itself, it asks the vmserver in the pod's VM to prepare an exec session on its streaming server
+// and hands the resulting URL back to the kubelet.  stdin/stdout/stderr, resizing and exit codes are handled there.
 func (m *Manager) Exec(ctx context.Context, req *kubeapi.ExecRequest) (*kubeapi.ExecResponse, error) {
-	glog.Infof("Exec: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "Exec", cookie, req)
+
+	if !m.capabilities().Exec {
+		return nil, unsupported("Exec")
+	}
+
+	if len(req.GetCmd()) == 0 {
+		return nil, errors.New("Exec: no command specified")
+	}
 
 	podId, _, err := icommon.ParseContainer(req.GetContainerId())
 	if err != nil {
@@ -375,7 +631,7 @@ func (m *Manager) Exec(ctx context.Context, req *kubeapi.ExecRequest) (*kubeapi.
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("Exec: failed to get podData for sandbox %v", podId)
+		glog.Infof("%sfailed to get podData", logPrefix("Exec", cookie, podId))
 		return nil, fmt.Errorf("failed to get podData for sandbox %v", podId)
 	}
 
@@ -387,15 +643,29 @@ func (m *Manager) Exec(ctx context.Context, req *kubeapi.ExecRequest) (*kubeapi.
 		return nil, errors.New("Exec: nil client, must be a removed pod sandbox?")
 	}
 
-	resp, err := client.Exec(req)
+	var resp *kubeapi.ExecResponse
+	err = callWithReconnect(client, func() (err error) {
+		resp, err = client.Exec(req)
+		return err
+	})
+	if err == nil && resp.GetUrl() == "" {
+		err = fmt.Errorf("Exec: vmserver for sandbox %v didn't return a streaming url", podId)
+		resp = nil
+	}
 
-	glog.Infof("Exec: resp = %+v, err = %v", resp, err)
+	m.log.Response(0, "Exec", cookie, req, resp, err)
 
 	return resp, err
 }
 
+// Attach is served by the streaming server in the pod's VM, which validates the stdin/tty flags against the container
 func (m *Manager) Attach(ctx context.Context, req *kubeapi.AttachRequest) (*kubeapi.AttachResponse, error) {
-	glog.Infof("Attach: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "Attach", cookie, req)
+
+	if !m.capabilities().Attach {
+		return nil, unsupported("Attach")
+	}
 
 	podId, _, err := icommon.ParseContainer(req.GetContainerId())
 	if err != nil {
@@ -404,7 +674,7 @@ func (m *Manager) Attach(ctx context.Context, req *kubeapi.AttachRequest) (*kube
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("Attach: failed to get podData for sandbox %v", podId)
+		glog.Infof("%sfailed to get podData", logPrefix("Attach", cookie, podId))
 		return nil, fmt.Errorf("failed to get podData for sandbox %v", podId)
 	}
 
@@ -416,21 +686,38 @@ func (m *Manager) Attach(ctx context.Context, req *kubeapi.AttachRequest) (*kube
 		return nil, errors.New("Attach: nil client, must be a removed pod sandbox?")
 	}
 
-	resp, err := client.Attach(req)
+	var resp *kubeapi.AttachResponse
+	err = callWithReconnect(client, func() (err error) {
+		resp, err = client.Attach(req)
+		return err
+	})
 
-	glog.Infof("Attach: resp = %+v, err = %v", resp, err)
+	m.log.Response(0, "Attach", cookie, req, resp, err)
 
 	return resp, err
 }
 
+// PortForward, like Exec, is served by the streaming server in the pod's VM, our job is just to get its URL.  As the VM
+// is the pod, all requested ports are forwarded to the VM itself.
 func (m *Manager) PortForward(ctx context.Context, req *kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error) {
-	glog.Infof("PortForward: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "PortForward", cookie, req)
+
+	if !m.capabilities().PortForward {
+		return nil, unsupported("PortForward")
+	}
+
+	for _, port := range req.GetPort() {
+		if port <= 0 || port > math.MaxUint16 {
+			return nil, fmt.Errorf("PortForward: invalid port %d", port)
+		}
+	}
 
 	podId := req.GetPodSandboxId()
 
 	podData, err := m.getPodData(podId)
 	if err != nil {
-		glog.Infof("PortForward: failed to get podData for sandbox %v", podId)
+		glog.Infof("%sfailed to get podData", logPrefix("PortForward", cookie, podId))
 		return nil, fmt.Errorf("failed to get podData for sandbox %v", podId)
 	}
 
@@ -442,39 +729,78 @@ func (m *Manager) PortForward(ctx context.Context, req *kubeapi.PortForwardReque
 		return nil, errors.New("PortForward: nil client, must be a removed pod sandbox?")
 	}
 
-	resp, err := client.PortForward(req)
+	var resp *kubeapi.PortForwardResponse
+	err = callWithReconnect(client, func() (err error) {
+		resp, err = client.PortForward(req)
+		return err
+	})
 
-	glog.Infof("Attach: resp = %+v, err = %v", resp, err)
+	m.log.Response(0, "PortForward", cookie, req, resp, err)
 
 	return resp, err
-
 }
 
-// TODO: Currently only handles PodCIDR and unsure how that impacts infranetes?  Seems machine specific, but we ignore the machine CIDR
+// UpdateRuntimeConfig only carries the node's pod CIDR.  Pods are VMs with their own ips so we don't need it ourselves, but
+// it is kept and handed to pod providers that route pod ips.
 func (m *Manager) UpdateRuntimeConfig(ctx context.Context, req *kubeapi.UpdateRuntimeConfigRequest) (*kubeapi.UpdateRuntimeConfigResponse, error) {
-	glog.Infof("UpdateRuntimeConfig: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "UpdateRuntimeConfig", cookie, req)
 
-	resp := &kubeapi.UpdateRuntimeConfigResponse{}
+	var resp *kubeapi.UpdateRuntimeConfigResponse
+	err := m.updatePodCIDR(req.GetRuntimeConfig().GetNetworkConfig().GetPodCidr())
+	if err == nil {
+		resp = &kubeapi.UpdateRuntimeConfigResponse{}
+	}
 
-	glog.Infof("UpdateRuntimeConfig: resp = %+v, err = %v", resp, nil)
+	m.log.Response(0, "UpdateRuntimeConfig", cookie, req, resp, err)
 
-	return resp, nil
+	return resp, err
 }
 
+// Status reports the runtime as ready while we are serving, and the network as ready only while the pod provider can
+// reach the infrastructure it provisions VMs on, so the kubelet stops scheduling to us when it can't.
 func (m *Manager) Status(ctx context.Context, req *kubeapi.StatusRequest) (*kubeapi.StatusResponse, error) {
 	runtimeReady := &kubeapi.RuntimeCondition{
 		Type:   kubeapi.RuntimeReady,
 		Status: true,
 	}
+	if atomic.LoadInt32(&m.serving) == 0 {
+		runtimeReady.Status = false
+		runtimeReady.Reason = "NotServing"
+		runtimeReady.Message = "infranetes grpc server is not serving"
+	}
+
 	networkReady := &kubeapi.RuntimeCondition{
 		Type:   kubeapi.NetworkReady,
 		Status: true,
 	}
+	if err := m.healthCheck(); err != nil {
+		glog.Warningf("Status: pod provider health check failed: %v", err)
+		networkReady.Status = false
+		networkReady.Reason = "ProviderUnreachable"
+		networkReady.Message = err.Error()
+	}
+
 	conditions := []*kubeapi.RuntimeCondition{runtimeReady, networkReady}
 	status := &kubeapi.RuntimeStatus{Conditions: conditions}
 
 	return &kubeapi.StatusResponse{Status: status}, nil
+}
 
+// healthCheck is the pod provider's HealthCheck() cached for --health-cache-interval, so the kubelet's Status polls (and /healthz
+// probes) don't each make a cloud API call
+func (m *Manager) healthCheck() error {
+	m.healthLock.Lock()
+	defer m.healthLock.Unlock()
+
+	if !m.healthChecked.IsZero() && time.Since(m.healthChecked) < *flags.HealthCache {
+		return m.healthErr
+	}
+
+	m.healthErr = m.podProvider.HealthCheck()
+	m.healthChecked = time.Now()
+
+	return m.healthErr
 }
 
 func (m *Manager) ListImages(ctx context.Context, req *kubeapi.ListImagesRequest) (*kubeapi.ListImagesResponse, error) {
@@ -488,42 +814,53 @@ func (m *Manager) ListImages(ctx context.Context, req *kubeapi.ListImagesRequest
 }
 
 func (m *Manager) ImageStatus(ctx context.Context, req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error) {
-	glog.Infof("ImageStatus: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "ImageStatus", cookie, req)
 
 	resp, err := m.contProvider.ImageStatus(req)
 
-	glog.Infof("ImageStatus: resp = %+v, err = %v", resp, err)
+	m.log.Response(0, "ImageStatus", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) PullImage(ctx context.Context, req *kubeapi.PullImageRequest) (*kubeapi.PullImageResponse, error) {
-	glog.Infof("PullImage: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "PullImage", cookie, req)
 
 	resp, err := m.contProvider.PullImage(req)
 
-	glog.Infof("PullImage: resp = %+v, err = %v", resp, err)
+	m.log.Response(0, "PullImage", cookie, req, resp, err)
 
 	return resp, err
 }
 
 func (m *Manager) RemoveImage(ctx context.Context, req *kubeapi.RemoveImageRequest) (*kubeapi.RemoveImageResponse, error) {
-	glog.Infof("RemoveImage: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "RemoveImage", cookie, req)
 
 	resp, err := m.contProvider.RemoveImage(req)
 
-	glog.Infof("RemoveImage: resp = %+v, err = %v", resp, err)
+	m.log.Response(0, "RemoveImage", cookie, req, resp, err)
 
 	return resp, err
 }
 
 // ImageFsInfo returns information of the filesystem that is used to store images.
 func (m *Manager) ImageFsInfo(ctx context.Context, req *kubeapi.ImageFsInfoRequest) (*kubeapi.ImageFsInfoResponse, error) {
-	return nil, errors.New("not implemented")
+	cookie := nextCookie()
+	m.log.Request(3, "ImageFsInfo", cookie, req)
+
+	resp, err := m.contProvider.ImageFsInfo(req)
+
+	m.log.Response(3, "ImageFsInfo", cookie, req, resp, err)
+
+	return resp, err
 }
 
 func (m *Manager) GetMetrics(ctx context.Context, req *icommon.GetMetricsRequest) (*icommon.GetMetricsResponse, error) {
-	glog.Infof("GetMetrics: req = %+v", req)
+	cookie := nextCookie()
+	m.log.Request(0, "GetMetrics", cookie, req)
 
 	containers := [][]byte{}
 
@@ -582,11 +919,41 @@ func (m *Manager) DelMount(ctx context.Context, req *icommon.DelMountRequest) (*
 	return &icommon.DelMountResponse{}, nil
 }
 
-// TODO
 func (m *Manager) ContainerStats(ctx context.Context, req *kubeapi.ContainerStatsRequest) (*kubeapi.ContainerStatsResponse, error) {
-	return nil, fmt.Errorf("Not implemented")
+	cookie := nextCookie()
+	m.log.Request(0, "ContainerStats", cookie, req)
+
+	podId, _, err := icommon.ParseContainer(req.GetContainerId())
+	if err != nil {
+		return nil, fmt.Errorf("ContainerStats: failed: %v", err)
+	}
+
+	client, err := m.getClient(podId)
+	if err != nil {
+		return nil, fmt.Errorf("ContainerStats: %v", err)
+	}
+	if client == nil {
+		return nil, errors.New("ContainerStats: nil client, must be a removed pod sandbox?")
+	}
+
+	var resp *kubeapi.ContainerStatsResponse
+	err = callWithReconnect(client, func() (err error) {
+		resp, err = client.ContainerStats(req)
+		return err
+	})
+
+	m.log.Response(0, "ContainerStats", cookie, req, resp, err)
+
+	return resp, err
 }
 
 func (m *Manager) ListContainerStats(ctx context.Context, req *kubeapi.ListContainerStatsRequest) (*kubeapi.ListContainerStatsResponse, error) {
-	return nil, fmt.Errorf("Not implemented")
+	cookie := nextCookie()
+	m.log.Request(1, "ListContainerStats", cookie, req)
+
+	resp, err := m.listContainerStats(req)
+
+	m.log.Response(1, "ListContainerStats", cookie, req, resp, err)
+
+	return resp, err
 }
@@ -0,0 +1,95 @@
+package infranetes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+)
+
+var (
+	operationLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "infranetes",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of pod and container operations.",
+			// booting a VM can take minutes, so go well past the default buckets
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+		},
+		[]string{"operation", "provider"},
+	)
+
+	operationErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "infranetes",
+			Name:      "operation_errors_total",
+			Help:      "Number of pod and container operations that returned an error.",
+		},
+		[]string{"operation", "provider"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(operationLatency, operationErrors)
+}
+
+// registerMetrics adds the metrics that need to look at the manager's state
+func (m *Manager) registerMetrics() {
+	sandboxes := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace:   "infranetes",
+			Name:        "sandboxes",
+			Help:        "Number of pod sandboxes infranetes is managing.",
+			ConstLabels: prometheus.Labels{"provider": *flags.PodProvider},
+		},
+		func() float64 {
+			m.vmMapLock.RLock()
+			defer m.vmMapLock.RUnlock()
+
+			return float64(len(m.vmMap))
+		},
+	)
+
+	if err := prometheus.Register(sandboxes); err != nil {
+		glog.Warningf("registerMetrics: couldn't register sandbox gauge: %v", err)
+	}
+}
+
+// observe records how long op took since start and whether it failed
+func (m *Manager) observe(op string, start time.Time, err error) {
+	operationLatency.WithLabelValues(op, *flags.PodProvider).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		operationErrors.WithLabelValues(op, *flags.PodProvider).Inc()
+	}
+}
+
+// healthz serves /healthz for load balancer and readiness probes, 200 only while the pod provider can reach the cloud API
+// backing it, i.e. not once its credentials have expired
+func (m *Manager) healthz(w http.ResponseWriter, r *http.Request) {
+	if err := m.healthCheck(); err != nil {
+		glog.Warningf("healthz: pod provider health check failed: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+func (m *Manager) serveMetrics(addr string) {
+	glog.Infof("Serving metrics at %s", addr)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/debug/vms", m.debugVMs)
+	mux.HandleFunc("/healthz", m.healthz)
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		glog.Errorf("serveMetrics: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+/* VM-less pods: pods annotated infranetes.io/no-vm="true" get a sandbox without a VM behind it */
+
+package infranetes
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	noVMAnnotation = "infranetes.io/no-vm"
+)
+
+func wantsNoVM(annotations map[string]string) bool {
+	return annotations[noVMAnnotation] == "true"
+}
+
+// isVMless is true for a sandbox from createVMlessSandbox().  The pod provider never hears of these, there is nothing
+// of its to stop or remove.
+func isVMless(podData *common.PodData) bool {
+	return !podData.Booted && wantsNoVM(podData.Annotations)
+}
+
+// createVMlessSandbox returns a sandbox for req that isn't backed by a VM, for pods that only need to exist as far as
+// the kubelet is concerned.  Its containers are only pretended to be run, by a fake client, so nothing it runs does
+// anything.  It has no ip, and like colocated guests it isn't saved by saveState, the kubelet recreates it after a
+// restart.
+func createVMlessSandbox(req *kubeapi.RunPodSandboxRequest) (*common.PodData, error) {
+	config := req.GetConfig()
+
+	client, err := common.CreateFakeClient()
+	if err != nil {
+		return nil, fmt.Errorf("createVMlessSandbox: %v", err)
+	}
+
+	id := fmt.Sprintf("novm-%v-%d", config.GetMetadata().GetUid(), config.GetMetadata().GetAttempt())
+	booted := false
+	podData := common.NewPodData(nil, id, config.Metadata, config.Annotations, config.Labels, "", config.Linux, client, booted, nil)
+
+	glog.Infof("createVMlessSandbox: %v doesn't get a VM", id)
+
+	return podData, nil
+}
@@ -11,8 +11,11 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
 
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
@@ -39,12 +42,14 @@ func NewAWSImageProvider() (provider.ImageProvider, error) {
 		return nil, fmt.Errorf("File error: %v\n", err)
 	}
 
-	json.Unmarshal(file, &conf)
+	if err := json.Unmarshal(file, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse aws.json: %v", err)
+	}
 
 	if conf.Region == "" {
 		msg := fmt.Sprintf("Failed to read in complete config file: conf = %+v", conf)
 		glog.Info(msg)
-		return nil, fmt.Errorf(msg)
+		return nil, errors.New(msg)
 	}
 
 	provider := &awsImageProvider{
@@ -59,7 +64,16 @@ func toRuntimeAPIImage(image *ec2.Image) (*kubeapi.Image, error) {
 		return nil, errors.New("unable to convert a nil pointer to a runtime API image")
 	}
 
-	size := uint64(1)
+	// the AMI's size isn't known, only that of its snapshots
+	size := uint64(0)
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.VolumeSize != nil {
+			size += uint64(*mapping.Ebs.VolumeSize) << 30
+		}
+	}
+	if size == 0 {
+		size = 1
+	}
 
 	name := image.ImageId
 	for _, tag := range image.Tags {
@@ -84,11 +98,18 @@ func (p *awsImageProvider) ListImages(req *kubeapi.ListImagesRequest) (*kubeapi.
 	result := []*kubeapi.Image{}
 
 	if req.Filter != nil && req.Filter.Image != nil {
-		if image, ok := p.imageMap[req.Filter.Image.Image]; ok {
+		if image, ok := p.lookupLocked(req.Filter.Image.Image); ok {
 			result = append(result, image)
 		}
 	} else {
+		// the same AMI can be pulled under more than one name (i.e. with and without its owner), the kubelet should only
+		// see it once
+		seen := make(map[string]bool)
 		for _, image := range p.imageMap {
+			if seen[image.Id] {
+				continue
+			}
+			seen[image.Id] = true
 			result = append(result, image)
 		}
 	}
@@ -100,17 +121,36 @@ func (p *awsImageProvider) ListImages(req *kubeapi.ListImagesRequest) (*kubeapi.
 	return resp, nil
 }
 
+// lookupLocked finds a pulled image by the name it was pulled under, treating a name without a tag as :latest, as
+// ImageStatus and the kubelet do.  Expects p.lock to be held.
+func (p *awsImageProvider) lookupLocked(name string) (*kubeapi.Image, bool) {
+	if image, ok := p.imageMap[name]; ok {
+		return image, true
+	}
+
+	if strings.HasSuffix(name, ":latest") {
+		image, ok := p.imageMap[strings.TrimSuffix(name, ":latest")]
+		return image, ok
+	}
+	if len(strings.Split(name, ":")) == 1 {
+		image, ok := p.imageMap[name+":latest"]
+		return image, ok
+	}
+
+	return nil, false
+}
+
 func (p *awsImageProvider) ImageStatus(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error) {
-	name := req.Image.Image
+	name := req.GetImage().GetImage()
 
+	// normalize into our own ImageSpec, the caller's request is left alone
 	if len(strings.Split(name, ":")) == 1 {
 		name += ":latest"
-		req.Image.Image = name
 	}
 
 	newreq := &kubeapi.ListImagesRequest{
 		Filter: &kubeapi.ImageFilter{
-			Image: req.Image,
+			Image: &kubeapi.ImageSpec{Image: name},
 		},
 	}
 
@@ -163,27 +203,57 @@ func (p *awsImageProvider) PullImage(req *kubeapi.PullImageRequest) (*kubeapi.Pu
 		}
 		p.imageMap[req.Image.Image] = image
 
-		return &kubeapi.PullImageResponse{}, nil
+		return &kubeapi.PullImageResponse{ImageRef: image.Id}, nil
 	default:
 		return nil, fmt.Errorf("PullImage: ec2.DescribeImages returned more than one image: %+v", ec2Results.Images)
 	}
 }
 
 func (p *awsImageProvider) RemoveImage(req *kubeapi.RemoveImageRequest) (*kubeapi.RemoveImageResponse, error) {
+	name := req.GetImage().GetImage()
+	tagged := name
+	if len(strings.Split(name, ":")) == 1 {
+		tagged += ":latest"
+	}
+
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	delete(p.imageMap, req.Image.Image)
+	found := false
+	for key, image := range p.imageMap {
+		if key == name || key == tagged || strings.TrimSuffix(key, ":latest") == name || image.Id == name {
+			delete(p.imageMap, key)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, grpc.Errorf(codes.NotFound, "RemoveImage: image %v not found", name)
+	}
 
 	return &kubeapi.RemoveImageResponse{}, nil
 }
 
+func (p *awsImageProvider) ImageFsInfo(req *kubeapi.ImageFsInfoRequest) (*kubeapi.ImageFsInfoResponse, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	// count every AMI once, however many names it was pulled under
+	images := make(map[string]*kubeapi.Image)
+	for _, image := range p.imageMap {
+		images[image.Id] = image
+	}
+
+	return common.ImagesFsInfo("aws-ami", images), nil
+}
+
 func (p *awsImageProvider) Integrate(pp provider.PodProvider) bool {
 	switch pp.(type) {
 	case *awsPodProvider:
 		app := pp.(*awsPodProvider)
 		//aws shouldn't boot on pod run if using container images
 		app.imagePod = true
+		app.resolveImage = p.resolveAMI
 
 		return true
 	}
@@ -191,6 +261,29 @@ func (p *awsImageProvider) Integrate(pp provider.PodProvider) bool {
 	return false
 }
 
+// Translate returns the AMI id the image spec refers to, so the pod provider can boot it
 func (p *awsImageProvider) Translate(spec *kubeapi.ImageSpec) (string, error) {
-	return spec.Image, nil
+	return p.resolveAMI(spec.GetImage())
+}
+
+// resolveAMI returns the id of the AMI image names, pulling it first if it hasn't been.  AMI ids are passed through as
+// is.
+func (p *awsImageProvider) resolveAMI(image string) (string, error) {
+	if strings.HasPrefix(image, "ami-") {
+		return image, nil
+	}
+
+	p.lock.RLock()
+	cached, ok := p.lookupLocked(image)
+	p.lock.RUnlock()
+	if ok {
+		return cached.Id, nil
+	}
+
+	resp, err := p.PullImage(&kubeapi.PullImageRequest{Image: &kubeapi.ImageSpec{Image: image}})
+	if err != nil {
+		return "", fmt.Errorf("couldn't resolve %v to an AMI: %v", image, err)
+	}
+
+	return resp.ImageRef, nil
 }
@@ -5,13 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/golang/glog"
+	"golang.org/x/net/context"
 
 	"github.com/apcera/libretto/ssh"
 	awsvm "github.com/apcera/libretto/virtualmachine/aws"
@@ -34,20 +37,45 @@ type podData struct {
 	attached    map[string]string
 	lock        sync.Mutex
 	volumes     []*types.Volume
+	// the instance was stopped by StopPodSandbox, and can be resumed
+	stopped bool
 }
 
 type awsPodProvider struct {
-	config   *awsConfig
+	// swapped by ReloadConfig, read it with getConfig()
+	configLock sync.RWMutex
+	config     *awsConfig
+
 	ipList   *utils.Deque
 	imagePod bool
 	key      string
+	pool     *common.VMPool
+
+	// pods running on spot instances, by instance id, see checkSpot()
+	spotLock sync.Mutex
+	spotPods map[string]*common.PodData
+
+	// holds a token for every launch in flight when MaxConcurrentProvision is set, nil otherwise
+	provisionSem chan struct{}
+
+	// parsed UserDataFile, nil if there is none
+	userData *template.Template
+
+	// set by the aws image provider, resolves image references to AMI ids.  Without it infranetes.aws.image has to be
+	// an AMI id.
+	resolveImage func(image string) (string, error)
+
+	// the root device names of the AMIs we booted, they don't change
+	rootDeviceLock sync.Mutex
+	rootDevices    map[string]string
 }
 
 func init() {
 	provider.PodProviders.RegisterProvider("aws", NewAWSPodProvider)
 }
 
-func NewAWSPodProvider() (provider.PodProvider, error) {
+// loadAWSConfig reads and validates aws.json, filling in the defaults
+func loadAWSConfig() (*awsConfig, error) {
 	var conf awsConfig
 
 	file, err := ioutil.ReadFile("aws.json")
@@ -55,22 +83,58 @@ func NewAWSPodProvider() (provider.PodProvider, error) {
 		return nil, fmt.Errorf("File error: %v\n", err)
 	}
 
-	json.Unmarshal(file, &conf)
+	if err := json.Unmarshal(file, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse aws.json: %v", err)
+	}
+
+	if err := conf.validate(); err != nil {
+		glog.Info(err)
+		return nil, err
+	}
+
+	if conf.AgentPort == 0 {
+		conf.AgentPort = common.DefaultAgentPort
+	}
+	if conf.IPSelection.Prefer == "" && conf.IPSelection.CIDR == "" {
+		conf.IPSelection.Prefer = "private"
+	}
+	if len(conf.InstanceSizes) == 0 {
+		conf.InstanceSizes = defaultInstanceSizes
+	}
+	if conf.KeyPairName == "" {
+		conf.KeyPairName = strings.TrimSuffix(filepath.Base(conf.SshKey), filepath.Ext(conf.SshKey))
+	}
+	if conf.SshUser == "" {
+		conf.SshUser = defaultSshUser
+	}
+	if conf.ProvisionRetries == nil {
+		retries := defaultProvisionRetries
+		conf.ProvisionRetries = &retries
+	} else if *conf.ProvisionRetries < 0 {
+		return nil, errors.New("aws.json ProvisionRetries can't be negative")
+	}
+	if conf.ProvisionBackoff <= 0 {
+		conf.ProvisionBackoff = defaultProvisionBackoff
+	}
+
+	return &conf, nil
+}
 
-	if conf.Ami == "" || conf.RouteTable == "" || conf.Region == "" || conf.SecurityGroup == "" || conf.Vpc == "" || conf.Subnet == "" || conf.SshKey == "" {
-		msg := fmt.Sprintf("Failed to read in complete config file: conf = %+v", conf)
-		glog.Info(msg)
-		return nil, fmt.Errorf(msg)
+func NewAWSPodProvider() (provider.PodProvider, error) {
+	conf, err := loadAWSConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	glog.Infof("Validating AWS Credentials")
+	// Region is checked by validate(), ValidCredentials would otherwise fail on the region rather than the credentials
+	glog.Infof("Validating AWS Credentials in %v", conf.Region)
 
 	if err := awsvm.ValidCredentials(conf.Region); err != nil {
-		glog.Infof("Failed to Validated AWS Credentials")
-		return nil, fmt.Errorf("failed to validate credentials: %v\n", err)
+		glog.Infof("Failed to Validate AWS Credentials in %v: %v", conf.Region, err)
+		return nil, fmt.Errorf("failed to validate credentials in region %v: %v", conf.Region, err)
 	}
 
-	glog.Infof("Validated AWS Credentials")
+	glog.Infof("Validated AWS Credentials in %v", conf.Region)
 
 	rawKey, err := ioutil.ReadFile(conf.SshKey)
 	if err != nil {
@@ -79,6 +143,10 @@ func NewAWSPodProvider() (provider.PodProvider, error) {
 
 	initEC2(conf.Region)
 
+	if err := ensureKeyPair(conf.KeyPairName, rawKey, conf.ImportKeyPair); err != nil {
+		return nil, fmt.Errorf("key pair %v: %v", conf.KeyPairName, err)
+	}
+
 	// FIXME: probably want to pull out ip handling into a "network plugin", would want to verify boot image supports plugin
 	// Currently: this just controls allocation to an independent infranetes subnet, L3 routing has to be setup correctly on cloud
 	// Enable autodetection of infranetes ip range
@@ -108,11 +176,159 @@ func NewAWSPodProvider() (provider.PodProvider, error) {
 		ipList.Append(fmt.Sprint(*flags.IPBase + "." + strconv.Itoa(i)))
 	}
 
-	return &awsPodProvider{
-		config: &conf,
-		ipList: ipList,
-		key:    string(rawKey),
-	}, nil
+	p := &awsPodProvider{
+		config:   conf,
+		ipList:   ipList,
+		key:      string(rawKey),
+		spotPods: make(map[string]*common.PodData),
+
+		rootDevices: make(map[string]string),
+	}
+
+	if conf.MaxConcurrentProvision > 0 {
+		p.provisionSem = make(chan struct{}, conf.MaxConcurrentProvision)
+	}
+
+	if conf.UserDataFile != "" {
+		if p.userData, err = loadUserData(conf.UserDataFile); err != nil {
+			return nil, err
+		}
+	}
+
+	go p.spotWatcher()
+
+	// even without a pool now, there may be one left over from before
+	p.destroyStalePool()
+
+	if conf.PoolSize > 0 {
+		glog.Infof("Keeping a pool of %d idle instances", conf.PoolSize)
+		p.pool = common.NewVMPool(conf.PoolSize, p.createPoolVM)
+		p.pool.Start()
+	}
+
+	return p, nil
+}
+
+func (v *awsPodProvider) getConfig() *awsConfig {
+	v.configLock.RLock()
+	defer v.configLock.RUnlock()
+
+	return v.config
+}
+
+// ReloadConfig re-reads aws.json.  What the running pods, the pool and the ec2 client were set up with (i.e. the region,
+// subnet and key) keeps its old value.
+func (v *awsPodProvider) ReloadConfig() error {
+	conf, err := loadAWSConfig()
+	if err != nil {
+		return err
+	}
+
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+
+	common.KeepFixedFields("aws.json", v.config, conf, "Region", "Vpc", "Subnet", "RouteTable", "SshKey", "KeyPairName",
+		"ImportKeyPair", "PoolSize", "UserDataFile", "MaxConcurrentProvision", "AgentPort")
+	v.config = conf
+
+	return nil
+}
+
+// destroyStalePool terminates the idle pool instances of ours a previous run left behind, the new pool doesn't know about
+// them and ListInstances skips them, so nothing else would.  Ours are the ones with an ip in our range, other nodes may
+// have pools in the same account.
+func (v *awsPodProvider) destroyStalePool() {
+	request := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag-key"), Values: []*string{aws.String(poolTag)}},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []*string{aws.String("pending"), aws.String("running"), aws.String("stopping"), aws.String("stopped")},
+			},
+		},
+	}
+	result, err := client.DescribeInstances(request)
+	if err != nil {
+		glog.Warningf("destroyStalePool: DescribeInstances failed: %v", err)
+		return
+	}
+
+	for _, resv := range result.Reservations {
+		for _, instance := range resv.Instances {
+			if !strings.HasPrefix(aws.StringValue(instance.PrivateIpAddress), *flags.IPBase+".") {
+				continue
+			}
+
+			vm := &awsvm.VM{
+				InstanceID: aws.StringValue(instance.InstanceId),
+				Region:     v.getConfig().Region,
+			}
+
+			glog.Infof("destroyStalePool: terminating idle pool instance %v left over from before", vm.InstanceID)
+			if err := vm.Destroy(); err != nil {
+				glog.Warningf("destroyStalePool: couldn't terminate %v: %v", vm.InstanceID, err)
+			}
+		}
+	}
+}
+
+// createPoolVM provisions an instance with the default settings.  It is only tagged as a pool instance, so ListInstances
+// won't mistake it for a pod if we restart before it is claimed, destroyStalePool cleans it up instead.
+func (v *awsPodProvider) createPoolVM() (common.VM, error) {
+	podIp := v.ipList.Shift().(string)
+
+	config := &kubeapi.PodSandboxConfig{}
+	vm := v.createVM(config, podIp)
+
+	userData, err := v.renderUserData(config)
+	if err != nil {
+		v.ipList.Append(podIp)
+		return nil, err
+	}
+
+	if _, err := v.provisionVM(context.Background(), vm, map[string]string{poolTag: "true"}, v.spotPrice(&awsAnnotations{}), userData); err != nil {
+		v.ipList.Append(podIp)
+		return nil, err
+	}
+
+	return vm, nil
+}
+
+// claimPoolVM returns a pooled instance if the pod can use one, i.e. doesn't ask for any non default aws settings
+func (v *awsPodProvider) claimPoolVM(config *kubeapi.PodSandboxConfig) (*awsvm.VM, bool) {
+	if v.pool == nil || *parseAWSAnnotations(config.Annotations) != (awsAnnotations{}) {
+		return nil, false
+	}
+	if r, err := common.ParseResources(config.Annotations); err != nil || !r.IsZero() {
+		return nil, false
+	}
+
+	vm, ok := v.pool.Claim()
+	if !ok {
+		return nil, false
+	}
+
+	awsVM, ok := vm.(*awsvm.VM)
+	if !ok {
+		glog.Warningf("claimPoolVM: pool returned a non aws VM %v", vm.GetName())
+		return nil, false
+	}
+
+	if err := untagInstance(awsVM.InstanceID, poolTag); err != nil {
+		glog.Warningf("claimPoolVM: couldn't remove pool tag from %v: %v", awsVM.InstanceID, err)
+	}
+	if err := tagInstance(awsVM.InstanceID, podTags(config, v.getConfig().ExtraTags)); err != nil {
+		glog.Warningf("claimPoolVM: couldn't tag %v: %v", awsVM.InstanceID, err)
+	}
+
+	return awsVM, true
+}
+
+// Shutdown destroys any idle pool instances
+func (v *awsPodProvider) Shutdown() {
+	if v.pool != nil {
+		v.pool.Shutdown()
+	}
 }
 
 func (*awsPodProvider) UpdatePodState(data *common.PodData) {
@@ -122,35 +338,53 @@ func (*awsPodProvider) UpdatePodState(data *common.PodData) {
 }
 
 // FIXME: if steps fail, probably want to teardown VM
-func (p *awsPodProvider) bootSandbox(vm *awsvm.VM, config *kubeapi.PodSandboxConfig, name string, volumes []*types.Volume) (*common.PodData, error) {
-	// 1. Parse Annotations from PodSandboxConfig
-	cAnno := common.ParseCommonAnnotations(config.Annotations)
-
-	// 2. Boot VM
-	if err := vm.Provision(); err != nil {
-		return nil, fmt.Errorf("failed to provision vm: %v\n", err)
+func (p *awsPodProvider) bootSandbox(ctx context.Context, vm *awsvm.VM, config *kubeapi.PodSandboxConfig, name string, volumes []*types.Volume) (*common.PodData, error) {
+	userData, err := p.renderUserData(config)
+	if err != nil {
+		return nil, fmt.Errorf("bootSandbox: %v", err)
 	}
 
-	vm.SetTag("infranetes", "true")
-
-	// 3. Extract IP Info
-	ips, err := vm.GetIPs()
+	// 1. Boot VM and 2. Extract IP Info
+	ips, err := p.provisionVM(ctx, vm, podTags(config, p.getConfig().ExtraTags), p.spotPrice(parseAWSAnnotations(config.Annotations)), userData)
 	if err != nil {
-		return nil, fmt.Errorf("bootSandbox: error in GetIPs(): %v", err)
+		return nil, fmt.Errorf("bootSandbox: %v", err)
+	}
+
+	// kubelet may have given up while we were waiting on the last GetIPs() poll
+	if err := ctx.Err(); err != nil {
+		destroyPartialVM(vm)
+		return nil, fmt.Errorf("bootSandbox: %v", err)
 	}
 
+	return p.setupSandbox(vm, ips, config, name, volumes)
+}
+
+// setupSandbox turns a running instance into a pod
+func (p *awsPodProvider) setupSandbox(vm *awsvm.VM, ips []net.IP, config *kubeapi.PodSandboxConfig, name string, volumes []*types.Volume) (*common.PodData, error) {
+	// 3. Parse Annotations from PodSandboxConfig
+	cAnno := common.ParseCommonAnnotations(config.Annotations)
+
 	glog.Infof("bootSandbox: ips = %v", ips)
 
-	// FIXME: Perhaps better way to choose public vs private ip
-	index := 1
-	podIp := ips[index].String()
+	// The pod is always given the private ip, IPSelection only decides what we dial
+	podIp := ips[1].String()
+
+	dialIp, err := p.getConfig().IPSelection.Select(ips)
+	if err != nil {
+		return nil, fmt.Errorf("bootSandbox: %v", err)
+	}
+
+	glog.Infof("bootSandbox: podIp = %v, vmserver at %v", podIp, dialIp)
 
-	glog.Infof("bootSandbox: podIp = %v", podIp)
+	// pods outside our subnet are named after the ip EC2 gave them
+	if name == "" {
+		name = podIp
+	}
 
 	// 4. Connect to VMServer in VM
-	client, err := common.CreateRealClient(podIp)
+	client, err := common.WaitForAgent(dialIp.String(), p.getConfig().AgentPort, *flags.VMReadyTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("bootSandbox: error in createClient(): %v", err)
+		return nil, fmt.Errorf("bootSandbox: %v", err)
 	}
 
 	providerData := &podData{
@@ -159,6 +393,12 @@ func (p *awsPodProvider) bootSandbox(vm *awsvm.VM, config *kubeapi.PodSandboxCon
 		attached:    make(map[string]string),
 		volumes:     volumes,
 	}
+	// keep Attach off the instance's data volumes, the root volume isn't on a device it picks from
+	for _, volume := range vm.Volumes {
+		if letter := dataVolumeLetter(volume.DeviceName); letter != "" {
+			providerData.usedDevices[letter] = true
+		}
+	}
 
 	// 5. Attach/Mount EBS Volumes
 	for _, vol := range volumes {
@@ -208,23 +448,248 @@ func (p *awsPodProvider) bootSandbox(vm *awsvm.VM, config *kubeapi.PodSandboxCon
 		glog.Infof("CreatePodSandbox: Skipping changing hostname")
 	}
 
+	// last, as every failure above destroys the instance
+	p.protectVM(vm, config)
+
 	booted := true
 
 	podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+	podData.ReadyCacheInterval = p.readyCacheInterval()
+	podData.NetworkMode = p.getConfig().NetworkMode
 
 	return podData, nil
 }
 
-func (v *awsPodProvider) RunPodSandbox(req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+func (p *awsPodProvider) protectVM(vm *awsvm.VM, config *kubeapi.PodSandboxConfig) {
+	anno := parseAWSAnnotations(config.Annotations)
+
+	protect := p.getConfig().TerminationProtection
+	switch anno.protect {
+	case "true":
+		protect = true
+	case "false":
+		protect = false
+	}
+
+	if !protect {
+		return
+	}
+
+	if p.spotPrice(anno) != "" {
+		glog.Warningf("protectVM: %v is a spot instance, which can't have termination protection", vm.InstanceID)
+		return
+	}
+
+	if err := setTerminationProtection(vm.InstanceID, true); err != nil {
+		glog.Warningf("protectVM: couldn't protect %v: %v", vm.InstanceID, err)
+		return
+	}
+
+	glog.Infof("protectVM: enabled termination protection on %v", vm.InstanceID)
+}
+
+// provisionVM boots the vm and waits for its ips, retrying with exponential backoff as EC2 throttling and capacity errors
+// are usually transient.  A failed attempt may have left an instance behind, so it is destroyed before trying again and
+// after the final failure.  Gives up as soon as ctx is done.  A non empty spotPrice requests a spot instance, userData is
+// base64 encoded and may be empty.
+func (p *awsPodProvider) provisionVM(ctx context.Context, vm *awsvm.VM, tags map[string]string, spotPrice string, userData string) ([]net.IP, error) {
+	conf := p.getConfig()
+
+	if err := p.rootDeviceVM(vm); err != nil {
+		return nil, err
+	}
+
+	backoff := time.Duration(conf.ProvisionBackoff) * time.Second
+
+	var err error
+	for attempt := 0; attempt <= *conf.ProvisionRetries; attempt++ {
+		if attempt > 0 {
+			glog.Warningf("provisionVM: attempt %d for %v failed: %v, retrying in %v", attempt, vm.GetName(), err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("gave up provisioning vm: %v", ctx.Err())
+			}
+			backoff *= 2
+		}
+
+		var ips []net.IP
+		if ips, err = p.provisionOnce(ctx, vm, tags, spotPrice, userData); err == nil {
+			return ips, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("failed to provision vm after %d attempts: %v", *conf.ProvisionRetries+1, err)
+}
+
+// provisionOnce runs a single provisioning attempt.  libretto's Provision() and GetIPs() can't be interrupted, so if ctx
+// is done first we return straight away and destroy whatever the attempt created once it finishes.  Either way a failed
+// attempt doesn't leave an instance behind.
+func (p *awsPodProvider) provisionOnce(ctx context.Context, vm *awsvm.VM, tags map[string]string, spotPrice string, userData string) ([]net.IP, error) {
+	type result struct {
+		ips []net.IP
+		err error
+	}
+
+	if p.provisionSem != nil {
+		select {
+		case p.provisionSem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up waiting to provision vm: %v", ctx.Err())
+		}
+	}
+
+	// the launch works on its own copy, so an abandoned one doesn't write to vm behind our caller's back
+	launch := &awsvm.VM{}
+	copyVM(launch, vm)
+
+	done := make(chan result, 1)
+	go func() {
+		ips, err := p.launchVM(launch, tags, spotPrice, userData)
+		// an abandoned launch still counts against the limit until EC2 is done with it
+		if p.provisionSem != nil {
+			<-p.provisionSem
+		}
+		done <- result{ips: ips, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		copyVM(vm, launch)
+		if r.err != nil {
+			destroyPartialVM(vm)
+		}
+		return r.ips, r.err
+	case <-ctx.Done():
+		glog.Warningf("provisionOnce: gave up on %v: %v", vm.GetName(), ctx.Err())
+		go func() {
+			<-done
+			destroyPartialVM(launch)
+		}()
+		return nil, fmt.Errorf("gave up provisioning vm: %v", ctx.Err())
+	}
+}
+
+// destroyPartialVM destroys the instance a failed or abandoned provisioning attempt left behind, if any
+func destroyPartialVM(vm *awsvm.VM) {
+	if vm.InstanceID == "" {
+		return
+	}
+
+	if err := vm.Destroy(); err != nil {
+		glog.Warningf("destroyPartialVM: couldn't destroy partially created instance %v: %v", vm.InstanceID, err)
+	}
+	vm.InstanceID = ""
+}
+
+// copyVM copies src's settings into dst field by field, a plain assignment would copy the mutex in SSHCreds
+func copyVM(dst, src *awsvm.VM) {
+	dst.Name = src.Name
+	dst.Region = src.Region
+	dst.AMI = src.AMI
+	dst.InstanceType = src.InstanceType
+	dst.InstanceID = src.InstanceID
+	dst.KeyPair = src.KeyPair
+	dst.IamInstanceProfileName = src.IamInstanceProfileName
+	dst.PrivateIPAddress = src.PrivateIPAddress
+	dst.Volumes = src.Volumes
+	dst.KeepRootVolumeOnDestroy = src.KeepRootVolumeOnDestroy
+	dst.DeleteNonRootVolumeOnDestroy = src.DeleteNonRootVolumeOnDestroy
+	dst.VPC = src.VPC
+	dst.Subnet = src.Subnet
+	dst.SecurityGroups = src.SecurityGroups
+	dst.SSHCreds.SSHUser = src.SSHCreds.SSHUser
+	dst.SSHCreds.SSHPassword = src.SSHCreds.SSHPassword
+	dst.SSHCreds.SSHPrivateKey = src.SSHCreds.SSHPrivateKey
+	dst.DeleteKeysOnDestroy = src.DeleteKeysOnDestroy
+}
+
+func (p *awsPodProvider) launchVM(vm *awsvm.VM, tags map[string]string, spotPrice string, userData string) ([]net.IP, error) {
+	if spotPrice != "" {
+		if err := provisionSpot(vm, spotPrice, userData); err != nil {
+			return nil, fmt.Errorf("failed to provision spot vm: %v", err)
+		}
+	} else if userData != "" {
+		if err := provisionWithUserData(vm, userData); err != nil {
+			return nil, fmt.Errorf("failed to provision vm: %v", err)
+		}
+	} else if err := vm.Provision(); err != nil {
+		return nil, fmt.Errorf("failed to provision vm: %v", err)
+	}
+
+	// libretto has no way to pass tags into RunInstances, so they are applied as soon as we have an instance id
+	if err := tagInstance(vm.InstanceID, tags); err != nil {
+		glog.Warningf("launchVM: couldn't tag %v: %v", vm.InstanceID, err)
+	}
+
+	ips, err := vm.GetIPs()
+	if err != nil {
+		return nil, fmt.Errorf("error in GetIPs(): %v", err)
+	}
+
+	// bootSandbox uses the private ip
+	if len(ips) < 2 || ips[1] == nil {
+		return nil, fmt.Errorf("GetIPs() didn't return a private ip: %v", ips)
+	}
+
+	return ips, nil
+}
+
+func (v *awsPodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+	if !v.imagePod {
+		if vm, ok := v.claimPoolVM(req.Config); ok {
+			glog.Infof("RunPodSandbox: using pooled instance %v", vm.InstanceID)
+
+			ret, err := v.bootPooledSandbox(vm, req.Config, volumes)
+			if err == nil {
+				handleElasticIP(req.Config, vm.GetName())
+				if v.spotPrice(&awsAnnotations{}) != "" {
+					v.watchSpot(ret, vm.InstanceID)
+				}
+			}
+
+			return ret, err
+		}
+	}
+
 	podIp := v.ipList.Shift().(string)
 
 	vm := v.createVM(req.Config, podIp)
 
+	name := podIp
+	if err := v.sizeVM(vm, req.Config); err != nil {
+		v.ipList.Append(podIp)
+		return nil, fmt.Errorf("RunPodSandbox: %v", err)
+	}
+	if err := v.placeVM(vm, req.Config); err != nil {
+		v.ipList.Append(podIp)
+		return nil, fmt.Errorf("RunPodSandbox: %v", err)
+	}
+	if err := v.imageVM(vm, req.Config); err != nil {
+		v.ipList.Append(podIp)
+		return nil, fmt.Errorf("RunPodSandbox: %v", err)
+	}
+	if vm.Subnet != v.getConfig().Subnet {
+		// our ips all come from the configured subnet, so EC2 has to pick one in the other subnet
+		vm.PrivateIPAddress = ""
+		if !v.imagePod {
+			v.ipList.Append(podIp)
+			name = ""
+		}
+	}
+
 	if !v.imagePod { // Traditional Pod, but within a VM
-		ret, err := v.bootSandbox(vm, req.Config, podIp, volumes)
+		ret, err := v.bootSandbox(ctx, vm, req.Config, name, volumes)
 
 		if err == nil { //i.e. boot succeeded
 			handleElasticIP(req.Config, vm.GetName())
+			if v.spotPrice(parseAWSAnnotations(req.Config.Annotations)) != "" {
+				v.watchSpot(ret, vm.InstanceID)
+			}
 		}
 
 		return ret, err
@@ -240,13 +705,29 @@ func (v *awsPodProvider) RunPodSandbox(req *kubeapi.RunPodSandboxRequest, volume
 		booted := false
 
 		podData := common.NewPodData(vm, podIp, req.Config.Metadata, req.Config.Annotations, req.Config.Labels, podIp, req.Config.Linux, client, booted, providerData)
+		podData.ReadyCacheInterval = v.readyCacheInterval()
+		podData.NetworkMode = v.getConfig().NetworkMode
 
 		return podData, nil
 	}
 }
 
+func (v *awsPodProvider) bootPooledSandbox(vm *awsvm.VM, config *kubeapi.PodSandboxConfig, volumes []*types.Volume) (*common.PodData, error) {
+	ips, err := vm.GetIPs()
+	if err == nil && (len(ips) < 2 || ips[1] == nil) {
+		err = fmt.Errorf("GetIPs() didn't return a private ip: %v", ips)
+	}
+	if err != nil {
+		vm.Destroy()
+		v.ipList.Append(vm.PrivateIPAddress)
+		return nil, fmt.Errorf("bootPooledSandbox: %v", err)
+	}
+
+	return v.setupSandbox(vm, ips, config, ips[1].String(), volumes)
+}
+
 // FIXME: if booting a VM here fails, do we want to fail the whole pod?
-func (v *awsPodProvider) PreCreateContainer(data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+func (v *awsPodProvider) PreCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
 	data.BootLock.Lock()
 	defer data.BootLock.Unlock()
 
@@ -284,15 +765,18 @@ func (v *awsPodProvider) PreCreateContainer(data *common.PodData, req *kubeapi.C
 		return fmt.Errorf("PreCreateContainer: Couldn't translate %v: err = %v and result = %v", req.Config.Image.Image, err, result)
 	}
 */
-	// Don't need to convert, getting the AMI here
+	// Don't need to convert, the image provider's Translate already resolved it to an AMI id
 	vm.AMI = req.Config.Image.Image
 
-	newPodData, err := v.bootSandbox(vm, req.SandboxConfig, data.Ip, volumes)
+	newPodData, err := v.bootSandbox(ctx, vm, req.SandboxConfig, data.Ip, volumes)
 	if err != nil {
 		return fmt.Errorf("PreCreateContainer: couldn't boot VM: %v", err)
 	}
 
 	handleElasticIP(req.GetSandboxConfig(), vm.GetName())
+	if v.spotPrice(parseAWSAnnotations(req.GetSandboxConfig().GetAnnotations())) != "" {
+		v.watchSpot(data, vm.InstanceID)
+	}
 
 	data.Booted = true
 
@@ -302,16 +786,18 @@ func (v *awsPodProvider) PreCreateContainer(data *common.PodData, req *kubeapi.C
 	return nil
 }
 
-func (v *awsPodProvider) StopPodSandbox(pdata *common.PodData) {
-	providerData, ok := pdata.ProviderData.(*podData)
-	providerData.lock.Lock()
-	defer providerData.lock.Unlock()
+func (v *awsPodProvider) StopPodSandbox(ctx context.Context, pdata *common.PodData) error {
+	conf := v.getConfig()
 
+	providerData, ok := pdata.ProviderData.(*podData)
 	if !ok {
 		glog.Warningf("StopPodSandbox: couldn't type assert ProviderData to podData")
-		return
+		return nil
 	}
 
+	providerData.lock.Lock()
+	defer providerData.lock.Unlock()
+
 	for _, vol := range providerData.volumes {
 		if vol.MountPoint != "" {
 			err := pdata.Client.UnmountFs(vol.MountPoint)
@@ -326,16 +812,186 @@ func (v *awsPodProvider) StopPodSandbox(pdata *common.PodData) {
 	}
 
 	providerData.volumes = nil
+
+	// the instance itself is only terminated when the pod is removed
+	if (conf.StopInstances || conf.HibernateOnStop) && pdata.Booted && pdata.VM != nil {
+		if err := pdata.VM.Halt(); err != nil {
+			glog.Warningf("StopPodSandbox: couldn't stop instance of %v: %v", pdata.Id, err)
+		} else {
+			providerData.stopped = true
+		}
+	}
+
+	return nil
+}
+
+// ResumePodSandbox starts a hibernated pod's instance back up.  Its private ip, and so the pod's, stays the same, but the
+// vmserver is dialed again as IPSelection may pick the public ip, which doesn't.
+func (v *awsPodProvider) ResumePodSandbox(ctx context.Context, data *common.PodData, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (bool, error) {
+	if !v.getConfig().HibernateOnStop || v.imagePod {
+		return false, nil
+	}
+
+	vm, ok := data.VM.(*awsvm.VM)
+	if !ok {
+		return false, nil
+	}
+
+	providerData, ok := data.ProviderData.(*podData)
+	if !ok {
+		return false, nil
+	}
+
+	providerData.lock.Lock()
+	stopped := providerData.stopped
+	providerData.lock.Unlock()
+
+	if !stopped {
+		return false, nil
+	}
+
+	glog.Infof("ResumePodSandbox: starting %v for %v", vm.InstanceID, data.Id)
+
+	if err := vm.Start(); err != nil {
+		return false, fmt.Errorf("ResumePodSandbox: %v", err)
+	}
+
+	running := &ec2.DescribeInstancesInput{InstanceIds: []*string{aws.String(vm.InstanceID)}}
+	if err := client.WaitUntilInstanceRunning(running); err != nil {
+		return false, fmt.Errorf("ResumePodSandbox: %v didn't start: %v", vm.InstanceID, err)
+	}
+
+	ips, err := vm.GetIPs()
+	if err == nil && (len(ips) < 2 || ips[1] == nil) {
+		err = fmt.Errorf("GetIPs() didn't return a private ip: %v", ips)
+	}
+	if err != nil {
+		return false, fmt.Errorf("ResumePodSandbox: %v", err)
+	}
+
+	newData, err := v.setupSandbox(vm, ips, req.Config, data.Id, volumes)
+	if err != nil {
+		return false, fmt.Errorf("ResumePodSandbox: %v", err)
+	}
+
+	data.Resume(req.Config, newData.Client, newData.ProviderData)
+
+	return true, nil
 }
 
 func (v *awsPodProvider) RemovePodSandbox(data *common.PodData) {
+	if vm, ok := data.VM.(*awsvm.VM); ok {
+		v.unwatchSpot(vm.InstanceID)
+	}
+
+	// pods placed in another subnet have an ip EC2 picked, which isn't ours to hand out again
+	if !strings.HasPrefix(data.Ip, *flags.IPBase+".") {
+		glog.Infof("RemovePodSandbox: not releasing IP %v from outside of %v", data.Ip, v.getConfig().Subnet)
+		return
+	}
+
 	glog.Infof("RemovePodSandbox: release IP: %v", data.Ip)
 
 	v.ipList.Append(data.Ip)
 }
 
+// ExplainDestroyError calls out instances with termination protection, the kubelet will keep failing to remove their pod
+// until it is turned off
+func (v *awsPodProvider) ExplainDestroyError(podData *common.PodData, err error) error {
+	vm, ok := podData.VM.(*awsvm.VM)
+	if !ok {
+		return err
+	}
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "OperationNotPermitted" {
+		glog.Warningf("RemovePodSandbox: %v of %v has termination protection, not removing it", vm.InstanceID, podData.Id)
+		return fmt.Errorf("instance %v has termination protection, disable it to remove the pod: %v", vm.InstanceID, err)
+	}
+
+	return err
+}
+
 func (v *awsPodProvider) PodSandboxStatus(podData *common.PodData) {}
 
+func (v *awsPodProvider) HealthCheck() error {
+	req := &ec2.DescribeRegionsInput{RegionNames: []*string{aws.String(v.getConfig().Region)}}
+	if _, err := client.DescribeRegions(req); err != nil {
+		return fmt.Errorf("HealthCheck: DescribeRegions failed: %v", err)
+	}
+
+	return nil
+}
+
+// VMExists lets the reconciler drop pods whose instance was terminated behind our back
+func (v *awsPodProvider) VMExists(podData *common.PodData) (bool, error) {
+	vm, ok := podData.VM.(*awsvm.VM)
+	if !ok {
+		return false, fmt.Errorf("VMExists: %v isn't an aws vm", podData.Id)
+	}
+
+	state, err := vm.GetState()
+	if err != nil {
+		// terminated instances are only described for about an hour, after that they are not found
+		if err == awsvm.ErrNoInstance || strings.Contains(err.Error(), "InvalidInstanceID.NotFound") {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return state != awsvm.StateDestroyed && state != "shutting-down", nil
+}
+
+// RemoveOrphan terminates the instances of ours with id, a pod ip, as their private ip.  Only pods in the configured
+// subnet have our ips for ids, the others can't be found this way.  An ip that isn't free is another sandbox's (i.e. one
+// being created), whose instance is left alone.
+func (v *awsPodProvider) RemoveOrphan(id string) (bool, error) {
+	if net.ParseIP(id) == nil {
+		return false, nil
+	}
+
+	request := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("private-ip-address"), Values: []*string{aws.String(id)}},
+			{Name: aws.String("tag-key"), Values: []*string{aws.String("infranetes")}},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []*string{aws.String("pending"), aws.String("running"), aws.String("stopping"), aws.String("stopped")},
+			},
+		},
+	}
+	result, err := client.DescribeInstances(request)
+	if err != nil {
+		return false, fmt.Errorf("RemoveOrphan: DescribeInstances failed: %v", err)
+	}
+
+	// checked after describing, an instance created for a sandbox that has taken the ip since then isn't in the result
+	if !v.ipList.Contains(id) {
+		if len(result.Reservations) > 0 {
+			glog.Infof("RemoveOrphan: %v is in use again, leaving its instance alone", id)
+		}
+		return false, nil
+	}
+
+	found := false
+	for _, resv := range result.Reservations {
+		for _, instance := range resv.Instances {
+			found = true
+			vm := &awsvm.VM{
+				InstanceID: aws.StringValue(instance.InstanceId),
+				Region:     v.getConfig().Region,
+			}
+
+			glog.Infof("RemoveOrphan: terminating %v of %v", vm.InstanceID, id)
+			v.unwatchSpot(vm.InstanceID)
+			if err := vm.Destroy(); err != nil {
+				return found, fmt.Errorf("RemoveOrphan: couldn't terminate %v: %v", vm.InstanceID, err)
+			}
+		}
+	}
+
+	return found, nil
+}
+
 func listInstances() ([]*ec2.Instance, error) {
 	filters := []*ec2.Filter{
 		{
@@ -374,13 +1030,19 @@ func (v *awsPodProvider) ListInstances() ([]*common.PodData, error) {
 
 	podDatas := []*common.PodData{}
 	for _, instance := range instances {
-		podIp := *instance.PrivateIpAddress
-		client, err := common.CreateRealClient(podIp)
+		ips := []net.IP{net.ParseIP(aws.StringValue(instance.PublicIpAddress)), net.ParseIP(aws.StringValue(instance.PrivateIpAddress))}
+		dialIp, err := v.getConfig().IPSelection.Select(ips)
+		if err != nil {
+			glog.Warningf("ListInstances: skipping %v: %v", aws.StringValue(instance.InstanceId), err)
+			continue
+		}
+
+		client, err := common.CreateRealClient(dialIp.String(), v.getConfig().AgentPort)
 		if err != nil {
 			return nil, fmt.Errorf("CreatePodSandbox: error in createClient(): %v", err)
 		}
 
-		podIp, err = client.GetPodIP()
+		podIp, err := client.GetPodIP()
 		if err != nil {
 			continue
 		}
@@ -394,7 +1056,7 @@ func (v *awsPodProvider) ListInstances() ([]*common.PodData, error) {
 
 		vm := &awsvm.VM{
 			InstanceID: *instance.InstanceId,
-			Region:     v.config.Region,
+			Region:     v.getConfig().Region,
 		}
 
 		providerData := &podData{}
@@ -404,6 +1066,12 @@ func (v *awsPodProvider) ListInstances() ([]*common.PodData, error) {
 		glog.Infof("ListInstances: creating a podData for %v", name)
 		booted := true
 		podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+		podData.ReadyCacheInterval = v.readyCacheInterval()
+		podData.NetworkMode = v.getConfig().NetworkMode
+
+		if instance.SpotInstanceRequestId != nil {
+			v.watchSpot(podData, *instance.InstanceId)
+		}
 
 		podDatas = append(podDatas, podData)
 	}
@@ -411,25 +1079,170 @@ func (v *awsPodProvider) ListInstances() ([]*common.PodData, error) {
 	return podDatas, nil
 }
 
+func (v *awsPodProvider) InstanceId(data *common.PodData) string {
+	if vm, ok := data.VM.(*awsvm.VM); ok {
+		return vm.InstanceID
+	}
+
+	return ""
+}
+
+// GetVMList returns the ids of our running instances, idle pool instances aren't ours until claimed
+func (v *awsPodProvider) GetVMList() ([]string, error) {
+	instances, err := listInstances()
+	if err != nil {
+		return nil, fmt.Errorf("GetVMList: %v", err)
+	}
+
+	ids := []string{}
+	for _, instance := range instances {
+		ids = append(ids, aws.StringValue(instance.InstanceId))
+	}
+
+	return ids, nil
+}
+
+func (v *awsPodProvider) VMName(data *common.PodData) string {
+	return v.InstanceId(data)
+}
+
+// readyCacheInterval is StateCacheSeconds as a PodData.ReadyCacheInterval, 0 if unset
+func (v *awsPodProvider) readyCacheInterval() time.Duration {
+	return time.Duration(v.getConfig().StateCacheSeconds) * time.Second
+}
+
+// RestorePod mirrors what ListInstances builds for a running instance
+func (v *awsPodProvider) RestorePod(instanceId string, data *common.PodData) error {
+	if instanceId == "" {
+		return errors.New("RestorePod: no instance id saved")
+	}
+
+	data.VM = &awsvm.VM{
+		InstanceID: instanceId,
+		Region:     v.getConfig().Region,
+	}
+	data.ProviderData = &podData{}
+	data.ReadyCacheInterval = v.readyCacheInterval()
+	data.NetworkMode = v.getConfig().NetworkMode
+
+	v.ipList.FindAndRemove(data.Ip)
+
+	return nil
+}
+
+// sizeVM picks the instance type from the pod's cpu and memory annotations, unless it asked for one explicitly
+func (v *awsPodProvider) sizeVM(vm *awsvm.VM, config *kubeapi.PodSandboxConfig) error {
+	if parseAWSAnnotations(config.Annotations).instanceType != "" {
+		return nil
+	}
+
+	size, err := common.ResolveSize(v.getConfig().InstanceSizes, config.Annotations)
+	if err != nil {
+		return err
+	}
+
+	if size != "" {
+		glog.Infof("sizeVM: booting instance type %v", size)
+		vm.InstanceType = size
+	}
+
+	return nil
+}
+
+// imageVM resolves the pod's infranetes.aws.image annotation to the AMI vm boots, if it has one
+func (v *awsPodProvider) imageVM(vm *awsvm.VM, config *kubeapi.PodSandboxConfig) error {
+	image := parseAWSAnnotations(config.Annotations).ami
+	if image == "" || v.resolveImage == nil {
+		return nil
+	}
+
+	ami, err := v.resolveImage(image)
+	if err != nil {
+		return err
+	}
+
+	if ami != image {
+		glog.Infof("imageVM: resolved %v to %v", image, ami)
+	}
+	vm.AMI = ami
+
+	return nil
+}
+
+// rootDeviceVM points vm's root volume, the first one, at the device its AMI boots from
+func (v *awsPodProvider) rootDeviceVM(vm *awsvm.VM) error {
+	device := v.getConfig().RootDeviceName
+
+	if device == "" {
+		v.rootDeviceLock.Lock()
+		defer v.rootDeviceLock.Unlock()
+
+		device = v.rootDevices[vm.AMI]
+		if device == "" {
+			var err error
+			if device, err = amiRootDevice(vm.AMI); err != nil {
+				return err
+			}
+			glog.Infof("rootDeviceVM: %v boots from %v", vm.AMI, device)
+			v.rootDevices[vm.AMI] = device
+		}
+	}
+
+	vm.Volumes[0].DeviceName = device
+
+	return nil
+}
+
+// placeVM moves vm into the subnet the pod's subnet / availability zone annotations ask for, if any
+func (v *awsPodProvider) placeVM(vm *awsvm.VM, config *kubeapi.PodSandboxConfig) error {
+	conf := v.getConfig()
+
+	anno := parseAWSAnnotations(config.Annotations)
+
+	// our ec2 client can only check subnets in the configured region
+	if anno.region != "" && anno.region != conf.Region {
+		glog.Warningf("placeVM: pod is in region %v, not validating its subnet", anno.region)
+		return nil
+	}
+
+	subnet, err := resolveSubnet(conf.Vpc, conf.Subnet, anno)
+	if err != nil {
+		return err
+	}
+
+	if subnet != conf.Subnet {
+		glog.Infof("placeVM: booting instance in subnet %v", subnet)
+	}
+	vm.Subnet = subnet
+
+	return nil
+}
+
 func (v *awsPodProvider) createVM(config *kubeapi.PodSandboxConfig, podIp string) *awsvm.VM {
+	conf := v.getConfig()
+
 	aAnno := parseAWSAnnotations(config.Annotations)
 
 	vm := &awsvm.VM{
-		AMI:              v.config.Ami,
+		AMI:              conf.Ami,
 		InstanceType:     "t2.micro",
-		Region:           v.config.Region,
-		KeyPair:          strings.TrimSuffix(filepath.Base(v.config.SshKey), filepath.Ext(v.config.SshKey)),
-		SecurityGroups:   []string{v.config.SecurityGroup},
-		Subnet:           v.config.Subnet,
+		Region:           conf.Region,
+		KeyPair:          conf.KeyPairName,
+		SecurityGroups:   append([]string{}, conf.SecurityGroup...),
+		Subnet:           conf.Subnet,
 		PrivateIPAddress: podIp,
 
+		IamInstanceProfileName: conf.IamInstanceProfile,
+
 		Volumes: []awsvm.EBSVolume{
 			{
-				DeviceName: "/dev/sda1",
+				// replaced by rootDeviceVM() once the AMI is known
+				DeviceName: defaultRootDevice,
+				VolumeSize: conf.RootVolumeSizeGB,
 			},
 		},
 		SSHCreds: ssh.Credentials{
-			SSHUser:       "ubuntu",
+			SSHUser:       conf.SshUser,
 			SSHPrivateKey: v.key,
 		},
 	}
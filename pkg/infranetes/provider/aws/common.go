@@ -1,14 +1,19 @@
 package aws
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
 )
 
 var (
@@ -39,12 +44,176 @@ func initEC2(region string) {
 
 }
 
+// stringList is a list of strings in aws.json that can also be given as a single string
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		// "" is the same as leaving it out, so validate() still catches it
+		*l = nil
+		if single != "" {
+			*l = stringList{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("expected a string or a list of strings: %v", err)
+	}
+	*l = stringList(list)
+
+	return nil
+}
+
 type awsConfig struct {
-	Ami           string
-	RouteTable    string
-	Region        string
-	SecurityGroup string
+	Ami        string
+	RouteTable string
+	Region     string
+	// SecurityGroup is one security group id, or a list of them to attach all of them to every instance
+	SecurityGroup stringList
 	Vpc           string
 	Subnet        string
 	SshKey        string
+
+	// KeyPairName is the EC2 key pair instances are launched with, SshKey has to be its private half.  Defaults to
+	// SshKey's file name without its extension.  With ImportKeyPair, a missing key pair is created from SshKey.
+	KeyPairName   string
+	ImportKeyPair bool
+
+	// SshUser is the AMI's login user (i.e. ec2-user on Amazon Linux, centos on CentOS), defaults to ubuntu
+	SshUser string
+
+	// IPSelection picks the address we dial the vmserver on, defaults to the private ip.  Pods always get the private ip.
+	IPSelection common.IPSelector
+
+	// PoolSize is how many idle instances to keep provisioned for pods that don't override any aws settings
+	PoolSize int
+
+	// ExtraTags are applied to every instance we launch (i.e. for cost allocation)
+	ExtraTags map[string]string
+
+	// RootVolumeSizeGB of 0 keeps the default root volume size
+	RootVolumeSizeGB int
+
+	// RootDeviceName is the device the AMI boots from, i.e. /dev/xvda, which the root volume settings are applied to.
+	// Unset looks it up from the AMI, as a mapping for any other device is attached as a second, empty volume.
+	RootDeviceName string
+
+	// UseSpot launches pods on one time spot instances bidding at most MaxSpotPrice (USD per hour, e.g. "0.05").
+	// Pods can opt in or out with the infranetes.aws.spot annotation.
+	UseSpot      bool
+	MaxSpotPrice string
+
+	// ProvisionRetries is how many extra attempts are made to provision a VM, 0 is none and unset is
+	// defaultProvisionRetries.  ProvisionBackoff is the initial wait (in seconds) between attempts, doubled after every
+	// failure, unset or 0 is defaultProvisionBackoff.
+	ProvisionRetries *int
+	ProvisionBackoff int
+
+	// UserDataFile is a text/template of the user data (i.e. a cloud-init config) every instance is launched with, given
+	// the pod's Namespace, Name, Uid, Labels and Annotations.  Unset launches instances without user data.
+	UserDataFile string
+
+	// InstanceSizes, smallest first, is what pods' infranetes.cpu and infranetes.memory annotations pick their instance
+	// type from, defaults to defaultInstanceSizes.  The infranetes.aws.instancetype annotation still wins.
+	InstanceSizes []common.Size
+
+	// StopInstances stops, rather than leaves running, a stopped pod's instance until the pod is removed.  Spot instances
+	// can't be stopped, so keep running.
+	StopInstances bool
+
+	// HibernateOnStop stops a stopped pod's instance like StopInstances, and starts it back up for the pod's next sandbox
+	// rather than launching a new one, so whatever is on its disks survives
+	HibernateOnStop bool
+
+	// TerminationProtection sets DisableApiTermination on every pod's instance, so removing the pod fails until it's
+	// turned off by hand.  Pods can opt in or out with the infranetes.aws.terminationprotection annotation.  Spot
+	// instances can't be protected.
+	TerminationProtection bool
+
+	// StateCacheSeconds is how long an instance's vmserver being ready is trusted before it's asked again, lower notices
+	// dead instances sooner, higher makes fewer calls on big clusters.  0 uses --ready-cache-interval.
+	StateCacheSeconds int
+
+	// MaxConcurrentProvision bounds how many instances are being launched at once, the rest wait their turn so a burst
+	// of pods doesn't run into RunInstances rate limits.  0, the default, is unlimited.
+	MaxConcurrentProvision int
+
+	// NetworkMode overrides the network namespace pods are reported to be in, common.NetworkModeHost or
+	// common.NetworkModePod.  Unset reports what each pod asked for.
+	NetworkMode string
+
+	// IamInstanceProfile is the name of the instance profile every instance is launched with, so pods get its role's
+	// credentials from the metadata endpoint.  Pods can pick another one with the infranetes.aws.iaminstancename
+	// annotation.
+	IamInstanceProfile string
+
+	// AgentPort is where vmserver listens in the instance, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
+}
+
+var defaultInstanceSizes = []common.Size{
+	{Name: "t2.micro", CPUs: 1, MemoryMB: 1024},
+	{Name: "t2.small", CPUs: 1, MemoryMB: 2048},
+	{Name: "t2.medium", CPUs: 2, MemoryMB: 4096},
+	{Name: "t2.large", CPUs: 2, MemoryMB: 8192},
+	{Name: "t2.xlarge", CPUs: 4, MemoryMB: 16384},
+	{Name: "t2.2xlarge", CPUs: 8, MemoryMB: 32768},
+}
+
+const (
+	defaultSshUser = "ubuntu"
+
+	defaultProvisionRetries = 3
+	defaultProvisionBackoff = 2
+)
+
+// validate returns an error naming every required field missing from aws.json
+func (c *awsConfig) validate() error {
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"Ami", c.Ami},
+		{"RouteTable", c.RouteTable},
+		{"Region", c.Region},
+		{"SecurityGroup", strings.Join(c.SecurityGroup, ",")},
+		{"Vpc", c.Vpc},
+		{"Subnet", c.Subnet},
+		{"SshKey", c.SshKey},
+	}
+
+	missing := []string{}
+	for _, field := range required {
+		if field.value == "" {
+			missing = append(missing, field.name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("aws.json is missing required fields: %v", strings.Join(missing, ", "))
+	}
+
+	if c.UseSpot && c.MaxSpotPrice == "" {
+		return fmt.Errorf("aws.json sets UseSpot without a MaxSpotPrice")
+	}
+
+	if err := common.ValidateNetworkMode(c.NetworkMode); err != nil {
+		return fmt.Errorf("aws.json: %v", err)
+	}
+
+	if c.IamInstanceProfile != "" {
+		if err := validateIamInstanceProfile(c.IamInstanceProfile); err != nil {
+			return fmt.Errorf("aws.json IamInstanceProfile: %v", err)
+		}
+	}
+
+	if c.MaxConcurrentProvision < 0 {
+		return fmt.Errorf("aws.json MaxConcurrentProvision can't be negative")
+	}
+
+	return c.IPSelection.Validate()
 }
@@ -0,0 +1,231 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	awsvm "github.com/apcera/libretto/virtualmachine/aws"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	// how often we ask EC2 whether any of our spot instances are being reclaimed
+	spotCheckInterval = 30 * time.Second
+)
+
+// spotPrice returns the max price to bid for the pod's instance, or "" if it should be an on demand one.  The
+// infranetes.aws.spot annotation overrides UseSpot.
+func (v *awsPodProvider) spotPrice(anno *awsAnnotations) string {
+	conf := v.getConfig()
+
+	useSpot := conf.UseSpot
+	switch anno.spot {
+	case "true":
+		useSpot = true
+	case "false":
+		useSpot = false
+	}
+
+	if !useSpot {
+		return ""
+	}
+
+	if conf.MaxSpotPrice == "" {
+		glog.Warningf("spotPrice: spot instance requested but MaxSpotPrice isn't configured, using an on demand instance")
+		return ""
+	}
+
+	// spot requests go through our ec2 client, which only talks to the configured region
+	if anno.region != "" && anno.region != conf.Region {
+		glog.Warningf("spotPrice: spot instances are only supported in %v, using an on demand instance in %v", conf.Region, anno.region)
+		return ""
+	}
+
+	return conf.MaxSpotPrice
+}
+
+// provisionSpot does what vm.Provision() does, but as a one time spot request as libretto can only launch on demand
+// instances.  Like Provision(), it leaves vm.InstanceID set if an instance was created, even on failure.
+func provisionSpot(vm *awsvm.VM, price string, userData string) error {
+	if vm.Name == "" {
+		vm.Name = "infranetes-spot-" + vm.PrivateIPAddress
+	}
+	if vm.InstanceType == "" {
+		vm.InstanceType = "t2.micro"
+	}
+
+	nic := &ec2.InstanceNetworkInterfaceSpecification{
+		DeviceIndex: aws.Int64(0),
+		SubnetId:    aws.String(vm.Subnet),
+		Groups:      aws.StringSlice(vm.SecurityGroups),
+	}
+	if vm.PrivateIPAddress != "" {
+		nic.PrivateIpAddress = aws.String(vm.PrivateIPAddress)
+	}
+
+	spec := &ec2.RequestSpotLaunchSpecification{
+		ImageId:             aws.String(vm.AMI),
+		InstanceType:        aws.String(vm.InstanceType),
+		KeyName:             aws.String(vm.KeyPair),
+		BlockDeviceMappings: blockDevices(vm),
+		Monitoring:          &ec2.RunInstancesMonitoringEnabled{Enabled: aws.Bool(true)},
+		NetworkInterfaces:   []*ec2.InstanceNetworkInterfaceSpecification{nic},
+	}
+	if userData != "" {
+		spec.UserData = aws.String(userData)
+	}
+	if vm.IamInstanceProfileName != "" {
+		spec.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Name: aws.String(vm.IamInstanceProfileName)}
+	}
+
+	resp, err := client.RequestSpotInstances(&ec2.RequestSpotInstancesInput{
+		InstanceCount:       aws.Int64(1),
+		SpotPrice:           aws.String(price),
+		Type:                aws.String(ec2.SpotInstanceTypeOneTime),
+		LaunchSpecification: spec,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to request spot instance: %v", err)
+	}
+	if len(resp.SpotInstanceRequests) == 0 {
+		return errors.New("spot request returned no requests")
+	}
+
+	reqId := resp.SpotInstanceRequests[0].SpotInstanceRequestId
+	describe := &ec2.DescribeSpotInstanceRequestsInput{SpotInstanceRequestIds: []*string{reqId}}
+
+	werr := client.WaitUntilSpotInstanceRequestFulfilled(describe)
+	if werr != nil {
+		if _, err := client.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{SpotInstanceRequestIds: []*string{reqId}}); err != nil {
+			glog.Warningf("provisionSpot: couldn't cancel spot request %v: %v", *reqId, err)
+		}
+	}
+
+	// even if waiting failed, the request may have been fulfilled before it was cancelled
+	out, err := client.DescribeSpotInstanceRequests(describe)
+	if err == nil && len(out.SpotInstanceRequests) > 0 {
+		vm.InstanceID = aws.StringValue(out.SpotInstanceRequests[0].InstanceId)
+	}
+
+	if werr != nil {
+		return fmt.Errorf("spot request %v wasn't fulfilled: %v", *reqId, werr)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't describe spot request %v: %v", *reqId, err)
+	}
+	if vm.InstanceID == "" {
+		return fmt.Errorf("spot request %v has no instance", *reqId)
+	}
+
+	if err := client.WaitUntilInstanceRunning(&ec2.DescribeInstancesInput{InstanceIds: []*string{aws.String(vm.InstanceID)}}); err != nil {
+		return fmt.Errorf("spot instance %v didn't start: %v", vm.InstanceID, err)
+	}
+
+	return nil
+}
+
+// blockDevices mirrors the block device mappings libretto builds for RunInstances
+func blockDevices(vm *awsvm.VM) []*ec2.BlockDeviceMapping {
+	devices := []*ec2.BlockDeviceMapping{}
+	for _, volume := range vm.Volumes {
+		size := volume.VolumeSize
+		if size == 0 {
+			size = 8
+		}
+		volType := volume.VolumeType
+		if volType == "" {
+			volType = "gp2"
+		}
+
+		devices = append(devices, &ec2.BlockDeviceMapping{
+			DeviceName: aws.String(volume.DeviceName),
+			Ebs: &ec2.EbsBlockDevice{
+				VolumeSize:          aws.Int64(int64(size)),
+				VolumeType:          aws.String(volType),
+				DeleteOnTermination: aws.Bool(!vm.KeepRootVolumeOnDestroy),
+			},
+		})
+	}
+
+	return devices
+}
+
+// watchSpot has checkSpot keep an eye on a pod running on a spot instance
+func (v *awsPodProvider) watchSpot(data *common.PodData, instanceId string) {
+	v.spotLock.Lock()
+	defer v.spotLock.Unlock()
+
+	v.spotPods[instanceId] = data
+}
+
+func (v *awsPodProvider) unwatchSpot(instanceId string) {
+	v.spotLock.Lock()
+	defer v.spotLock.Unlock()
+
+	delete(v.spotPods, instanceId)
+}
+
+func (v *awsPodProvider) spotWatcher() {
+	for range time.Tick(spotCheckInterval) {
+		v.checkSpot()
+	}
+}
+
+// checkSpot marks pods whose spot instance is marked for termination (or already gone) as not ready, so the kubelet
+// can reschedule them without waiting for the VM to stop answering
+func (v *awsPodProvider) checkSpot() {
+	v.spotLock.Lock()
+	ids := []*string{}
+	for id := range v.spotPods {
+		ids = append(ids, aws.String(id))
+	}
+	v.spotLock.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	resp, err := client.DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: ids}},
+	})
+	if err != nil {
+		glog.Warningf("checkSpot: DescribeSpotInstanceRequests failed: %v", err)
+		return
+	}
+
+	for _, req := range resp.SpotInstanceRequests {
+		code := ""
+		if req.Status != nil {
+			code = aws.StringValue(req.Status.Code)
+		}
+		if code != "marked-for-termination" && !strings.HasPrefix(code, "instance-terminated") {
+			continue
+		}
+
+		instanceId := aws.StringValue(req.InstanceId)
+
+		v.spotLock.Lock()
+		data, ok := v.spotPods[instanceId]
+		delete(v.spotPods, instanceId)
+		v.spotLock.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		glog.Warningf("checkSpot: spot instance %v of pod %v is being reclaimed (%v), marking it not ready", instanceId, data.Id, code)
+
+		data.Lock()
+		data.PodState = kubeapi.PodSandboxState_SANDBOX_NOTREADY
+		data.Unlock()
+	}
+}
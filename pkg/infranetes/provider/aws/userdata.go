@@ -0,0 +1,120 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+
+	awsvm "github.com/apcera/libretto/virtualmachine/aws"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	// EC2 rejects user data over 16KB, before it is base64 encoded
+	maxUserData = 16 * 1024
+)
+
+// userDataVars is what a UserDataFile template can refer to, i.e. {{.Namespace}} or {{index .Labels "app"}}.  Pool
+// instances are launched before their pod is known, so they see all of it empty.
+type userDataVars struct {
+	Namespace   string
+	Name        string
+	Uid         string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func loadUserData(path string) (*template.Template, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read UserDataFile: %v", err)
+	}
+
+	tmpl, err := template.New(path).Option("missingkey=zero").Parse(string(buf))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse UserDataFile: %v", err)
+	}
+
+	return tmpl, nil
+}
+
+// renderUserData returns the base64 encoded user data for the pod's instance, "" if no UserDataFile is configured
+func (v *awsPodProvider) renderUserData(config *kubeapi.PodSandboxConfig) (string, error) {
+	if v.userData == nil {
+		return "", nil
+	}
+
+	vars := userDataVars{
+		Labels:      config.Labels,
+		Annotations: config.Annotations,
+	}
+	if config.Metadata != nil {
+		vars.Namespace = config.Metadata.Namespace
+		vars.Name = config.Metadata.Name
+		vars.Uid = config.Metadata.Uid
+	}
+
+	var buf bytes.Buffer
+	if err := v.userData.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("couldn't render user data: %v", err)
+	}
+
+	if buf.Len() > maxUserData {
+		return "", fmt.Errorf("rendered user data is %d bytes, EC2 allows at most %d", buf.Len(), maxUserData)
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// provisionWithUserData does what vm.Provision() does, as libretto has no way to pass user data into RunInstances.  Like
+// Provision(), it leaves vm.InstanceID set if an instance was created, even on failure.
+func provisionWithUserData(vm *awsvm.VM, userData string) error {
+	if vm.InstanceType == "" {
+		vm.InstanceType = "t2.micro"
+	}
+
+	input := &ec2.RunInstancesInput{
+		ImageId:             aws.String(vm.AMI),
+		InstanceType:        aws.String(vm.InstanceType),
+		KeyName:             aws.String(vm.KeyPair),
+		MinCount:            aws.Int64(1),
+		MaxCount:            aws.Int64(1),
+		BlockDeviceMappings: blockDevices(vm),
+		Monitoring:          &ec2.RunInstancesMonitoringEnabled{Enabled: aws.Bool(true)},
+		SubnetId:            aws.String(vm.Subnet),
+		SecurityGroupIds:    aws.StringSlice(vm.SecurityGroups),
+		UserData:            aws.String(userData),
+	}
+	if vm.PrivateIPAddress != "" {
+		input.PrivateIpAddress = aws.String(vm.PrivateIPAddress)
+	}
+	if vm.IamInstanceProfileName != "" {
+		input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Name: aws.String(vm.IamInstanceProfileName)}
+	}
+
+	resp, err := client.RunInstances(input)
+	if err != nil {
+		return fmt.Errorf("failed to create instance: %v", err)
+	}
+	if len(resp.Instances) == 0 || resp.Instances[0].InstanceId == nil {
+		return awsvm.ErrNoInstanceID
+	}
+	vm.InstanceID = *resp.Instances[0].InstanceId
+
+	if err := client.WaitUntilInstanceRunning(&ec2.DescribeInstancesInput{InstanceIds: []*string{aws.String(vm.InstanceID)}}); err != nil {
+		return fmt.Errorf("instance %v didn't start: %v", vm.InstanceID, err)
+	}
+
+	if vm.Name != "" {
+		if err := vm.SetTag("Name", vm.GetName()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/golang/glog"
@@ -12,6 +14,11 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	cryptossh "golang.org/x/crypto/ssh"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
 type awsAnnotations struct {
@@ -21,7 +28,29 @@ type awsAnnotations struct {
 	securityGroup string
 	region        string
 	subnet        string
+	availZone     string
 	elasticIP     string
+	rootVolSize   int
+	spot          string
+	protect       string
+	// validated infranetes.aws.datavolumes, see parseDataVolumes()
+	dataVolumes string
+}
+
+// defaultRootDevice is the root device of AMIs that don't say, it's what Ubuntu's use
+const defaultRootDevice = "/dev/sda1"
+
+// iamNameRegexp is what IAM accepts as an instance profile name
+var iamNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{1,128}$`)
+
+// validateIamInstanceProfile checks name could be an instance profile, so a typo is caught here rather than as a less
+// obvious RunInstances error
+func validateIamInstanceProfile(name string) error {
+	if !iamNameRegexp.MatchString(name) {
+		return fmt.Errorf("%q isn't a valid IAM instance profile name", name)
+	}
+
+	return nil
 }
 
 func parseAWSAnnotations(a map[string]string) *awsAnnotations {
@@ -32,7 +61,11 @@ func parseAWSAnnotations(a map[string]string) *awsAnnotations {
 	}
 
 	if tmp, ok := a["infranetes.aws.iaminstancename"]; ok {
-		ret.role = tmp
+		if err := validateIamInstanceProfile(tmp); err != nil {
+			glog.Warningf("parseAWSAnnotations: ignoring infranetes.aws.iaminstancename: %v", err)
+		} else {
+			ret.role = tmp
+		}
 	}
 
 	if tmp, ok := a["infranetes.aws.instancetype"]; ok {
@@ -51,13 +84,108 @@ func parseAWSAnnotations(a map[string]string) *awsAnnotations {
 		ret.subnet = tmp
 	}
 
+	if tmp, ok := a["infranetes.aws.availabilityzone"]; ok {
+		ret.availZone = tmp
+	}
+
 	if tmp, ok := a["infranetes.aws.elasticip"]; ok {
 		ret.elasticIP = tmp
 	}
 
+	if tmp, ok := a["infranetes.aws.rootvolumesize"]; ok {
+		size, err := strconv.Atoi(tmp)
+		if err != nil || size < 0 {
+			glog.Warningf("parseAWSAnnotations: ignoring invalid root volume size %q", tmp)
+		} else {
+			ret.rootVolSize = size
+		}
+	}
+
+	if tmp, ok := a["infranetes.aws.spot"]; ok {
+		if tmp != "true" && tmp != "false" {
+			glog.Warningf("parseAWSAnnotations: ignoring invalid spot value %q, must be true or false", tmp)
+		} else {
+			ret.spot = tmp
+		}
+	}
+
+	if tmp, ok := a["infranetes.aws.terminationprotection"]; ok {
+		if tmp != "true" && tmp != "false" {
+			glog.Warningf("parseAWSAnnotations: ignoring invalid terminationprotection value %q, must be true or false", tmp)
+		} else {
+			ret.protect = tmp
+		}
+	}
+
+	if tmp, ok := a["infranetes.aws.datavolumes"]; ok {
+		if _, err := parseDataVolumes(tmp); err != nil {
+			glog.Warningf("parseAWSAnnotations: ignoring invalid data volumes %q: %v", tmp, err)
+		} else {
+			ret.dataVolumes = tmp
+		}
+	}
+
 	return ret
 }
 
+// the volume types a data volume can be, io1 and io2 would need iops too
+var dataVolumeTypes = map[string]bool{"gp2": true, "gp3": true, "st1": true, "sc1": true, "standard": true}
+
+// parseDataVolumes parses the infranetes.aws.datavolumes annotation, extra EBS volumes for the instance as a comma
+// separated list of <device>:<size in GB>[:<type>], i.e. "/dev/sdf:100,/dev/sdg:500:st1".  Devices have to be one of
+// /dev/sd[f-p] or /dev/xvd[f-p], the ones Attach picks from, so it knows not to use them for the pod's volumes.  Like
+// the root volume they are deleted when the instance is terminated.
+func parseDataVolumes(s string) ([]awsvm.EBSVolume, error) {
+	volumes := []awsvm.EBSVolume{}
+	used := make(map[string]bool)
+
+	for _, spec := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(spec), ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%q isn't <device>:<size>[:<type>]", spec)
+		}
+
+		letter := dataVolumeLetter(fields[0])
+		if letter == "" {
+			return nil, fmt.Errorf("device %v isn't one of /dev/sd[f-p] or /dev/xvd[f-p]", fields[0])
+		}
+		if used[letter] {
+			return nil, fmt.Errorf("device %v is given more than once", fields[0])
+		}
+		used[letter] = true
+
+		size, err := strconv.Atoi(fields[1])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size %q for %v", fields[1], fields[0])
+		}
+
+		volume := awsvm.EBSVolume{DeviceName: fields[0], VolumeSize: size}
+		if len(fields) == 3 {
+			if !dataVolumeTypes[fields[2]] {
+				return nil, fmt.Errorf("unsupported volume type %q for %v", fields[2], fields[0])
+			}
+			volume.VolumeType = fields[2]
+		}
+
+		volumes = append(volumes, volume)
+	}
+
+	return volumes, nil
+}
+
+// dataVolumeLetter is the letter device is kept under in podData.usedDevices, "" if it isn't one a data volume can use
+func dataVolumeLetter(device string) string {
+	for _, prefix := range []string{"/dev/sd", "/dev/xvd"} {
+		if strings.HasPrefix(device, prefix) && len(device) == len(prefix)+1 {
+			if c := device[len(device)-1]; c >= 'f' && c <= 'p' {
+				return string(c)
+			}
+		}
+	}
+
+	return ""
+}
+
 func overrideVMDefault(vm *awsvm.VM, anno *awsAnnotations) {
 	if anno.ami != "" {
 		glog.Infof("ParseAWSAnnotations: overriding ami image with %v", anno.ami)
@@ -89,6 +217,196 @@ func overrideVMDefault(vm *awsvm.VM, anno *awsAnnotations) {
 		glog.Infof("RunPodSandbox: booting instance subnet %v", anno.subnet)
 		vm.Subnet = anno.subnet
 	}
+
+	if anno.rootVolSize != 0 {
+		glog.Infof("RunPodSandbox: booting instance with a %vGB root volume", anno.rootVolSize)
+		vm.Volumes[0].VolumeSize = anno.rootVolSize
+	}
+
+	if anno.dataVolumes != "" {
+		glog.Infof("RunPodSandbox: booting instance with data volumes %v", anno.dataVolumes)
+		volumes, _ := parseDataVolumes(anno.dataVolumes)
+		vm.Volumes = append(vm.Volumes, volumes...)
+	}
+}
+
+const (
+	// marks idle instances in the pool, they don't get the infranetes tag until claimed
+	poolTag = "infranetes.pool"
+
+	// EC2 tag limits
+	maxTags        = 50
+	maxTagKeyLen   = 127
+	maxTagValueLen = 255
+)
+
+// podTags builds the EC2 tags for a pod's instance.  Pod metadata wins over labels, which win over the configured extra
+// tags.  Anything EC2 would reject is dropped so one bad label can't stop the rest from being applied.
+func podTags(config *kubeapi.PodSandboxConfig, extra map[string]string) map[string]string {
+	// ListInstances depends on the infranetes tag, so it always goes in first
+	tags := map[string]string{"infranetes": "true"}
+
+	if md := config.GetMetadata(); md != nil {
+		// what the console shows, the readable namespace and name are in their own tags
+		tags["Name"] = common.VMName(md, maxTagValueLen)
+		tags["infranetes.namespace"] = md.GetNamespace()
+		tags["infranetes.name"] = md.GetName()
+		tags["infranetes.uid"] = md.GetUid()
+	}
+
+	for _, src := range []map[string]string{config.GetLabels(), extra} {
+		for k, v := range src {
+			if _, ok := tags[k]; ok {
+				continue
+			}
+			if k == "" || len(k) > maxTagKeyLen || len(v) > maxTagValueLen || strings.HasPrefix(k, "aws:") {
+				glog.Warningf("podTags: dropping tag %q = %q that EC2 won't accept", k, v)
+				continue
+			}
+			if len(tags) >= maxTags {
+				glog.Warningf("podTags: dropping tag %q, already at EC2's limit of %d tags", k, maxTags)
+				continue
+			}
+			tags[k] = v
+		}
+	}
+
+	return tags
+}
+
+func tagInstance(instanceId string, tags map[string]string) error {
+	ec2Tags := []*ec2.Tag{}
+	for k, v := range tags {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	req := &ec2.CreateTagsInput{
+		Resources: []*string{aws.String(instanceId)},
+		Tags:      ec2Tags,
+	}
+
+	if _, err := client.CreateTags(req); err != nil {
+		return fmt.Errorf("CreateTags failed: %v", err)
+	}
+
+	return nil
+}
+
+func setTerminationProtection(instanceId string, enabled bool) error {
+	req := &ec2.ModifyInstanceAttributeInput{
+		InstanceId:            aws.String(instanceId),
+		DisableApiTermination: &ec2.AttributeBooleanValue{Value: aws.Bool(enabled)},
+	}
+
+	if _, err := client.ModifyInstanceAttribute(req); err != nil {
+		return fmt.Errorf("ModifyInstanceAttribute failed: %v", err)
+	}
+
+	return nil
+}
+
+func untagInstance(instanceId string, key string) error {
+	req := &ec2.DeleteTagsInput{
+		Resources: []*string{aws.String(instanceId)},
+		Tags:      []*ec2.Tag{{Key: aws.String(key)}},
+	}
+
+	if _, err := client.DeleteTags(req); err != nil {
+		return fmt.Errorf("DeleteTags failed: %v", err)
+	}
+
+	return nil
+}
+
+// resolveSubnet picks the subnet for a pod that asks for a specific subnet and/or availability zone, making sure it is in
+// our VPC.  The configured subnet is preferred when it matches, otherwise the matching subnet with the most free ips.
+func resolveSubnet(vpc string, defaultSubnet string, anno *awsAnnotations) (string, error) {
+	if anno.subnet == "" && anno.availZone == "" {
+		return defaultSubnet, nil
+	}
+
+	req := &ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpc)}}},
+	}
+	if anno.subnet != "" {
+		req.SubnetIds = []*string{aws.String(anno.subnet)}
+	}
+	if anno.availZone != "" {
+		req.Filters = append(req.Filters, &ec2.Filter{Name: aws.String("availability-zone"), Values: []*string{aws.String(anno.availZone)}})
+	}
+
+	resp, err := client.DescribeSubnets(req)
+	if err != nil {
+		return "", fmt.Errorf("DescribeSubnets failed: %v", err)
+	}
+	if len(resp.Subnets) == 0 {
+		return "", fmt.Errorf("no subnet in %v matches subnet %q and availability zone %q", vpc, anno.subnet, anno.availZone)
+	}
+
+	best := resp.Subnets[0]
+	for _, subnet := range resp.Subnets {
+		if aws.StringValue(subnet.SubnetId) == defaultSubnet {
+			return defaultSubnet, nil
+		}
+		if aws.Int64Value(subnet.AvailableIpAddressCount) > aws.Int64Value(best.AvailableIpAddressCount) {
+			best = subnet
+		}
+	}
+
+	return aws.StringValue(best.SubnetId), nil
+}
+
+// amiRootDevice returns the device ami boots from, i.e. /dev/sda1 for Ubuntu's AMIs and /dev/xvda for Amazon Linux's
+func amiRootDevice(ami string) (string, error) {
+	resp, err := client.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(ami)}})
+	if err != nil {
+		return "", fmt.Errorf("couldn't find the root device of %v: DescribeImages failed: %v", ami, err)
+	}
+	if len(resp.Images) != 1 {
+		return "", fmt.Errorf("couldn't find the root device of %v: DescribeImages returned %d images", ami, len(resp.Images))
+	}
+
+	device := aws.StringValue(resp.Images[0].RootDeviceName)
+	if device == "" {
+		glog.Warningf("amiRootDevice: %v has no root device name, assuming %v", ami, defaultRootDevice)
+		device = defaultRootDevice
+	}
+
+	return device, nil
+}
+
+// ensureKeyPair checks that the named key pair exists, and if it doesn't and importKey is set, imports the public half
+// of privateKey under that name
+func ensureKeyPair(name string, privateKey []byte, importKey bool) error {
+	_, err := client.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{KeyNames: []*string{aws.String(name)}})
+	if err == nil {
+		return nil
+	}
+
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "InvalidKeyPair.NotFound" {
+		return fmt.Errorf("DescribeKeyPairs failed: %v", err)
+	}
+
+	if !importKey {
+		return fmt.Errorf("key pair %v doesn't exist in this region, import it or set ImportKeyPair", name)
+	}
+
+	signer, err := cryptossh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("couldn't parse SshKey to import it: %v", err)
+	}
+
+	req := &ec2.ImportKeyPairInput{
+		KeyName:           aws.String(name),
+		PublicKeyMaterial: cryptossh.MarshalAuthorizedKey(signer.PublicKey()),
+	}
+	if _, err := client.ImportKeyPair(req); err != nil {
+		return fmt.Errorf("ImportKeyPair failed: %v", err)
+	}
+
+	glog.Infof("ensureKeyPair: imported key pair %v", name)
+
+	return nil
 }
 
 func findBase(subnetId *string) (*string, error) {
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+	"github.com/apporbit/infranetes/pkg/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// BootConfig is what BootSandbox and ConnectSandbox need of a pod provider's config
+type BootConfig struct {
+	// picks the VM address the vmserver is dialed on, which is also the pod's ip
+	IPSelection IPSelector
+	AgentPort   int
+	// added to every pod's VM
+	Routes []common.AddRouteRequest
+}
+
+// BootSandbox provisions vm with provision, which has to clean up whatever it created if it fails, connects to its
+// vmserver and sets it up for the pod sandbox config.  Returns the client and the pod's ip.  Anything but the sandbox's
+// optional settings (i.e. its hostname) failing destroys vm, the pod would be broken without it.
+func BootSandbox(vm VM, provision func() error, config *kubeapi.PodSandboxConfig, conf *BootConfig) (Client, string, error) {
+	cAnno := ParseCommonAnnotations(config.Annotations)
+
+	if err := provision(); err != nil {
+		return nil, "", fmt.Errorf("failed to provision vm: %v", err)
+	}
+
+	ips, err := vm.GetIPs()
+	if err != nil {
+		vm.Destroy()
+		return nil, "", fmt.Errorf("BootSandbox: error in GetIPs(): %v", err)
+	}
+
+	glog.Infof("BootSandbox: ips = %v", ips)
+
+	ip, err := conf.IPSelection.Select(ips)
+	if err != nil {
+		vm.Destroy()
+		return nil, "", fmt.Errorf("BootSandbox: %v", err)
+	}
+	podIp := ip.String()
+
+	glog.Infof("BootSandbox: podIp = %v", podIp)
+
+	client, err := WaitForAgent(podIp, conf.AgentPort, *flags.VMReadyTimeout)
+	if err != nil {
+		vm.Destroy()
+		return nil, "", fmt.Errorf("BootSandbox: %v", err)
+	}
+
+	// the config is what ConnectSandbox recovers the pod from after a restart
+	if err := client.SetSandboxConfig(config); err != nil {
+		glog.Warningf("BootSandbox: Failed to save sandbox config: %v", err)
+	}
+
+	if err := client.SetPodIP(podIp); err != nil {
+		glog.Warningf("BootSandbox: Failed to configure interface: %v", err)
+	}
+
+	if cAnno.StartProxy {
+		if err := client.StartProxy(); err != nil {
+			client.Close()
+			vm.Destroy()
+			return nil, "", fmt.Errorf("BootSandbox: couldn't start kube-proxy: %v", err)
+		}
+	} else {
+		glog.Infof("BootSandbox: Skipping Proxy")
+	}
+
+	if cAnno.SetHostname {
+		if err := client.SetHostname(config.GetHostname()); err != nil {
+			glog.Warningf("BootSandbox: couldn't set hostname to %v: %v", config.GetHostname(), err)
+		}
+	} else {
+		glog.Infof("BootSandbox: Skipping changing hostname")
+	}
+
+	for _, r := range conf.Routes {
+		glog.Infof("AddRoute: %+v", r)
+		r := r
+		if _, err := client.AddRoute(&r); err != nil {
+			glog.Warningf("BootSandbox: %v", err)
+		}
+	}
+
+	return client, podIp, nil
+}
+
+// ConnectSandbox reconnects to the vmserver of vm, a pod's VM found running at startup, returning the client along with
+// the pod ip and sandbox config BootSandbox saved in it.  The client is closed on failure.
+func ConnectSandbox(vm VM, conf *BootConfig) (Client, string, *kubeapi.PodSandboxConfig, error) {
+	ips, err := vm.GetIPs()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("couldn't get ips: %v", err)
+	}
+
+	ip, err := conf.IPSelection.Select(ips)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	client, err := CreateRealClient(ip.String(), conf.AgentPort)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("couldn't connect: %v", err)
+	}
+
+	podIp, err := client.GetPodIP()
+	if err != nil {
+		client.Close()
+		return nil, "", nil, fmt.Errorf("couldn't get pod ip: %v", err)
+	}
+
+	config, err := client.GetSandboxConfig()
+	if err != nil {
+		client.Close()
+		return nil, "", nil, fmt.Errorf("couldn't get sandbox config: %v", err)
+	}
+
+	return client, podIp, config, nil
+}
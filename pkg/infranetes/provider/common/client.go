@@ -10,8 +10,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -26,6 +29,9 @@ import (
 )
 
 type Client interface {
+	// CreateContainer hands req to the vmserver as is.  Its LogPath is relative to the kubelet's log directory on this
+	// host, not the VM, so the vmserver logging however its runtime does and the caller streaming that back into the path
+	// with SaveLogs once the container starts is what makes kubectl logs work.
 	CreateContainer(req *kubeapi.CreateContainerRequest) (*kubeapi.CreateContainerResponse, error)
 	StartContainer(req *kubeapi.StartContainerRequest) (*kubeapi.StartContainerResponse, error)
 	StopContainer(req *kubeapi.StopContainerRequest) (*kubeapi.StopContainerResponse, error)
@@ -36,6 +42,8 @@ type Client interface {
 	Exec(req *kubeapi.ExecRequest) (*kubeapi.ExecResponse, error)
 	Attach(req *kubeapi.AttachRequest) (*kubeapi.AttachResponse, error)
 	PortForward(req *kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error)
+	ContainerStats(req *kubeapi.ContainerStatsRequest) (*kubeapi.ContainerStatsResponse, error)
+	ListContainerStats(req *kubeapi.ListContainerStatsRequest) (*kubeapi.ListContainerStatsResponse, error)
 
 	StartProxy() error
 	RunCmd(req *common.RunCmdRequest) error
@@ -49,90 +57,224 @@ type Client interface {
 	SetHostname(hostname string) error
 	Close()
 	Version() (*kubeapi.VersionResponse, error)
+	// Ready pings the vmserver.  A booted pod whose vmserver doesn't answer is reported not ready, even if its VM is
+	// running, see PodData.GetPodState().
 	Ready() error
+	// WaitReady waits until the vmserver can answer for its container runtime, not just accept connections
+	WaitReady(timeout time.Duration) error
+	// SaveLogs follows the container's output in the VM and writes it line by line to path on this host, returning once
+	// the container's log ends
 	SaveLogs(container string, path string) error
+	// ReopenContainerLog has SaveLogs start a new file at the container's log path, i.e. after it was rotated
+	ReopenContainerLog(container string) error
 	GetMetric(req *common.GetMetricsRequest) (*common.GetMetricsResponse, error)
 	AddRoute(req *common.AddRouteRequest) (*common.AddRouteResponse, error)
-}
+	IP() string
+	// Port is the vmserver's port, with IP where the client dials
+	Port() int
+	Reconnect() error
+}
+
+// DefaultAgentPort is where vmserver listens unless a provider's config sets AgentPort
+const DefaultAgentPort = 2375
+
+const (
+	// extra time given to vmserver to report an ExecSync timeout before we give up on it
+	execSyncGrace = 5 * time.Second
+	// how long Reconnect waits for the vmserver to accept the new connection
+	reconnectTimeout = 10 * time.Second
+	// how long CreateRealClient waits between attempts to reach a new VM's vmserver
+	connectRetryInterval = 5 * time.Second
+	// how often WaitReady asks a new vmserver whether it is ready
+	agentReadyInterval = 2 * time.Second
+	// how long Ready and each of WaitReady's attempts give the vmserver to answer
+	agentPingTimeout = 5 * time.Second
+)
 
 type RealClient struct {
+	ip   string
+	port int
+
+	// protects the connection, which Reconnect can swap out from under in flight calls
+	lock       sync.RWMutex
 	kubeclient kubeapi.RuntimeServiceClient
 	vmclient   common.VMServerClient
 	conn       *grpc.ClientConn
+
+	logsLock sync.Mutex
+	logs     map[string]*containerLog
+}
+
+func (c *RealClient) kube() kubeapi.RuntimeServiceClient {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.kubeclient
+}
+
+func (c *RealClient) vm() common.VMServerClient {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.vmclient
+}
+
+// callCtx is the context of a call to the vmserver, so a hung VM fails the call with codes.DeadlineExceeded, which the
+// kubelet retries, instead of blocking it forever
+func callCtx(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), timeout)
 }
 
 func (c *RealClient) CreateContainer(req *kubeapi.CreateContainerRequest) (*kubeapi.CreateContainerResponse, error) {
-	resp, err := c.kubeclient.CreateContainer(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCreateTimeout)
+	defer cancel()
+
+	resp, err := c.kube().CreateContainer(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) StartContainer(req *kubeapi.StartContainerRequest) (*kubeapi.StartContainerResponse, error) {
-	resp, err := c.kubeclient.StartContainer(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCreateTimeout)
+	defer cancel()
+
+	resp, err := c.kube().StartContainer(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) StopContainer(req *kubeapi.StopContainerRequest) (*kubeapi.StopContainerResponse, error) {
-	resp, err := c.kubeclient.StopContainer(context.Background(), req)
+	// the container gets its timeout to exit before it is killed
+	ctx, cancel := callCtx(time.Duration(req.GetTimeout())*time.Second + *flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.kube().StopContainer(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) RemoveContainer(req *kubeapi.RemoveContainerRequest) (*kubeapi.RemoveContainerResponse, error) {
-	resp, err := c.kubeclient.RemoveContainer(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCreateTimeout)
+	defer cancel()
+
+	resp, err := c.kube().RemoveContainer(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) ListContainers(req *kubeapi.ListContainersRequest) (*kubeapi.ListContainersResponse, error) {
-	resp, err := c.kubeclient.ListContainers(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.kube().ListContainers(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) ContainerStatus(req *kubeapi.ContainerStatusRequest) (*kubeapi.ContainerStatusResponse, error) {
-	resp, err := c.kubeclient.ContainerStatus(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.kube().ContainerStatus(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) ExecSync(req *kubeapi.ExecSyncRequest) (*kubeapi.ExecSyncResponse, error) {
-	resp, err := c.kubeclient.ExecSync(context.Background(), req)
+	ctx := context.Background()
+
+	// vmserver enforces the timeout itself, the deadline here is so a wedged VM can't hang the caller (i.e. a probe)
+	if req.GetTimeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.GetTimeout())*time.Second+execSyncGrace)
+		defer cancel()
+	}
+
+	resp, err := c.kube().ExecSync(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) Exec(req *kubeapi.ExecRequest) (*kubeapi.ExecResponse, error) {
-	resp, err := c.kubeclient.Exec(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.kube().Exec(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) Attach(req *kubeapi.AttachRequest) (*kubeapi.AttachResponse, error) {
-	resp, err := c.kubeclient.Attach(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.kube().Attach(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) PortForward(req *kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error) {
-	resp, err := c.kubeclient.PortForward(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.kube().PortForward(ctx, req)
+
+	return resp, err
+}
+
+func (c *RealClient) ContainerStats(req *kubeapi.ContainerStatsRequest) (*kubeapi.ContainerStatsResponse, error) {
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.kube().ContainerStats(ctx, req)
+
+	return resp, err
+}
+
+func (c *RealClient) ListContainerStats(req *kubeapi.ListContainerStatsRequest) (*kubeapi.ListContainerStatsResponse, error) {
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.kube().ListContainerStats(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) Version() (*kubeapi.VersionResponse, error) {
-	return c.kubeclient.Version(context.Background(), &kubeapi.VersionRequest{})
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	return c.kube().Version(ctx, &kubeapi.VersionRequest{})
 }
 
 func (c *RealClient) Ready() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), agentPingTimeout)
 	defer cancel()
 
-	_, err := c.kubeclient.Version(ctx, &kubeapi.VersionRequest{})
+	_, err := c.kube().Version(ctx, &kubeapi.VersionRequest{})
 	return err
 }
 
+func (c *RealClient) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), agentPingTimeout)
+		_, err := c.kube().ListContainers(ctx, &kubeapi.ListContainersRequest{})
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("vmserver at %v wasn't ready after %v: %v", c.ip, timeout, err)
+		}
+
+		glog.V(2).Infof("WaitReady: vmserver at %v not ready yet: %v", c.ip, err)
+		time.Sleep(agentReadyInterval)
+	}
+}
+
 func (c *RealClient) StartProxy() error {
 	data, err := ioutil.ReadFile(*flags.Kubeconfig)
 
@@ -142,25 +284,37 @@ func (c *RealClient) StartProxy() error {
 		Kubeconfig:  data,
 	}
 
-	_, err = c.vmclient.StartProxy(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCreateTimeout)
+	defer cancel()
+
+	_, err = c.vm().StartProxy(ctx, req)
 
 	return err
 }
 
 func (c *RealClient) RunCmd(req *common.RunCmdRequest) error {
-	_, err := c.vmclient.RunCmd(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCreateTimeout)
+	defer cancel()
+
+	_, err := c.vm().RunCmd(ctx, req)
 
 	return err
 }
 
 func (c *RealClient) SetPodIP(ip string) error {
-	_, err := c.vmclient.SetPodIP(context.Background(), &common.SetIPRequest{Ip: ip})
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	_, err := c.vm().SetPodIP(ctx, &common.SetIPRequest{Ip: ip})
 
 	return err
 }
 
 func (c *RealClient) GetPodIP() (string, error) {
-	resp, err := c.vmclient.GetPodIP(context.Background(), &common.GetIPRequest{})
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.vm().GetPodIP(ctx, &common.GetIPRequest{})
 	if err != nil {
 		return "", err
 	}
@@ -174,13 +328,19 @@ func (c *RealClient) SetSandboxConfig(config *kubeapi.PodSandboxConfig) error {
 		return err
 	}
 
-	_, err = c.vmclient.SetSandboxConfig(context.Background(), &common.SetSandboxConfigRequest{Config: bytes})
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	_, err = c.vm().SetSandboxConfig(ctx, &common.SetSandboxConfigRequest{Config: bytes})
 
 	return err
 }
 
 func (c *RealClient) GetSandboxConfig() (*kubeapi.PodSandboxConfig, error) {
-	resp, err := c.vmclient.GetSandboxConfig(context.Background(), &common.GetSandboxConfigRequest{})
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.vm().GetSandboxConfig(ctx, &common.GetSandboxConfigRequest{})
 	if err != nil {
 		return nil, err
 	}
@@ -229,7 +389,10 @@ func (c *RealClient) internalCopyFile(file string) error {
 		FileData: fileData,
 	}
 
-	_, err = c.vmclient.CopyFile(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCreateTimeout)
+	defer cancel()
+
+	_, err = c.vm().CopyFile(ctx, req)
 
 	return err
 }
@@ -242,7 +405,10 @@ func (c *RealClient) MountFs(source string, target string, fstype string, readOn
 		ReadOnly: readOnly,
 	}
 
-	_, err := c.vmclient.MountFs(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCreateTimeout)
+	defer cancel()
+
+	_, err := c.vm().MountFs(ctx, req)
 
 	return err
 }
@@ -252,7 +418,10 @@ func (c *RealClient) UnmountFs(target string) error {
 		Target: target,
 	}
 
-	_, err := c.vmclient.UnmountFs(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCreateTimeout)
+	defer cancel()
+
+	_, err := c.vm().UnmountFs(ctx, req)
 
 	return err
 }
@@ -262,20 +431,39 @@ func (c *RealClient) SetHostname(hostname string) error {
 		Hostname: hostname,
 	}
 
-	_, err := c.vmclient.SetHostname(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	_, err := c.vm().SetHostname(ctx, req)
 
 	return err
 }
 
 func (c *RealClient) SaveLogs(container string, path string) error {
-	f, err := os.Create(path)
+	f, err := createContainerLog(path)
 	if err != nil {
 		msg := fmt.Sprintf("SaveLogs: failed to create path %v: %v", path, err)
 		glog.Warningf(msg)
 		return errors.New(msg)
 	}
+	defer f.Close()
 
-	stream, err := c.vmclient.Logs(context.Background(), &common.LogsRequest{ContainerID: container})
+	c.logsLock.Lock()
+	if c.logs == nil {
+		c.logs = make(map[string]*containerLog)
+	}
+	c.logs[container] = f
+	c.logsLock.Unlock()
+
+	defer func() {
+		c.logsLock.Lock()
+		if c.logs[container] == f {
+			delete(c.logs, container)
+		}
+		c.logsLock.Unlock()
+	}()
+
+	stream, err := c.vm().Logs(context.Background(), &common.LogsRequest{ContainerID: container})
 	if err != nil {
 		return fmt.Errorf("SaveLogs: failed: %v", err)
 	}
@@ -291,40 +479,115 @@ func (c *RealClient) SaveLogs(container string, path string) error {
 			return fmt.Errorf(msg)
 		}
 
-		f.WriteString(line.LogLine)
-		f.WriteString("\n")
+		if err := f.WriteLine(line.LogLine); err != nil {
+			glog.Warningf("SaveLogs: couldn't write to %v: %v", path, err)
+		}
 	}
 
 	return nil
 }
 
+func (c *RealClient) ReopenContainerLog(container string) error {
+	c.logsLock.Lock()
+	f, ok := c.logs[container]
+	c.logsLock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("ReopenContainerLog: %v isn't being logged", container)
+	}
+
+	return f.Reopen()
+}
+
 func (c *RealClient) GetMetric(req *common.GetMetricsRequest) (*common.GetMetricsResponse, error) {
-	resp, err := c.vmclient.GetMetrics(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.vm().GetMetrics(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) AddRoute(req *common.AddRouteRequest) (*common.AddRouteResponse, error) {
-	resp, err := c.vmclient.AddRoute(context.Background(), req)
+	ctx, cancel := callCtx(*flags.VMCallTimeout)
+	defer cancel()
+
+	resp, err := c.vm().AddRoute(ctx, req)
 
 	return resp, err
 }
 
 func (c *RealClient) Close() {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
 	c.conn.Close()
 }
 
-func CreateRealClient(ip string) (Client, error) {
-	glog.Infof("CreateClient: ip = %v", ip)
+func (c *RealClient) IP() string {
+	return c.ip
+}
+
+func (c *RealClient) Port() int {
+	return c.port
+}
+
+// Reconnect redials the vmserver at the same ip and port.  The new connection is only swapped in once it is established, calls
+// still in flight on the old one will just fail.
+func (c *RealClient) Reconnect() error {
+	glog.Infof("Reconnect: redialing %v", c.ip)
+
+	conn, err := dialVMServer(c.ip, c.port, grpc.WithBlock(), grpc.WithTimeout(reconnectTimeout))
+	if err != nil {
+		return fmt.Errorf("Reconnect: couldn't redial %v: %v", c.ip, err)
+	}
+
+	c.lock.Lock()
+	old := c.conn
+	c.conn = conn
+	c.kubeclient = kubeapi.NewRuntimeServiceClient(conn)
+	c.vmclient = common.NewVMServerClient(conn)
+	c.lock.Unlock()
+
+	old.Close()
+
+	return nil
+}
+
+// WaitForAgent connects to the vmserver of a VM that was just booted and waits for up to timeout for it to be able to
+// run containers, as the VM being up doesn't mean its vmserver is yet.  The client is closed again on failure.
+func WaitForAgent(ip string, port int, timeout time.Duration) (Client, error) {
+	client, err := CreateRealClient(ip, port)
+	if err != nil {
+		return nil, fmt.Errorf("error in createClient(): %v", err)
+	}
+
+	if err := client.WaitReady(timeout); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// CreateRealClient waits up to --vm-connect-window for the vmserver at ip and port to answer, each dial and version check
+// giving up after --vm-connect-timeout.  A freshly booted VM may take a while to start vmserver, but a bad ip shouldn't
+// hang the caller forever.
+func CreateRealClient(ip string, port int) (Client, error) {
+	glog.Infof("CreateClient: ip = %v, port = %v", ip, port)
 	var (
 		err    error
 		client *RealClient
 	)
 
-	for i := 0; i < 10; i++ {
-		client, err = internalCreateClient(ip)
+	deadline := time.Now().Add(*flags.VMConnectWindow)
+
+	for {
+		client, err = internalCreateClient(ip, port, grpc.WithBlock(), grpc.WithTimeout(*flags.VMConnectTimeout))
 		if err == nil {
-			version, err1 := client.Version()
+			ctx, cancel := context.WithTimeout(context.Background(), *flags.VMConnectTimeout)
+			version, err1 := client.kube().Version(ctx, &kubeapi.VersionRequest{})
+			cancel()
 			if err1 == nil {
 				glog.Infof("CreateClient: version = %+v", version)
 
@@ -347,13 +610,29 @@ func CreateRealClient(ip string) (Client, error) {
 		} else {
 			glog.Infof("CreateClient: internalCreateClient failed: %v", err)
 		}
-		time.Sleep(5 * time.Second)
+
+		if time.Now().Add(connectRetryInterval).After(deadline) {
+			break
+		}
+		time.Sleep(connectRetryInterval)
 	}
 
-	return nil, err
+	return nil, fmt.Errorf("CreateClient: vmserver at %v:%v wasn't ready within %v: %v", ip, port, *flags.VMConnectWindow, err)
 }
 
-func internalCreateClient(ip string) (*RealClient, error) {
+func internalCreateClient(ip string, port int, extra ...grpc.DialOption) (*RealClient, error) {
+	conn, err := dialVMServer(ip, port, extra...)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeclient := kubeapi.NewRuntimeServiceClient(conn)
+	vmclient := common.NewVMServerClient(conn)
+
+	return &RealClient{ip: ip, port: port, kubeclient: kubeclient, vmclient: vmclient, conn: conn}, nil
+}
+
+func dialVMServer(ip string, port int, extra ...grpc.DialOption) (*grpc.ClientConn, error) {
 	var opts []grpc.DialOption
 	var creds credentials.TransportCredentials
 	var sn = "127.0.0.1"
@@ -363,17 +642,9 @@ func internalCreateClient(ip string) (*RealClient, error) {
 		return nil, err
 	}
 	opts = append(opts, grpc.WithTransportCredentials(creds))
+	opts = append(opts, extra...)
 
-	conn, err := grpc.Dial(ip+":2375", opts...)
-
-	if err != nil {
-		return nil, err
-	}
-
-	kubeclient := kubeapi.NewRuntimeServiceClient(conn)
-	vmclient := common.NewVMServerClient(conn)
-
-	return &RealClient{kubeclient: kubeclient, vmclient: vmclient, conn: conn}, nil
+	return grpc.Dial(net.JoinHostPort(ip, strconv.Itoa(port)), opts...)
 }
 
 // NewClientTLSFromFile constructs a TLS from the input certificate file for client.
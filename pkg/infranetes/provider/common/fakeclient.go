@@ -2,6 +2,7 @@ package common
 
 import (
 	"errors"
+	"time"
 
 	"github.com/apporbit/infranetes/pkg/common"
 	"github.com/apporbit/infranetes/pkg/vmserver"
@@ -63,6 +64,14 @@ func (c *fakeClient) PortForward(req *kubeapi.PortForwardRequest) (*kubeapi.Port
 	return nil, errors.New("fake doesn't support streaming attach")
 }
 
+func (c *fakeClient) ContainerStats(req *kubeapi.ContainerStatsRequest) (*kubeapi.ContainerStatsResponse, error) {
+	return nil, errors.New("fake doesn't support container stats")
+}
+
+func (c *fakeClient) ListContainerStats(req *kubeapi.ListContainerStatsRequest) (*kubeapi.ListContainerStatsResponse, error) {
+	return &kubeapi.ListContainerStatsResponse{}, nil
+}
+
 func (c *fakeClient) Version() (*kubeapi.VersionResponse, error) {
 	return &kubeapi.VersionResponse{}, nil
 }
@@ -71,6 +80,10 @@ func (c *fakeClient) Ready() error {
 	return nil
 }
 
+func (c *fakeClient) WaitReady(timeout time.Duration) error {
+	return nil
+}
+
 func (c *fakeClient) StartProxy() error {
 	return errors.New("Fake doesn't support StartProxy")
 }
@@ -118,6 +131,10 @@ func (c *fakeClient) SaveLogs(container string, path string) error {
 	return nil
 }
 
+func (c *fakeClient) ReopenContainerLog(container string) error {
+	return nil
+}
+
 func (c *fakeClient) GetMetric(req *common.GetMetricsRequest) (*common.GetMetricsResponse, error) {
 	return &common.GetMetricsResponse{}, nil
 }
@@ -125,3 +142,15 @@ func (c *fakeClient) GetMetric(req *common.GetMetricsRequest) (*common.GetMetric
 func (c *fakeClient) AddRoute(req *common.AddRouteRequest) (*common.AddRouteResponse, error) {
 	return &common.AddRouteResponse{}, nil
 }
+
+func (c *fakeClient) IP() string {
+	return ""
+}
+
+func (c *fakeClient) Port() int {
+	return DefaultAgentPort
+}
+
+func (c *fakeClient) Reconnect() error {
+	return nil
+}
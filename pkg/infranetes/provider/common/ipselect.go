@@ -0,0 +1,90 @@
+package common
+
+import (
+	"fmt"
+	"net"
+)
+
+// IPSelector picks which of a VM's addresses infranetes dials the vmserver on.  It is meant to be embedded in a
+// provider's json config, e.g. "IPSelection": {"Prefer": "public"} or "IPSelection": {"CIDR": "10.1.0.0/16"}
+type IPSelector struct {
+	// Prefer is "private", "public" or empty for the first address the VM reports.  If no address of the preferred
+	// kind exists, the first address is used instead.
+	Prefer string
+	// CIDR, if set, overrides Prefer and has to contain the chosen address
+	CIDR string
+}
+
+var privateNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		privateNets = append(privateNets, ipnet)
+	}
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, ipnet := range privateNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate should be called when reading in the config so a typo fails at startup instead of at every boot
+func (s *IPSelector) Validate() error {
+	switch s.Prefer {
+	case "", "private", "public":
+	default:
+		return fmt.Errorf("IPSelection: unknown Prefer value %q, must be private or public", s.Prefer)
+	}
+
+	if s.CIDR != "" {
+		if _, _, err := net.ParseCIDR(s.CIDR); err != nil {
+			return fmt.Errorf("IPSelection: bad CIDR: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Select returns the address to dial out of ips.  nil entries (i.e. a missing public ip) are skipped.
+func (s *IPSelector) Select(ips []net.IP) (net.IP, error) {
+	usable := []net.IP{}
+	for _, ip := range ips {
+		if ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+			usable = append(usable, ip)
+		}
+	}
+
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("no usable ip in %v", ips)
+	}
+
+	if s.CIDR != "" {
+		_, ipnet, err := net.ParseCIDR(s.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("bad CIDR %v: %v", s.CIDR, err)
+		}
+		for _, ip := range usable {
+			if ipnet.Contains(ip) {
+				return ip, nil
+			}
+		}
+		return nil, fmt.Errorf("no ip in %v is within %v", ips, s.CIDR)
+	}
+
+	for _, ip := range usable {
+		switch {
+		case s.Prefer == "private" && isPrivate(ip):
+			return ip, nil
+		case s.Prefer == "public" && !isPrivate(ip):
+			return ip, nil
+		}
+	}
+
+	return usable[0], nil
+}
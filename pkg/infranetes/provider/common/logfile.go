@@ -0,0 +1,94 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// how often a container log checks whether its file was rotated out from under it
+	logRotateCheckInterval = 10 * time.Second
+)
+
+// containerLog is the host side file a container's log is streamed into.  If the file is renamed or removed (i.e. by
+// logrotate), the next write after logRotateCheckInterval reopens path, so the rotated file stops growing.
+type containerLog struct {
+	lock    sync.Mutex
+	path    string
+	f       *os.File
+	checked time.Time
+}
+
+func createContainerLog(path string) (*containerLog, error) {
+	// newer kubelets put each container's logs in a directory of its own
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &containerLog{path: path, f: f, checked: time.Now()}, nil
+}
+
+func (l *containerLog) WriteLine(line string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if time.Since(l.checked) > logRotateCheckInterval {
+		l.checked = time.Now()
+		if l.rotated() {
+			if err := l.reopen(); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := l.f.WriteString(line + "\n")
+	return err
+}
+
+// Reopen starts a new file at path regardless of whether the current one was rotated
+func (l *containerLog) Reopen() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.reopen()
+}
+
+func (l *containerLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.f.Close()
+}
+
+func (l *containerLog) rotated() bool {
+	onDisk, err := os.Stat(l.path)
+	if err != nil {
+		return true
+	}
+
+	current, err := l.f.Stat()
+	if err != nil {
+		return true
+	}
+
+	return !os.SameFile(onDisk, current)
+}
+
+func (l *containerLog) reopen() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.f.Close()
+	l.f = f
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// VMName is what a pod sandbox's VM is called in the cloud's console, "<namespace>-<name>-<uid>" plus "-<attempt>" for
+// retries, so pods that share a name (i.e. across replica sets or namespaces) or attempts of the same pod never collide.
+// Only lower case letters, digits and dashes are kept, which every cloud accepts.  The namespace and name are cut short
+// to fit maxLen, never the uid.
+func VMName(meta *kubeapi.PodSandboxMetadata, maxLen int) string {
+	suffix := "-" + sanitizeName(meta.GetUid())
+	if meta.GetAttempt() > 0 {
+		suffix += fmt.Sprintf("-%d", meta.GetAttempt())
+	}
+
+	prefix := sanitizeName(meta.GetNamespace() + "-" + meta.GetName())
+	if len(prefix)+len(suffix) > maxLen {
+		if maxLen > len(suffix) {
+			prefix = prefix[:maxLen-len(suffix)]
+		} else {
+			prefix = ""
+		}
+	}
+
+	if prefix = strings.Trim(prefix, "-"); prefix == "" {
+		return strings.TrimPrefix(suffix, "-")
+	}
+
+	return prefix + suffix
+}
+
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, s)
+}
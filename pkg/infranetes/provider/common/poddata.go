@@ -7,9 +7,10 @@ import (
 	"sync"
 	"time"
 
-	lvm "github.com/apcera/libretto/virtualmachine"
 	"github.com/golang/glog"
 
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
@@ -18,8 +19,25 @@ type ProviderData interface {
 	NeedMount(volume string) bool
 }
 
+const (
+	// the pod shares the network namespace of the VM, i.e. it has the VM's ip
+	NetworkModeHost = "host"
+	// the pod has a network namespace of its own in the VM
+	NetworkModePod = "pod"
+)
+
+// ValidateNetworkMode checks a provider config's NetworkMode, "" being the default of reporting what the pod asked for
+func ValidateNetworkMode(mode string) error {
+	switch mode {
+	case "", NetworkModeHost, NetworkModePod:
+		return nil
+	}
+
+	return fmt.Errorf("NetworkMode must be %v or %v, not %q", NetworkModeHost, NetworkModePod, mode)
+}
+
 type PodData struct {
-	VM           lvm.VirtualMachine
+	VM           VM
 	Id           string
 	Metadata     *kubeapi.PodSandboxMetadata
 	Annotations  map[string]string
@@ -34,9 +52,21 @@ type PodData struct {
 	BootLock     sync.Mutex
 	ProviderData ProviderData
 	ContLogs     map[string]string
+
+	// ReadyCacheInterval overrides --ready-cache-interval for this pod if set
+	ReadyCacheInterval time.Duration
+
+	// NetworkMode is the network namespace PodStatus reports, see NetworkModeHost and NetworkModePod.  Unset reports
+	// whatever the pod asked for.
+	NetworkMode string
+
+	// cached result of the last Client.Ready() check, see clientReady()
+	readyLock    sync.Mutex
+	readyChecked time.Time
+	readyErr     error
 }
 
-func NewPodData(vm lvm.VirtualMachine, id string, meta *kubeapi.PodSandboxMetadata, anno map[string]string,
+func NewPodData(vm VM, id string, meta *kubeapi.PodSandboxMetadata, anno map[string]string,
 	labels map[string]string, ip string, linux *kubeapi.LinuxPodSandboxConfig, client Client, booted bool,
 	providerData ProviderData) *PodData {
 	return &PodData{
@@ -133,6 +163,26 @@ func (p *PodData) StopPod() error {
 	return nil
 }
 
+// Resume makes a stopped pod whose VM was started again the sandbox for config, reached through client.  Expects the
+// pod's lock to be held.
+func (p *PodData) Resume(config *kubeapi.PodSandboxConfig, client Client, providerData ProviderData) {
+	if p.Client != nil {
+		p.Client.Close()
+	}
+
+	p.Client = client
+	p.ProviderData = providerData
+	p.Metadata = config.Metadata
+	p.Annotations = config.Annotations
+	p.Labels = config.Labels
+	p.Linux = config.Linux
+	p.CreatedAt = time.Now().Unix()
+
+	// not ready is otherwise sticky, and the cached Ready() result is from before the VM was stopped
+	p.PodState = kubeapi.PodSandboxState_SANDBOX_READY
+	p.RefreshPodState()
+}
+
 func (p *PodData) RemovePod() error {
 	p.Client.Close()
 	p.Client = nil
@@ -145,9 +195,19 @@ func (p *PodData) PodStatus() *kubeapi.PodSandboxStatus {
 		Ip: p.Ip,
 	}
 
+	// the config may not have a LinuxPodSandboxConfig (or namespace options in it), that gets the defaults rather than
+	// taking the manager down
+	options := kubeapi.NamespaceOption{}
+	if requested := p.Linux.GetSecurityContext().GetNamespaceOptions(); requested != nil {
+		options = *requested
+	}
+	if p.NetworkMode != "" {
+		options.HostNetwork = p.NetworkMode == NetworkModeHost
+	}
+
 	linux := &kubeapi.LinuxPodSandboxStatus{
 		Namespaces: &kubeapi.Namespace{
-			Options: p.Linux.SecurityContext.NamespaceOptions,
+			Options: &options,
 		},
 	}
 
@@ -216,15 +276,46 @@ func (p *PodData) GetPodState() kubeapi.PodSandboxState {
 		return kubeapi.PodSandboxState_SANDBOX_READY
 	}
 
-	err := p.Client.Ready()
+	err := p.clientReady()
 	if err != nil {
-		glog.Infof("GetPodState: pod %v not Ready: %v", err)
+		glog.Infof("GetPodState: pod %v not Ready: %v", p.Id, err)
 		return kubeapi.PodSandboxState_SANDBOX_NOTREADY
 	}
 
 	return kubeapi.PodSandboxState_SANDBOX_READY
 }
 
+// clientReady is Client.Ready() cached for ReadyCacheInterval, so listing sandboxes doesn't hit every VM every time.
+// It has its own lock as callers only hold the pod's read lock.
+func (p *PodData) clientReady() error {
+	p.readyLock.Lock()
+	defer p.readyLock.Unlock()
+
+	interval := *flags.ReadyCache
+	if p.ReadyCacheInterval > 0 {
+		interval = p.ReadyCacheInterval
+	}
+
+	if !p.readyChecked.IsZero() && time.Since(p.readyChecked) < interval {
+		return p.readyErr
+	}
+
+	p.readyErr = p.Client.Ready()
+	p.readyChecked = time.Now()
+
+	return p.readyErr
+}
+
+// RefreshPodState is UpdatePodState without the cached Ready() result
+func (p *PodData) RefreshPodState() {
+	p.readyLock.Lock()
+	p.readyChecked = time.Time{}
+	p.readyLock.Unlock()
+
+	p.UpdatePodState()
+}
+
+/* Expect StateLock to already be taken */
 func (p *PodData) UpdatePodState() {
 	p.PodState = p.GetPodState()
 }
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// how long the pool waits before trying again after failing to provision a VM
+	poolRetryInterval = 30 * time.Second
+)
+
+// VMPool keeps a number of idle, already provisioned VMs around so RunPodSandbox doesn't have to wait on the cloud.
+// Claimed VMs are replaced in the background.
+type VMPool struct {
+	size   int
+	create func() (VM, error)
+
+	lock     sync.Mutex
+	idle     []VM
+	shutdown bool
+
+	refill chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewVMPool returns a pool that keeps size VMs made by create ready.  Nothing is provisioned until Start is called.
+func NewVMPool(size int, create func() (VM, error)) *VMPool {
+	return &VMPool{
+		size:   size,
+		create: create,
+		refill: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (p *VMPool) Start() {
+	p.wg.Add(1)
+	go p.fill()
+
+	p.kick()
+}
+
+func (p *VMPool) kick() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// fill provisions VMs one at a time until the pool is full, then waits to be kicked by Claim
+func (p *VMPool) fill() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.refill:
+		}
+
+		for {
+			p.lock.Lock()
+			full := len(p.idle) >= p.size || p.shutdown
+			p.lock.Unlock()
+			if full {
+				break
+			}
+
+			vm, err := p.create()
+			if err != nil {
+				glog.Warningf("VMPool: failed to provision a VM: %v", err)
+				select {
+				case <-p.done:
+					return
+				case <-time.After(poolRetryInterval):
+				}
+				continue
+			}
+
+			p.lock.Lock()
+			if p.shutdown { // raced with Shutdown, nobody is going to claim this one
+				p.lock.Unlock()
+				destroyPoolVM(vm)
+				return
+			}
+			p.idle = append(p.idle, vm)
+			glog.Infof("VMPool: %v ready, %d/%d idle", vm.GetName(), len(p.idle), p.size)
+			p.lock.Unlock()
+		}
+	}
+}
+
+// Claim hands out an idle VM if there is one and starts provisioning its replacement
+func (p *VMPool) Claim() (VM, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.shutdown || len(p.idle) == 0 {
+		return nil, false
+	}
+
+	vm := p.idle[0]
+	p.idle = p.idle[1:]
+
+	p.kick()
+
+	return vm, true
+}
+
+// Shutdown stops refilling the pool and destroys every VM that was never claimed
+func (p *VMPool) Shutdown() {
+	p.lock.Lock()
+	if p.shutdown {
+		p.lock.Unlock()
+		return
+	}
+	p.shutdown = true
+	idle := p.idle
+	p.idle = nil
+	p.lock.Unlock()
+
+	close(p.done)
+
+	for _, vm := range idle {
+		destroyPoolVM(vm)
+	}
+
+	p.wg.Wait()
+}
+
+func destroyPoolVM(vm VM) {
+	glog.Infof("VMPool: destroying idle VM %v", vm.GetName())
+	if err := vm.Destroy(); err != nil {
+		glog.Warningf("VMPool: couldn't destroy %v: %v", vm.GetName(), err)
+	}
+}
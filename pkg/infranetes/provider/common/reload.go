@@ -0,0 +1,24 @@
+package common
+
+import (
+	"reflect"
+
+	"github.com/golang/glog"
+)
+
+// KeepFixedFields copies the named fields of cur into next, pointers to the same config struct, for the settings a
+// reload can't change under running pods (i.e. the region).  The ones next tried to change are logged as ignored.
+func KeepFixedFields(file string, cur, next interface{}, fields ...string) {
+	c := reflect.ValueOf(cur).Elem()
+	n := reflect.ValueOf(next).Elem()
+
+	for _, name := range fields {
+		cf := c.FieldByName(name)
+		nf := n.FieldByName(name)
+
+		if !reflect.DeepEqual(cf.Interface(), nf.Interface()) {
+			glog.Warningf("%v: ignoring new %v of %v, it can't change without a restart", file, name, nf.Interface())
+			nf.Set(cf)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// the pod's resource requests, as kubernetes quantities (i.e. "500m" or "2" cpus, "512Mi" or "4Gi" memory), for
+	// providers to size its VM with
+	CPUAnnotation    = "infranetes.cpu"
+	MemoryAnnotation = "infranetes.memory"
+)
+
+// Resources is what a pod asked for with the sizing annotations, 0 for what it didn't ask for
+type Resources struct {
+	MilliCPUs int64
+	MemoryMB  int64
+}
+
+func (r Resources) IsZero() bool {
+	return r.MilliCPUs == 0 && r.MemoryMB == 0
+}
+
+// Size is a VM size (i.e. an instance or machine type) and what it provides
+type Size struct {
+	Name     string
+	CPUs     float64
+	MemoryMB int64
+}
+
+func ParseResources(annotations map[string]string) (Resources, error) {
+	var ret Resources
+
+	if val, ok := annotations[CPUAnnotation]; ok {
+		q, err := resource.ParseQuantity(val)
+		if err != nil || q.Sign() < 0 {
+			return Resources{}, fmt.Errorf("bad %v annotation %q", CPUAnnotation, val)
+		}
+		ret.MilliCPUs = q.MilliValue()
+	}
+
+	if val, ok := annotations[MemoryAnnotation]; ok {
+		q, err := resource.ParseQuantity(val)
+		if err != nil || q.Sign() < 0 {
+			return Resources{}, fmt.Errorf("bad %v annotation %q", MemoryAnnotation, val)
+		}
+		ret.MemoryMB = (q.Value() + MiB - 1) / MiB
+	}
+
+	return ret, nil
+}
+
+// PickSize returns the first of sizes, expected smallest first, that provides everything r asks for.  "" if r is zero,
+// i.e. the provider's default size should be used.
+func PickSize(sizes []Size, r Resources) (string, error) {
+	if r.IsZero() {
+		return "", nil
+	}
+
+	for _, size := range sizes {
+		if int64(size.CPUs*1000) >= r.MilliCPUs && size.MemoryMB >= r.MemoryMB {
+			return size.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no size provides %dm cpu and %dMB memory", r.MilliCPUs, r.MemoryMB)
+}
+
+// ResolveSize is ParseResources and PickSize together, for providers to call with the pod's annotations
+func ResolveSize(sizes []Size, annotations map[string]string) (string, error) {
+	r, err := ParseResources(annotations)
+	if err != nil {
+		return "", err
+	}
+
+	return PickSize(sizes, r)
+}
@@ -0,0 +1,221 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateStore is where the manager persists a record per sandbox, keyed by pod sandbox id, so it can pick its pods back
+// up after a restart, or another manager can after a fail over
+type StateStore interface {
+	Save(id string, data []byte) error
+	// Load returns every saved record
+	Load() (map[string][]byte, error)
+	Delete(id string) error
+}
+
+// fileStateStore keeps every record in a single json file, rewritten on every change
+type fileStateStore struct {
+	lock sync.Mutex
+	path string
+}
+
+func NewFileStateStore(path string) StateStore {
+	return &fileStateStore{path: path}
+}
+
+func (s *fileStateStore) read() (map[string]json.RawMessage, error) {
+	records := make(map[string]json.RawMessage)
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("couldn't parse %v: %v", s.path, err)
+	}
+
+	return records, nil
+}
+
+func (s *fileStateStore) write(records map[string]json.RawMessage) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename so a crash mid write can't leave us with a truncated state file
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileStateStore) Save(id string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	records[id] = json.RawMessage(data)
+
+	return s.write(records)
+}
+
+func (s *fileStateStore) Load() (map[string][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string][]byte)
+	for id, data := range records {
+		ret[id] = []byte(data)
+	}
+
+	return ret, nil
+}
+
+func (s *fileStateStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := records[id]; !ok {
+		return nil
+	}
+	delete(records, id)
+
+	return s.write(records)
+}
+
+// etcdStateStore keeps every record under prefix in etcd, through the v3 json gateway (etcd 3.4 and later) so we don't
+// need the etcd client
+type etcdStateStore struct {
+	endpoints  []string
+	prefix     string
+	httpClient *http.Client
+}
+
+func NewEtcdStateStore(endpoints []string, prefix string) (StateStore, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no etcd endpoints")
+	}
+
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &etcdStateStore{
+		endpoints:  endpoints,
+		prefix:     prefix,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// post tries each endpoint in turn, so a single etcd member being down doesn't fail the request.  []byte fields are
+// base64 encoded by encoding/json, as the gateway expects.
+func (s *etcdStateStore) post(path string, in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, endpoint := range s.endpoints {
+		resp, err := s.httpClient.Post(strings.TrimSuffix(endpoint, "/")+path, "application/json", bytes.NewReader(data))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("%v returned %v: %s", endpoint+path, resp.Status, body)
+			continue
+		}
+
+		if out != nil {
+			if err := json.Unmarshal(body, out); err != nil {
+				return fmt.Errorf("couldn't parse %v response: %v", path, err)
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("etcd %v failed: %v", path, lastErr)
+}
+
+func (s *etcdStateStore) Save(id string, data []byte) error {
+	return s.post("/v3/kv/put", &etcdKV{Key: []byte(s.prefix + id), Value: data}, nil)
+}
+
+func (s *etcdStateStore) Load() (map[string][]byte, error) {
+	// range_end is the prefix with its last byte incremented, i.e. every key starting with prefix
+	end := []byte(s.prefix)
+	end[len(end)-1]++
+
+	req := struct {
+		Key      []byte `json:"key"`
+		RangeEnd []byte `json:"range_end"`
+	}{[]byte(s.prefix), end}
+
+	var resp struct {
+		Kvs []etcdKV `json:"kvs"`
+	}
+	if err := s.post("/v3/kv/range", &req, &resp); err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string][]byte)
+	for _, kv := range resp.Kvs {
+		ret[strings.TrimPrefix(string(kv.Key), s.prefix)] = kv.Value
+	}
+
+	return ret, nil
+}
+
+func (s *etcdStateStore) Delete(id string) error {
+	req := struct {
+		Key []byte `json:"key"`
+	}{[]byte(s.prefix + id)}
+
+	return s.post("/v3/kv/deleterange", &req, nil)
+}
@@ -6,10 +6,13 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 
 	libcontainercgroups "github.com/opencontainers/runc/libcontainer/cgroups"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
 const (
@@ -123,3 +126,21 @@ func ParseCommonAnnotations(annotations map[string]string) *annotationConfig {
 
 	return ret
 }
+
+// ImagesFsInfo is ImageFsInfo for image providers whose images live in the cloud (i.e. AMIs) rather than on a local
+// filesystem.  It reports the images' total size, and counts each image as one inode.
+func ImagesFsInfo(storageId string, images map[string]*kubeapi.Image) *kubeapi.ImageFsInfoResponse {
+	var size uint64
+	for _, image := range images {
+		size += image.GetSize_()
+	}
+
+	usage := &kubeapi.FilesystemUsage{
+		Timestamp:  time.Now().UnixNano(),
+		StorageId:  &kubeapi.StorageIdentifier{Uuid: storageId},
+		UsedBytes:  &kubeapi.UInt64Value{Value: size},
+		InodesUsed: &kubeapi.UInt64Value{Value: uint64(len(images))},
+	}
+
+	return &kubeapi.ImageFsInfoResponse{ImageFilesystems: []*kubeapi.FilesystemUsage{usage}}
+}
@@ -0,0 +1,18 @@
+package common
+
+import (
+	"net"
+)
+
+// VM is the part of a VM's lifecycle the manager and the common code drive, so they don't depend on any particular
+// backend.  libretto's VMs (i.e. aws.VM, virtualbox.VM) are the real implementations, the fake provider's fakeVM the one
+// for tests.  Halt is libretto's name for stopping the VM.
+type VM interface {
+	GetName() string
+	Provision() error
+	GetIPs() ([]net.IP, error)
+	GetState() (string, error)
+	Destroy() error
+	Halt() error
+	Start() error
+}
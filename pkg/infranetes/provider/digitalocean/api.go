@@ -0,0 +1,268 @@
+/* Minimal client for the parts of the DigitalOcean v2 API we need, libretto doesn't support DigitalOcean */
+
+package digitalocean
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/apcera/libretto/ssh"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+const (
+	apiBase = "https://api.digitalocean.com/v2"
+
+	// tag put on every droplet we create so ListInstances can find them again
+	infranetesTag = "infranetes"
+
+	// how long Provision waits for a droplet to become active
+	provisionTimeout = 5 * time.Minute
+	pollInterval     = 5 * time.Second
+)
+
+type doClient struct {
+	token      string
+	httpClient *http.Client
+}
+
+func newDOClient(token string) *doClient {
+	return &doClient{
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type dropletNetwork struct {
+	IpAddress string `json:"ip_address"`
+	Type      string `json:"type"`
+}
+
+type droplet struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Networks struct {
+		V4 []dropletNetwork `json:"v4"`
+	} `json:"networks"`
+}
+
+type createDropletRequest struct {
+	Name    string   `json:"name"`
+	Region  string   `json:"region"`
+	Size    string   `json:"size"`
+	Image   string   `json:"image"`
+	SshKeys []string `json:"ssh_keys,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+func (c *doClient) do(method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, apiBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v %v failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%v %v: couldn't read response: %v", method, path, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v %v returned %v: %s", method, path, resp.Status, data)
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%v %v: couldn't parse response: %v", method, path, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *doClient) createDroplet(req *createDropletRequest) (*droplet, error) {
+	var resp struct {
+		Droplet droplet `json:"droplet"`
+	}
+	if err := c.do("POST", "/droplets", req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Droplet, nil
+}
+
+func (c *doClient) getDroplet(id int) (*droplet, error) {
+	var resp struct {
+		Droplet droplet `json:"droplet"`
+	}
+	if err := c.do("GET", "/droplets/"+strconv.Itoa(id), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Droplet, nil
+}
+
+func (c *doClient) deleteDroplet(id int) error {
+	return c.do("DELETE", "/droplets/"+strconv.Itoa(id), nil, nil)
+}
+
+func (c *doClient) dropletAction(id int, action string) error {
+	return c.do("POST", "/droplets/"+strconv.Itoa(id)+"/actions", map[string]string{"type": action}, nil)
+}
+
+func (c *doClient) listDroplets(tag string) ([]droplet, error) {
+	var resp struct {
+		Droplets []droplet `json:"droplets"`
+	}
+	if err := c.do("GET", "/droplets?per_page=200&tag_name="+tag, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Droplets, nil
+}
+
+func (c *doClient) getAccount() error {
+	return c.do("GET", "/account", nil, nil)
+}
+
+// dropletVM implements libretto's VirtualMachine interface on top of doClient so the rest of infranetes can treat it
+// like any other VM
+type dropletVM struct {
+	client *doClient
+
+	Id      int
+	Name    string
+	Region  string
+	Size    string
+	Image   string
+	SshKeys []string
+}
+
+func (vm *dropletVM) GetName() string {
+	return vm.Name
+}
+
+func (vm *dropletVM) Provision() error {
+	req := &createDropletRequest{
+		Name:    vm.Name,
+		Region:  vm.Region,
+		Size:    vm.Size,
+		Image:   vm.Image,
+		SshKeys: vm.SshKeys,
+		Tags:    []string{infranetesTag},
+	}
+
+	d, err := vm.client.createDroplet(req)
+	if err != nil {
+		return fmt.Errorf("Failed to create droplet: %v", err)
+	}
+	vm.Id = d.Id
+
+	for start := time.Now(); time.Since(start) < provisionTimeout; time.Sleep(pollInterval) {
+		d, err := vm.client.getDroplet(vm.Id)
+		if err != nil {
+			return err
+		}
+		if d.Status == "active" {
+			return nil
+		}
+	}
+
+	return lvm.ErrVMBootTimeout
+}
+
+// GetIPs returns the droplet's public ip first, followed by its private ip if private networking is enabled
+func (vm *dropletVM) GetIPs() ([]net.IP, error) {
+	d, err := vm.client.getDroplet(vm.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var public, private []net.IP
+	for _, n := range d.Networks.V4 {
+		ip := net.ParseIP(n.IpAddress)
+		if ip == nil {
+			continue
+		}
+		if n.Type == "public" {
+			public = append(public, ip)
+		} else {
+			private = append(private, ip)
+		}
+	}
+
+	ips := append(public, private...)
+	if len(ips) == 0 {
+		return nil, lvm.ErrVMNoIP
+	}
+
+	return ips, nil
+}
+
+func (vm *dropletVM) Destroy() error {
+	return vm.client.deleteDroplet(vm.Id)
+}
+
+func (vm *dropletVM) GetState() (string, error) {
+	d, err := vm.client.getDroplet(vm.Id)
+	if err != nil {
+		return lvm.VMUnknown, err
+	}
+
+	switch d.Status {
+	case "new":
+		return lvm.VMStarting, nil
+	case "active":
+		return lvm.VMRunning, nil
+	case "off":
+		return lvm.VMHalted, nil
+	case "archive":
+		return lvm.VMSuspended, nil
+	}
+
+	return lvm.VMUnknown, nil
+}
+
+func (vm *dropletVM) Suspend() error {
+	return lvm.ErrSuspendNotSupported
+}
+
+func (vm *dropletVM) Resume() error {
+	return lvm.ErrResumeNotSupported
+}
+
+func (vm *dropletVM) Halt() error {
+	return vm.client.dropletAction(vm.Id, "power_off")
+}
+
+func (vm *dropletVM) Start() error {
+	return vm.client.dropletAction(vm.Id, "power_on")
+}
+
+func (vm *dropletVM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	return nil, fmt.Errorf("GetSSH: not supported for droplets")
+}
@@ -0,0 +1,23 @@
+package digitalocean
+
+import (
+	icommon "github.com/apporbit/infranetes/pkg/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+)
+
+type doConfig struct {
+	Token          string
+	Region         string
+	Size           string
+	Image          string
+	SshFingerprint string
+
+	Routes []icommon.AddRouteRequest
+
+	// IPSelection picks the droplet address we dial, and that the pod is given.  Defaults to the public ip.
+	IPSelection common.IPSelector
+
+	// AgentPort is where vmserver listens in the droplet, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
+}
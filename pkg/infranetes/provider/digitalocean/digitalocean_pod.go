@@ -0,0 +1,210 @@
+package digitalocean
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/types"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+type podData struct{}
+
+type doPodProvider struct {
+	config *doConfig
+	client *doClient
+}
+
+func init() {
+	provider.PodProviders.RegisterProvider("digitalocean", NewDOPodProvider)
+}
+
+func NewDOPodProvider() (provider.PodProvider, error) {
+	var conf doConfig
+
+	file, err := ioutil.ReadFile("digitalocean.json")
+	if err != nil {
+		return nil, fmt.Errorf("File error: %v\n", err)
+	}
+
+	json.Unmarshal(file, &conf)
+
+	if conf.Token == "" || conf.Region == "" || conf.Size == "" || conf.Image == "" || conf.SshFingerprint == "" {
+		msg := fmt.Sprintf("Failed to read in complete config file: conf = %+v", conf)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+
+	if conf.AgentPort == 0 {
+		conf.AgentPort = common.DefaultAgentPort
+	}
+	if conf.IPSelection.Prefer == "" && conf.IPSelection.CIDR == "" {
+		conf.IPSelection.Prefer = "public"
+	}
+	if err := conf.IPSelection.Validate(); err != nil {
+		return nil, err
+	}
+
+	client := newDOClient(conf.Token)
+
+	glog.Infof("Validating DigitalOcean Credentials")
+	if err := client.getAccount(); err != nil {
+		msg := fmt.Sprintf("Failed to validate DigitalOcean Credentials: %v", err)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+	glog.Infof("Validated Credentials")
+
+	return &doPodProvider{
+		config: &conf,
+		client: client,
+	}, nil
+}
+
+func (*doPodProvider) UpdatePodState(data *common.PodData) {
+	if data.Booted {
+		data.UpdatePodState()
+	}
+}
+
+// bootConfig is what common.BootSandbox and common.ConnectSandbox need of our config
+func (v *doPodProvider) bootConfig() *common.BootConfig {
+	return &common.BootConfig{
+		IPSelection: v.config.IPSelection,
+		AgentPort:   v.config.AgentPort,
+		Routes:      v.config.Routes,
+	}
+}
+
+func (p *doPodProvider) bootSandbox(vm *dropletVM, config *kubeapi.PodSandboxConfig, name string) (*common.PodData, error) {
+	client, podIp, err := common.BootSandbox(vm, func() error {
+		if err := vm.Provision(); err != nil {
+			if vm.Id != 0 {
+				vm.Destroy()
+			}
+			return err
+		}
+		return nil
+	}, config, p.bootConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	providerData := &podData{}
+
+	booted := true
+
+	podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+	return podData, nil
+}
+
+func (v *doPodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+	vm := v.createVM(req.Config)
+
+	return v.bootSandbox(vm, req.Config, vm.Name)
+}
+
+func (v *doPodProvider) PreCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+	return nil
+}
+
+func (v *doPodProvider) StopPodSandbox(ctx context.Context, podData *common.PodData) error {
+	return nil
+}
+
+func (v *doPodProvider) RemovePodSandbox(data *common.PodData) {}
+
+func (v *doPodProvider) PodSandboxStatus(podData *common.PodData) {}
+
+func (v *doPodProvider) HealthCheck() error {
+	if err := v.client.getAccount(); err != nil {
+		return fmt.Errorf("HealthCheck: %v", err)
+	}
+
+	return nil
+}
+
+func (v *doPodProvider) ListInstances() ([]*common.PodData, error) {
+	droplets, err := v.client.listDroplets(infranetesTag)
+	if err != nil {
+		return nil, fmt.Errorf("ListInstances: %v", err)
+	}
+
+	podDatas := []*common.PodData{}
+	for _, d := range droplets {
+		vm := v.newVM(d.Name)
+		vm.Id = d.Id
+
+		client, podIp, config, err := common.ConnectSandbox(vm, v.bootConfig())
+		if err != nil {
+			glog.Warningf("ListInstances: skipping %v: %v", d.Name, err)
+			continue
+		}
+
+		name := d.Name
+
+		providerData := &podData{}
+
+		glog.Infof("ListInstances: creating a podData for %v", name)
+		booted := true
+		podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+		podDatas = append(podDatas, podData)
+	}
+
+	return podDatas, nil
+}
+
+func (v *doPodProvider) GetVMList() ([]string, error) {
+	droplets, err := v.client.listDroplets(infranetesTag)
+	if err != nil {
+		return nil, fmt.Errorf("GetVMList: %v", err)
+	}
+
+	names := []string{}
+	for _, d := range droplets {
+		names = append(names, d.Name)
+	}
+
+	return names, nil
+}
+
+func (v *doPodProvider) VMName(podData *common.PodData) string {
+	if podData.VM == nil {
+		return ""
+	}
+
+	return podData.VM.GetName()
+}
+
+func (v *doPodProvider) newVM(name string) *dropletVM {
+	return &dropletVM{
+		client:  v.client,
+		Name:    name,
+		Region:  v.config.Region,
+		Size:    v.config.Size,
+		Image:   v.config.Image,
+		SshKeys: []string{v.config.SshFingerprint},
+	}
+}
+
+func (v *doPodProvider) createVM(config *kubeapi.PodSandboxConfig) *dropletVM {
+	return v.newVM("kube-infra-" + config.Metadata.Uid)
+}
+
+func (p *podData) Attach(vol, device string) (string, error) {
+	return "", errors.New("Attach: Not implemented yet")
+}
+
+func (p *podData) NeedMount(vol string) bool {
+	return false
+}
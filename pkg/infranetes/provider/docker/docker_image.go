@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
@@ -18,9 +21,26 @@ import (
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
+const (
+	// how often PullImage logs how a pull is coming along
+	pullProgressInterval = 10 * time.Second
+)
+
+// pullMessage is one of the json messages docker streams back while pulling
+type pullMessage struct {
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+	Progress string `json:"progress"`
+	Error    string `json:"error"`
+}
+
 type dockerImageProvider struct {
 	client   *dockerclient.Client
 	imageMap map[string]string
+
+	// registry credentials by image, see RegistryAuth()
+	authLock sync.RWMutex
+	auths    map[string]string
 }
 
 func init() {
@@ -34,6 +54,7 @@ func NewDockerImageProvider() (provider.ImageProvider, error) {
 		dockerImageProvider := &dockerImageProvider{
 			client:   client,
 			imageMap: make(map[string]string),
+			auths:    make(map[string]string),
 		}
 
 		return dockerImageProvider, nil
@@ -101,39 +122,119 @@ func (d *dockerImageProvider) ImageStatus(req *kubeapi.ImageStatusRequest) (*kub
 }
 
 func (d *dockerImageProvider) PullImage(req *kubeapi.PullImageRequest) (*kubeapi.PullImageResponse, error) {
-	pullresp, err := d.client.ImagePull(context.Background(), req.Image.GetImage(), dockertypes.ImagePullOptions{})
+	auth, err := common.EncodeRegistryAuth(req.GetAuth())
+	if err != nil {
+		return nil, fmt.Errorf("PullImage: couldn't encode registry credentials: %v", err)
+	}
+
+	pullresp, err := d.client.ImagePull(context.Background(), req.Image.GetImage(), dockertypes.ImagePullOptions{RegistryAuth: auth})
 	if err != nil {
 		return nil, fmt.Errorf("ImagePull Failed (%v)\n", err)
 	}
 
+	image := req.Image.GetImage()
+	lastLogged := time.Now()
+
 	decoder := json.NewDecoder(pullresp)
 	for {
-		var msg interface{}
+		var msg pullMessage
 		err := decoder.Decode(&msg)
 
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			pullresp.Close()
 			return nil, fmt.Errorf("Pull Image failed: %v", err)
 		}
+		// docker reports failures mid pull in the stream, not as an error from ImagePull
+		if msg.Error != "" {
+			pullresp.Close()
+			return nil, fmt.Errorf("Pull Image failed: %v", msg.Error)
+		}
+
+		glog.V(4).Infof("PullImage: %v: %v %v %v", image, msg.Id, msg.Status, msg.Progress)
+		if time.Since(lastLogged) >= pullProgressInterval {
+			glog.Infof("PullImage: still pulling %v: %v %v %v", image, msg.Id, msg.Status, msg.Progress)
+			lastLogged = time.Now()
+		}
 	}
 
 	pullresp.Close()
 
-	resp := &kubeapi.PullImageResponse{}
+	inspect, _, err := d.client.ImageInspectWithRaw(context.Background(), image, false)
+	if err != nil {
+		return nil, fmt.Errorf("PullImage: pulled %v but couldn't inspect it: %v", image, err)
+	}
 
-	return resp, err
+	d.authLock.Lock()
+	if auth != "" {
+		d.auths[req.Image.GetImage()] = auth
+	} else {
+		delete(d.auths, req.Image.GetImage())
+	}
+	d.authLock.Unlock()
+
+	resp := &kubeapi.PullImageResponse{
+		ImageRef: inspect.ID,
+	}
+
+	return resp, nil
 }
 
 func (d *dockerImageProvider) RemoveImage(req *kubeapi.RemoveImageRequest) (*kubeapi.RemoveImageResponse, error) {
 	_, err := d.client.ImageRemove(context.Background(), req.Image.GetImage(), dockertypes.ImageRemoveOptions{PruneChildren: true})
 
+	d.authLock.Lock()
+	delete(d.auths, req.Image.GetImage())
+	d.authLock.Unlock()
+
 	resp := &kubeapi.RemoveImageResponse{}
 
 	return resp, err
 }
 
+// ImageFsInfo reports the size of docker's images and the inodes in use on the filesystem holding docker's root dir
+func (d *dockerImageProvider) ImageFsInfo(req *kubeapi.ImageFsInfoRequest) (*kubeapi.ImageFsInfoResponse, error) {
+	info, err := d.client.Info(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get docker info: %v", err)
+	}
+
+	images, err := d.client.ImageList(context.Background(), dockertypes.ImageListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list docker images: %v", err)
+	}
+
+	var size uint64
+	for _, i := range images {
+		size += uint64(i.Size)
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(info.DockerRootDir, &fs); err != nil {
+		return nil, fmt.Errorf("couldn't statfs %v: %v", info.DockerRootDir, err)
+	}
+
+	usage := &kubeapi.FilesystemUsage{
+		Timestamp:  time.Now().UnixNano(),
+		StorageId:  &kubeapi.StorageIdentifier{Uuid: info.DockerRootDir},
+		UsedBytes:  &kubeapi.UInt64Value{Value: size},
+		InodesUsed: &kubeapi.UInt64Value{Value: fs.Files - fs.Ffree},
+	}
+
+	return &kubeapi.ImageFsInfoResponse{ImageFilesystems: []*kubeapi.FilesystemUsage{usage}}, nil
+}
+
+// RegistryAuth hands vmserver the credentials the kubelet gave us when it pulled image, as docker in the VM pulls it
+// again
+func (d *dockerImageProvider) RegistryAuth(image string) string {
+	d.authLock.RLock()
+	defer d.authLock.RUnlock()
+
+	return d.auths[image]
+}
+
 func (d *dockerImageProvider) Integrate(pp provider.PodProvider) bool {
 	return true
 }
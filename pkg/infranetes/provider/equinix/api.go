@@ -0,0 +1,303 @@
+/* Minimal client for the parts of the Equinix Metal (formerly Packet) API we need, libretto doesn't support it */
+
+package equinix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apcera/libretto/ssh"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+const (
+	apiBase = "https://api.equinix.com/metal/v1"
+
+	// tag put on every device we create so ListInstances can find them again
+	infranetesTag = "infranetes"
+
+	// bare metal routinely takes 5-15 minutes to become active
+	provisionTimeout = 30 * time.Minute
+	pollInterval     = 15 * time.Second
+)
+
+// errNotFound is returned for a 404, i.e. a device that has been deleted
+var errNotFound = errors.New("not found")
+
+type metalClient struct {
+	token      string
+	projectId  string
+	httpClient *http.Client
+}
+
+func newMetalClient(token, projectId string) *metalClient {
+	return &metalClient{
+		token:      token,
+		projectId:  projectId,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type ipAddress struct {
+	Address       string `json:"address"`
+	AddressFamily int    `json:"address_family"`
+	Public        bool   `json:"public"`
+}
+
+type device struct {
+	Id          string      `json:"id"`
+	Hostname    string      `json:"hostname"`
+	State       string      `json:"state"`
+	IpAddresses []ipAddress `json:"ip_addresses"`
+}
+
+type createDeviceRequest struct {
+	Hostname        string   `json:"hostname"`
+	Plan            string   `json:"plan"`
+	Metro           string   `json:"metro,omitempty"`
+	Facility        []string `json:"facility,omitempty"`
+	OperatingSystem string   `json:"operating_system"`
+	BillingCycle    string   `json:"billing_cycle"`
+	Tags            []string `json:"tags,omitempty"`
+}
+
+func (c *metalClient) do(method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, apiBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v %v failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%v %v: couldn't read response: %v", method, path, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v %v returned %v: %s", method, path, resp.Status, data)
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%v %v: couldn't parse response: %v", method, path, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *metalClient) createDevice(req *createDeviceRequest) (*device, error) {
+	var d device
+	if err := c.do("POST", "/projects/"+c.projectId+"/devices", req, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func (c *metalClient) getDevice(id string) (*device, error) {
+	var d device
+	if err := c.do("GET", "/devices/"+id, nil, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func (c *metalClient) deleteDevice(id string) error {
+	return c.do("DELETE", "/devices/"+id+"?force_delete=true", nil, nil)
+}
+
+func (c *metalClient) deviceAction(id string, action string) error {
+	return c.do("POST", "/devices/"+id+"/actions", map[string]string{"type": action}, nil)
+}
+
+func (c *metalClient) listDevices(tag string) ([]device, error) {
+	var resp struct {
+		Devices []device `json:"devices"`
+	}
+	if err := c.do("GET", "/projects/"+c.projectId+"/devices?per_page=1000&tag="+tag, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Devices, nil
+}
+
+func (c *metalClient) getProject() error {
+	return c.do("GET", "/projects/"+c.projectId, nil, nil)
+}
+
+// deviceVM implements libretto's VirtualMachine interface on top of metalClient so the rest of infranetes can treat a
+// bare metal device like any other VM
+type deviceVM struct {
+	client *metalClient
+
+	Id       string
+	Name     string
+	Plan     string
+	Metro    string
+	Facility string
+	OS       string
+}
+
+func (vm *deviceVM) GetName() string {
+	return vm.Name
+}
+
+func (vm *deviceVM) Provision() error {
+	return vm.provision(context.Background())
+}
+
+// provision creates the device and waits for it to become active, logging every state it passes through as this takes
+// a while.  Leaves vm.Id set if a device was created, even on failure.
+func (vm *deviceVM) provision(ctx context.Context) error {
+	req := &createDeviceRequest{
+		Hostname:        vm.Name,
+		Plan:            vm.Plan,
+		Metro:           vm.Metro,
+		OperatingSystem: vm.OS,
+		BillingCycle:    "hourly",
+		Tags:            []string{infranetesTag},
+	}
+	if vm.Metro == "" {
+		req.Facility = []string{vm.Facility}
+	}
+
+	d, err := vm.client.createDevice(req)
+	if err != nil {
+		return fmt.Errorf("Failed to create device: %v", err)
+	}
+	vm.Id = d.Id
+
+	glog.Infof("provision: created device %v (%v) for %v", vm.Id, vm.Plan, vm.Name)
+
+	start := time.Now()
+	state := d.State
+	for time.Since(start) < provisionTimeout {
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		d, err := vm.client.getDevice(vm.Id)
+		if err != nil {
+			return err
+		}
+
+		if d.State != state {
+			glog.Infof("provision: device %v is %v after %v", vm.Id, d.State, time.Since(start).Truncate(time.Second))
+			state = d.State
+		}
+
+		switch d.State {
+		case "active":
+			return nil
+		case "failed":
+			return fmt.Errorf("device %v failed to provision", vm.Id)
+		}
+	}
+
+	return lvm.ErrVMBootTimeout
+}
+
+// GetIPs returns the device's public ipv4 address first, followed by its private one
+func (vm *deviceVM) GetIPs() ([]net.IP, error) {
+	d, err := vm.client.getDevice(vm.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var public, private []net.IP
+	for _, a := range d.IpAddresses {
+		ip := net.ParseIP(a.Address)
+		if a.AddressFamily != 4 || ip == nil {
+			continue
+		}
+		if a.Public {
+			public = append(public, ip)
+		} else {
+			private = append(private, ip)
+		}
+	}
+
+	ips := append(public, private...)
+	if len(ips) == 0 {
+		return nil, lvm.ErrVMNoIP
+	}
+
+	return ips, nil
+}
+
+func (vm *deviceVM) Destroy() error {
+	return vm.client.deleteDevice(vm.Id)
+}
+
+func (vm *deviceVM) GetState() (string, error) {
+	d, err := vm.client.getDevice(vm.Id)
+	if err != nil {
+		return lvm.VMUnknown, err
+	}
+
+	switch d.State {
+	case "queued", "provisioning", "powering_on":
+		return lvm.VMStarting, nil
+	case "active":
+		return lvm.VMRunning, nil
+	case "inactive", "powering_off":
+		return lvm.VMHalted, nil
+	case "failed":
+		return lvm.VMError, nil
+	}
+
+	return lvm.VMUnknown, nil
+}
+
+func (vm *deviceVM) Suspend() error {
+	return lvm.ErrSuspendNotSupported
+}
+
+func (vm *deviceVM) Resume() error {
+	return lvm.ErrResumeNotSupported
+}
+
+func (vm *deviceVM) Halt() error {
+	return vm.client.deviceAction(vm.Id, "power_off")
+}
+
+func (vm *deviceVM) Start() error {
+	return vm.client.deviceAction(vm.Id, "power_on")
+}
+
+func (vm *deviceVM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	return nil, fmt.Errorf("GetSSH: not supported for devices")
+}
@@ -0,0 +1,37 @@
+package equinix
+
+import (
+	icommon "github.com/apporbit/infranetes/pkg/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+)
+
+type equinixConfig struct {
+	Token     string
+	ProjectId string
+	Plan      string
+	// Metro is preferred, Facility is for projects still placing devices in a specific facility
+	Metro    string
+	Facility string
+	OS       string
+
+	Routes []icommon.AddRouteRequest
+
+	// IPSelection picks the device address we dial, and that the pod is given.  Defaults to the public ip.
+	IPSelection common.IPSelector
+
+	// bare metal takes minutes to provision, so these work like their aws counterparts: ProvisionRetries extra attempts
+	// (0 is none, unset the default) ProvisionBackoff seconds apart (doubled after every failure), and at most
+	// MaxConcurrentProvision devices being provisioned at once, 0 is unlimited
+	ProvisionRetries       *int
+	ProvisionBackoff       int
+	MaxConcurrentProvision int
+
+	// AgentPort is where vmserver listens in the device, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
+}
+
+const (
+	defaultProvisionRetries = 2
+	defaultProvisionBackoff = 30
+)
@@ -0,0 +1,293 @@
+package equinix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/types"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+type podData struct{}
+
+type equinixPodProvider struct {
+	config *equinixConfig
+	client *metalClient
+
+	// holds a token for every device being provisioned when MaxConcurrentProvision is set, nil otherwise
+	provisionSem chan struct{}
+}
+
+func init() {
+	provider.PodProviders.RegisterProvider("equinix", NewEquinixPodProvider)
+}
+
+func NewEquinixPodProvider() (provider.PodProvider, error) {
+	var conf equinixConfig
+
+	file, err := ioutil.ReadFile("equinix.json")
+	if err != nil {
+		return nil, fmt.Errorf("File error: %v\n", err)
+	}
+
+	if err := json.Unmarshal(file, &conf); err != nil {
+		return nil, fmt.Errorf("couldn't parse equinix.json: %v", err)
+	}
+
+	if conf.Token == "" || conf.ProjectId == "" || conf.Plan == "" || conf.OS == "" || (conf.Metro == "" && conf.Facility == "") {
+		msg := fmt.Sprintf("Failed to read in complete config file: conf = %+v", conf)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+
+	if conf.MaxConcurrentProvision < 0 {
+		return nil, errors.New("equinix.json MaxConcurrentProvision can't be negative")
+	}
+	if conf.ProvisionRetries == nil {
+		retries := defaultProvisionRetries
+		conf.ProvisionRetries = &retries
+	} else if *conf.ProvisionRetries < 0 {
+		return nil, errors.New("equinix.json ProvisionRetries can't be negative")
+	}
+	if conf.ProvisionBackoff <= 0 {
+		conf.ProvisionBackoff = defaultProvisionBackoff
+	}
+
+	if conf.AgentPort == 0 {
+		conf.AgentPort = common.DefaultAgentPort
+	}
+	if conf.IPSelection.Prefer == "" && conf.IPSelection.CIDR == "" {
+		conf.IPSelection.Prefer = "public"
+	}
+	if err := conf.IPSelection.Validate(); err != nil {
+		return nil, err
+	}
+
+	client := newMetalClient(conf.Token, conf.ProjectId)
+
+	glog.Infof("Validating Equinix Metal Credentials")
+	if err := client.getProject(); err != nil {
+		msg := fmt.Sprintf("Failed to validate Equinix Metal Credentials: %v", err)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+	glog.Infof("Validated Credentials")
+
+	p := &equinixPodProvider{
+		config: &conf,
+		client: client,
+	}
+
+	if conf.MaxConcurrentProvision > 0 {
+		p.provisionSem = make(chan struct{}, conf.MaxConcurrentProvision)
+	}
+
+	return p, nil
+}
+
+func (*equinixPodProvider) UpdatePodState(data *common.PodData) {
+	if data.Booted {
+		data.UpdatePodState()
+	}
+}
+
+// provisionVM provisions the device, retrying with exponential backoff as a plan is often briefly out of stock in a
+// metro.  A failed attempt's device is deleted before trying again.  Gives up as soon as ctx is done.
+func (p *equinixPodProvider) provisionVM(ctx context.Context, vm *deviceVM) error {
+	if p.provisionSem != nil {
+		select {
+		case p.provisionSem <- struct{}{}:
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting to provision device: %v", ctx.Err())
+		}
+		defer func() { <-p.provisionSem }()
+	}
+
+	backoff := time.Duration(p.config.ProvisionBackoff) * time.Second
+
+	var err error
+	for attempt := 0; attempt <= *p.config.ProvisionRetries; attempt++ {
+		if attempt > 0 {
+			glog.Warningf("provisionVM: attempt %d for %v failed: %v, retrying in %v", attempt, vm.Name, err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("gave up provisioning device: %v", ctx.Err())
+			}
+			backoff *= 2
+		}
+
+		start := time.Now()
+		if err = vm.provision(ctx); err == nil {
+			glog.Infof("provisionVM: %v is active after %v", vm.Name, time.Since(start).Truncate(time.Second))
+			return nil
+		}
+
+		if vm.Id != "" {
+			if derr := vm.Destroy(); derr != nil {
+				glog.Warningf("provisionVM: couldn't delete partially provisioned device %v: %v", vm.Id, derr)
+			}
+			vm.Id = ""
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("failed to provision device after %d attempts: %v", *p.config.ProvisionRetries+1, err)
+}
+
+// bootConfig is what common.BootSandbox and common.ConnectSandbox need of our config
+func (v *equinixPodProvider) bootConfig() *common.BootConfig {
+	return &common.BootConfig{
+		IPSelection: v.config.IPSelection,
+		AgentPort:   v.config.AgentPort,
+		Routes:      v.config.Routes,
+	}
+}
+
+func (p *equinixPodProvider) bootSandbox(ctx context.Context, vm *deviceVM, config *kubeapi.PodSandboxConfig, name string) (*common.PodData, error) {
+	client, podIp, err := common.BootSandbox(vm, func() error {
+		return p.provisionVM(ctx, vm)
+	}, config, p.bootConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	providerData := &podData{}
+
+	booted := true
+
+	podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+	return podData, nil
+}
+
+func (v *equinixPodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+	vm := v.newVM("kube-infra-" + req.Config.Metadata.Uid)
+
+	return v.bootSandbox(ctx, vm, req.Config, vm.Name)
+}
+
+func (v *equinixPodProvider) PreCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+	return nil
+}
+
+func (v *equinixPodProvider) StopPodSandbox(ctx context.Context, podData *common.PodData) error {
+	return nil
+}
+
+func (v *equinixPodProvider) RemovePodSandbox(data *common.PodData) {}
+
+func (v *equinixPodProvider) PodSandboxStatus(podData *common.PodData) {}
+
+func (v *equinixPodProvider) HealthCheck() error {
+	if err := v.client.getProject(); err != nil {
+		return fmt.Errorf("HealthCheck: %v", err)
+	}
+
+	return nil
+}
+
+// VMExists lets the reconciler drop pods whose device was deleted behind our back
+func (v *equinixPodProvider) VMExists(podData *common.PodData) (bool, error) {
+	vm, ok := podData.VM.(*deviceVM)
+	if !ok {
+		return true, nil
+	}
+
+	if _, err := v.client.getDevice(vm.Id); err == errNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("VMExists: %v", err)
+	}
+
+	return true, nil
+}
+
+func (v *equinixPodProvider) ListInstances() ([]*common.PodData, error) {
+	devices, err := v.client.listDevices(infranetesTag)
+	if err != nil {
+		return nil, fmt.Errorf("ListInstances: %v", err)
+	}
+
+	podDatas := []*common.PodData{}
+	for _, d := range devices {
+		if d.State != "active" {
+			glog.Infof("ListInstances: skipping %v as it is %v", d.Hostname, d.State)
+			continue
+		}
+
+		vm := v.newVM(d.Hostname)
+		vm.Id = d.Id
+
+		client, podIp, config, err := common.ConnectSandbox(vm, v.bootConfig())
+		if err != nil {
+			glog.Warningf("ListInstances: skipping %v: %v", d.Hostname, err)
+			continue
+		}
+
+		name := d.Hostname
+
+		providerData := &podData{}
+
+		glog.Infof("ListInstances: creating a podData for %v", name)
+		booted := true
+		podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+		podDatas = append(podDatas, podData)
+	}
+
+	return podDatas, nil
+}
+
+func (v *equinixPodProvider) GetVMList() ([]string, error) {
+	devices, err := v.client.listDevices(infranetesTag)
+	if err != nil {
+		return nil, fmt.Errorf("GetVMList: %v", err)
+	}
+
+	names := []string{}
+	for _, d := range devices {
+		names = append(names, d.Hostname)
+	}
+
+	return names, nil
+}
+
+func (v *equinixPodProvider) VMName(podData *common.PodData) string {
+	if podData.VM == nil {
+		return ""
+	}
+
+	return podData.VM.GetName()
+}
+
+func (v *equinixPodProvider) newVM(name string) *deviceVM {
+	return &deviceVM{
+		client:   v.client,
+		Name:     name,
+		Plan:     v.config.Plan,
+		Metro:    v.config.Metro,
+		Facility: v.config.Facility,
+		OS:       v.config.OS,
+	}
+}
+
+func (p *podData) Attach(vol, device string) (string, error) {
+	return "", errors.New("Attach: Not implemented yet")
+}
+
+func (p *podData) NeedMount(vol string) bool {
+	return false
+}
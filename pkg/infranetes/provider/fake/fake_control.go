@@ -0,0 +1,105 @@
+/* Lets tests drive the fake pod provider, i.e. make its operations fail or have a pod's VM go away */
+
+package fake
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// Hooks are called before the fake provider does the corresponding operation, an error fails it.  nil hooks succeed.
+type Hooks struct {
+	RunPodSandbox  func(req *kubeapi.RunPodSandboxRequest) error
+	StopPodSandbox func(podData *common.PodData) error
+	// Destroy is called by the Destroy() of the pod's VM
+	Destroy func(podId string) error
+}
+
+// Controller is implemented by the fake pod provider, i.e. m.podProvider.(fake.Controller)
+type Controller interface {
+	SetHooks(hooks Hooks)
+	// SetReady makes the pod's client report err from Ready(), so the pod goes not ready.  nil makes it ready again.
+	SetReady(podId string, err error) error
+	// DeleteVM makes it look like the pod's VM was deleted behind our back, its client stops answering and VMExists()
+	// returns false
+	DeleteVM(podId string) error
+}
+
+// readyClient is a fake client whose Ready() result can be changed
+type readyClient struct {
+	common.Client
+
+	lock  sync.Mutex
+	ready error
+}
+
+func (c *readyClient) Ready() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.ready
+}
+
+func (c *readyClient) setReady(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ready = err
+}
+
+func (p *fakePodProvider) SetHooks(hooks Hooks) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.hooks = hooks
+}
+
+func (p *fakePodProvider) getHooks() Hooks {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.hooks
+}
+
+func (p *fakePodProvider) SetReady(podId string, err error) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	instance, ok := p.instances[podId]
+	if !ok {
+		return fmt.Errorf("SetReady: unknown pod %v", podId)
+	}
+
+	instance.client.setReady(err)
+
+	return nil
+}
+
+func (p *fakePodProvider) DeleteVM(podId string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	instance, ok := p.instances[podId]
+	if !ok {
+		return fmt.Errorf("DeleteVM: unknown pod %v", podId)
+	}
+
+	instance.deleted = true
+	instance.client.setReady(fmt.Errorf("VM of %v was deleted", podId))
+
+	return nil
+}
+
+// VMExists lets the reconciler see VMs removed by DeleteVM()
+func (p *fakePodProvider) VMExists(podData *common.PodData) (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	instance, ok := p.instances[podData.Id]
+
+	return ok && !instance.deleted, nil
+}
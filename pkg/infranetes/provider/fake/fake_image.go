@@ -2,6 +2,7 @@ package fake
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/apporbit/infranetes/pkg/infranetes/provider"
 
@@ -31,7 +32,7 @@ func (p *fakeImageProvider) Integrate(pp provider.PodProvider) bool {
 func (p *fakeImageProvider) PullImage(req *kubeapi.PullImageRequest) (*kubeapi.PullImageResponse, error) {
 	p.imageList[req.GetImage().GetImage()] = true
 
-	return &kubeapi.PullImageResponse{}, nil
+	return &kubeapi.PullImageResponse{ImageRef: req.GetImage().GetImage()}, nil
 }
 
 func (p *fakeImageProvider) ListImages(req *kubeapi.ListImagesRequest) (*kubeapi.ListImagesResponse, error) {
@@ -87,6 +88,17 @@ func (p *fakeImageProvider) RemoveImage(req *kubeapi.RemoveImageRequest) (*kubea
 	return &kubeapi.RemoveImageResponse{}, nil
 }
 
+func (p *fakeImageProvider) ImageFsInfo(req *kubeapi.ImageFsInfoRequest) (*kubeapi.ImageFsInfoResponse, error) {
+	usage := &kubeapi.FilesystemUsage{
+		Timestamp:  time.Now().UnixNano(),
+		StorageId:  &kubeapi.StorageIdentifier{Uuid: "fake"},
+		UsedBytes:  &kubeapi.UInt64Value{Value: 0},
+		InodesUsed: &kubeapi.UInt64Value{Value: uint64(len(p.imageList))},
+	}
+
+	return &kubeapi.ImageFsInfoResponse{ImageFilesystems: []*kubeapi.FilesystemUsage{usage}}, nil
+}
+
 func (p *fakeImageProvider) Translate(spec *kubeapi.ImageSpec) (string, error) {
 	return spec.Image, nil
 }
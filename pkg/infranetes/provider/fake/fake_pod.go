@@ -1,8 +1,13 @@
 package fake
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"strconv"
+	"sync"
+
+	"golang.org/x/net/context"
 
 	"github.com/apporbit/infranetes/cmd/infranetes/flags"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider"
@@ -13,9 +18,19 @@ import (
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
+type fakeInstance struct {
+	podData *common.PodData
+	client  *readyClient
+	deleted bool
+}
+
 type fakePodProvider struct {
-	instances map[string]*common.PodData
+	lock      sync.Mutex
+	instances map[string]*fakeInstance
+	hooks     Hooks
 	ipList    *utils.Deque
+	// the pod CIDR ips are handed out from, nil while they come from --base-ip
+	podNet *net.IPNet
 }
 
 func init() {
@@ -30,7 +45,7 @@ func NewFakePodProvider() (provider.PodProvider, error) {
 	}
 
 	provider := &fakePodProvider{
-		instances: make(map[string]*common.PodData),
+		instances: make(map[string]*fakeInstance),
 		ipList:    ipList,
 	}
 
@@ -39,37 +54,129 @@ func NewFakePodProvider() (provider.PodProvider, error) {
 
 func (p *fakePodProvider) SetBootAtRun(boot bool) {}
 
-func (p *fakePodProvider) RunPodSandbox(req *kubeapi.RunPodSandboxRequest, voluems []*types.Volume) (*common.PodData, error) {
+func (p *fakePodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, voluems []*types.Volume) (*common.PodData, error) {
+	// hooks are called without the lock so they can use the Controller
+	if hook := p.getHooks().RunPodSandbox; hook != nil {
+		if err := hook(req); err != nil {
+			return nil, err
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	name := "fake-" + utils.RandString(10)
 	vm := &fakeVM{
-		name: name,
+		name:     name,
+		provider: p,
+	}
+
+	ip := p.ipList.Shift()
+	if ip == nil {
+		return nil, errors.New("RunPodSandbox: out of fake ips")
 	}
+	podIp := ip.(string)
 
-	client, _ := common.CreateFakeClient()
-	podIp := p.ipList.Shift().(string)
+	fakeClient, _ := common.CreateFakeClient()
+	client := &readyClient{Client: fakeClient}
 	booted := true
 	podData := common.NewPodData(vm, vm.name, req.Config.Metadata, req.Config.Annotations, req.Config.Labels, podIp, req.Config.Linux, client, booted, nil)
 
-	p.instances[name] = podData
+	p.instances[name] = &fakeInstance{podData: podData, client: client}
 
 	return podData, nil
 }
 
-func (*fakePodProvider) PreCreateContainer(podData *common.PodData, req *kubeapi.CreateContainerRequest, f func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+func (*fakePodProvider) PreCreateContainer(ctx context.Context, podData *common.PodData, req *kubeapi.CreateContainerRequest, f func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
 	return nil
 }
 
 func (*fakePodProvider) UpdatePodState(cPodData *common.PodData) {}
 
-func (*fakePodProvider) StopPodSandbox(podData *common.PodData) {}
+func (p *fakePodProvider) StopPodSandbox(ctx context.Context, podData *common.PodData) error {
+	if hook := p.getHooks().StopPodSandbox; hook != nil {
+		return hook(podData)
+	}
+
+	return nil
+}
 
 func (v *fakePodProvider) RemovePodSandbox(data *common.PodData) {
-	// putting ip back into queue
-	v.ipList.Append(data.Ip)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	delete(v.instances, data.Id)
+
+	// putting ip back into queue, unless it is from before the pod CIDR changed
+	if v.podNet == nil || v.podNet.Contains(net.ParseIP(data.Ip)) {
+		v.ipList.Append(data.Ip)
+	}
+}
+
+// UpdatePodCIDR has pods get their ips from cidr, in place of --base-ip, skipping the ones running pods already have
+func (v *fakePodProvider) UpdatePodCIDR(cidr string) error {
+	ip, podNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	ip = ip.Mask(podNet.Mask).To4()
+	if ip == nil {
+		return fmt.Errorf("UpdatePodCIDR: %v isn't an IPv4 CIDR", cidr)
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	inUse := make(map[string]bool)
+	for _, instance := range v.instances {
+		inUse[instance.podData.Ip] = true
+	}
+
+	// as many as NewFakePodProvider hands out, starting after the network address
+	ipList := utils.NewDeque()
+	for i := 1; i <= 255; i++ {
+		ip = nextIP(ip)
+		if !podNet.Contains(ip) {
+			break
+		}
+		if !inUse[ip.String()] {
+			ipList.Append(ip.String())
+		}
+	}
+
+	v.ipList = ipList
+	v.podNet = podNet
+
+	return nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
 }
 
 func (v *fakePodProvider) PodSandboxStatus(podData *common.PodData) {}
 
+func (v *fakePodProvider) HealthCheck() error {
+	return nil
+}
+
+// Capabilities: the fake client has no streaming server, only ExecSync is run by the fake exec provider
+func (v *fakePodProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ExecSync: true,
+	}
+}
+
 func (v *fakePodProvider) ListInstances() ([]*common.PodData, error) {
 	return nil, nil
 }
@@ -4,10 +4,16 @@ import (
 	"net"
 
 	"github.com/apcera/libretto/ssh"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
 )
 
+var _ common.VM = &fakeVM{}
+
+// fakeVM does nothing, it stands in for a real VM wherever a common.VM is needed
 type fakeVM struct {
-	name string
+	name     string
+	provider *fakePodProvider
 }
 
 func (v *fakeVM) GetName() string {
@@ -22,6 +28,10 @@ func (v *fakeVM) GetIPs() ([]net.IP, error) {
 }
 
 func (v *fakeVM) Destroy() error {
+	if hook := v.provider.getHooks().Destroy; hook != nil {
+		return hook(v.name)
+	}
+
 	return nil
 }
 
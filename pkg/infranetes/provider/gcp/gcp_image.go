@@ -7,22 +7,34 @@ import (
 	"io/ioutil"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	compute "google.golang.org/api/compute/v1"
 
 	"github.com/apporbit/infranetes/pkg/common/gcp"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
 
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
+const (
+	// how long a pulled image is trusted before PullImage looks it up again, so a re-labeled image gets picked up
+	imageCacheTTL = 10 * time.Minute
+)
+
 type gcpImageProvider struct {
 	lock sync.RWMutex
 
-	config   *gcp.GceConfig
+	config *gcp.GceConfig
+	// built once, its oauth2 transport refreshes the token itself when it expires
+	service  *gcp.GcpSvcWrapper
 	imageMap map[string]*kubeapi.Image
+	pulled   map[string]time.Time
 }
 
 func init() {
@@ -45,9 +57,16 @@ func NewGCPImageProvider() (provider.ImageProvider, error) {
 		return nil, fmt.Errorf(msg)
 	}
 
+	s, err := gcp.GetService(conf.AuthFile, conf.Project, conf.Zone, []string{conf.Scope})
+	if err != nil {
+		return nil, fmt.Errorf("can't get gcp service: %v", err)
+	}
+
 	provider := &gcpImageProvider{
 		config:   &conf,
+		service:  s,
 		imageMap: make(map[string]*kubeapi.Image),
+		pulled:   make(map[string]time.Time),
 	}
 
 	return provider, nil
@@ -64,7 +83,14 @@ func (p *gcpImageProvider) ListImages(req *kubeapi.ListImagesRequest) (*kubeapi.
 			result = append(result, image)
 		}
 	} else {
+		// the same gce image can be pulled under more than one name (i.e. with and without its project), the kubelet
+		// should only see it once
+		seen := make(map[string]bool)
 		for _, image := range p.imageMap {
+			if seen[image.Id] {
+				continue
+			}
+			seen[image.Id] = true
 			result = append(result, image)
 		}
 	}
@@ -77,16 +103,16 @@ func (p *gcpImageProvider) ListImages(req *kubeapi.ListImagesRequest) (*kubeapi.
 }
 
 func (p *gcpImageProvider) ImageStatus(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error) {
-	name := req.Image.Image
+	name := req.GetImage().GetImage()
 
+	// normalize into our own ImageSpec, the caller's request is left alone
 	if len(strings.Split(name, ":")) == 1 {
 		name += ":latest"
-		req.Image.Image = name
 	}
 
 	newreq := &kubeapi.ListImagesRequest{
 		Filter: &kubeapi.ImageFilter{
-			Image: req.Image,
+			Image: &kubeapi.ImageSpec{Image: name},
 		},
 	}
 
@@ -110,7 +136,11 @@ func toRuntimeAPIImage(image *compute.Image) (*kubeapi.Image, error) {
 		return nil, errors.New("unable to convert a nil pointer to a runtime API image")
 	}
 
+	// images made from a disk rather than an uploaded archive have no archive size, what they take up is their disk
 	size := uint64(image.ArchiveSizeBytes)
+	if size == 0 {
+		size = uint64(image.DiskSizeGb) << 30
+	}
 
 	repoTag := image.Labels["infranetes-name"] + ":" + image.Labels["infranetes-version"]
 	glog.Infof("RepoTag = %v", repoTag)
@@ -123,12 +153,27 @@ func toRuntimeAPIImage(image *compute.Image) (*kubeapi.Image, error) {
 	}, nil
 }
 
+// cached returns the image if it was pulled within imageCacheTTL
+func (p *gcpImageProvider) cached(name string) (*kubeapi.Image, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	image, ok := p.imageMap[name]
+	if !ok || time.Since(p.pulled[name]) > imageCacheTTL {
+		return nil, false
+	}
+
+	return image, true
+}
+
 func (p *gcpImageProvider) PullImage(req *kubeapi.PullImageRequest) (*kubeapi.PullImageResponse, error) {
-	s, err := gcp.GetService(p.config.AuthFile, p.config.Project, p.config.Zone, []string{p.config.Scope})
-	if err != nil {
-		return nil, fmt.Errorf("PullImage: can't get gcp service %v", err)
+	if image, ok := p.cached(req.Image.Image); ok {
+		glog.V(2).Infof("PullImage: using cached image %v for %v", image.Id, req.Image.Image)
+		return &kubeapi.PullImageResponse{ImageRef: image.Id}, nil
 	}
 
+	s := p.service
+
 	splits := strings.Split(req.Image.Image, "/")
 	var project string
 	var fullname string
@@ -166,7 +211,8 @@ func (p *gcpImageProvider) PullImage(req *kubeapi.PullImageRequest) (*kubeapi.Pu
 	for {
 		list, err := s.Service.Images.List(project).PageToken(nextPageToken).Do()
 		if err != nil {
-			return nil, fmt.Errorf("ListInstances failed: %v", err)
+			// a bad or expired service account only shows up here, as the jwt token is fetched lazily
+			return nil, fmt.Errorf("PullImage: listing images in %v failed: %v", project, err)
 		}
 
 		for _, i := range list.Items {
@@ -180,6 +226,7 @@ func (p *gcpImageProvider) PullImage(req *kubeapi.PullImageRequest) (*kubeapi.Pu
 					return nil, fmt.Errorf("PullImage: toRuntimeAPIImage failed: %v", err)
 				}
 				p.imageMap[req.Image.Image] = image
+				p.pulled[req.Image.Image] = time.Now()
 
 				return &kubeapi.PullImageResponse{ImageRef: i.Name}, nil
 			}
@@ -196,15 +243,47 @@ func (p *gcpImageProvider) PullImage(req *kubeapi.PullImageRequest) (*kubeapi.Pu
 	return nil, fmt.Errorf("PullImage: couldn't find any image matching %v", req.Image.Image)
 }
 
+// RemoveImage forgets every name the image was pulled under.  The kubelet's image gc removes by the id ListImages
+// returned, others by the name they pulled, so either matches.
 func (p *gcpImageProvider) RemoveImage(req *kubeapi.RemoveImageRequest) (*kubeapi.RemoveImageResponse, error) {
+	name := req.GetImage().GetImage()
+	tagged := name
+	if len(strings.Split(name, ":")) == 1 {
+		tagged += ":latest"
+	}
+
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	delete(p.imageMap, req.Image.Image)
+	found := false
+	for key, image := range p.imageMap {
+		if key == name || key == tagged || image.Id == name {
+			delete(p.imageMap, key)
+			delete(p.pulled, key)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, grpc.Errorf(codes.NotFound, "RemoveImage: image %v not found", name)
+	}
 
 	return &kubeapi.RemoveImageResponse{}, nil
 }
 
+func (p *gcpImageProvider) ImageFsInfo(req *kubeapi.ImageFsInfoRequest) (*kubeapi.ImageFsInfoResponse, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	// count every gce image once, however many names it was pulled under
+	images := make(map[string]*kubeapi.Image)
+	for _, image := range p.imageMap {
+		images[image.Id] = image
+	}
+
+	return common.ImagesFsInfo("gcp-image", images), nil
+}
+
 func (p *gcpImageProvider) Integrate(pp provider.PodProvider) bool {
 	switch pp.(type) {
 	case *gcpPodProvider:
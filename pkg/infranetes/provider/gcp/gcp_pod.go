@@ -9,6 +9,7 @@ import (
 	"sync"
 
 	"github.com/golang/glog"
+	"golang.org/x/net/context"
 
 	gcpvm "github.com/apcera/libretto/virtualmachine/gcp"
 
@@ -24,16 +25,50 @@ import (
 
 const (
 	devPrefix = "/dev/disk/by-id/google-"
+
+	defaultMachineType = "g1-small"
 )
 
+// defaultMachineSizes start at defaultMachineType so sizing never boots a pod on something smaller than it used to get
+var defaultMachineSizes = []common.Size{
+	{Name: "g1-small", CPUs: 0.5, MemoryMB: 1740},
+	{Name: "n1-standard-1", CPUs: 1, MemoryMB: 3840},
+	{Name: "n1-standard-2", CPUs: 2, MemoryMB: 7680},
+	{Name: "n1-standard-4", CPUs: 4, MemoryMB: 15360},
+	{Name: "n1-standard-8", CPUs: 8, MemoryMB: 30720},
+	{Name: "n1-standard-16", CPUs: 16, MemoryMB: 61440},
+}
+
+// gcpPodConfig is gce.json as read by the pod provider, which also has the machine types pods are sized with
+type gcpPodConfig struct {
+	gcp.GceConfig
+
+	// MachineSizes, smallest first, is what pods' infranetes.cpu and infranetes.memory annotations pick their machine
+	// type from, defaults to defaultMachineSizes
+	MachineSizes []common.Size
+
+	// AgentPort is where vmserver listens in the instance, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
+}
+
 func init() {
 	provider.PodProviders.RegisterProvider("gcp", NewGCPPodProvider)
 }
 
 type gcpPodProvider struct {
-	config   *gcp.GceConfig
-	ipList   *utils.Deque
-	imagePod bool
+	// swapped by ReloadConfig, read it with getConfig()
+	configLock sync.RWMutex
+	config     *gcpPodConfig
+	// built once, its oauth2 transport refreshes the token itself when it expires
+	service   *gcp.GcpSvcWrapper
+	agentPort int
+	ipList    *utils.Deque
+	imagePod  bool
+
+	// pods on preemptible instances, by instance name
+	preemptLock sync.Mutex
+	preemptPods map[string]*common.PodData
 }
 
 type podData struct {
@@ -44,15 +79,24 @@ type podData struct {
 	service    *gcp.GcpSvcWrapper
 }
 
-func NewGCPPodProvider() (provider.PodProvider, error) {
-	var conf gcp.GceConfig
+// loadGCPPodConfig reads and checks gce.json, filling in the defaults
+func loadGCPPodConfig() (*gcpPodConfig, error) {
+	var podConf gcpPodConfig
 
 	file, err := ioutil.ReadFile("gce.json")
 	if err != nil {
 		return nil, fmt.Errorf("File error: %v\n", err)
 	}
 
-	json.Unmarshal(file, &conf)
+	json.Unmarshal(file, &podConf)
+
+	conf := podConf.GceConfig
+	if len(podConf.MachineSizes) == 0 {
+		podConf.MachineSizes = defaultMachineSizes
+	}
+	if podConf.AgentPort == 0 {
+		podConf.AgentPort = common.DefaultAgentPort
+	}
 
 	if conf.SourceImage == "" || conf.Zone == "" || conf.Project == "" || conf.Scope == "" || conf.AuthFile == "" || conf.Network == "" || conf.Subnet == "" {
 		msg := fmt.Sprintf("Failed to read in complete config file: conf = %+v", conf)
@@ -60,6 +104,16 @@ func NewGCPPodProvider() (provider.PodProvider, error) {
 		return nil, fmt.Errorf(msg)
 	}
 
+	return &podConf, nil
+}
+
+func NewGCPPodProvider() (provider.PodProvider, error) {
+	podConf, err := loadGCPPodConfig()
+	if err != nil {
+		return nil, err
+	}
+	conf := podConf.GceConfig
+
 	// FIXME: add autodetection like AWS
 	if *flags.MasterIP == "" || *flags.IPBase == "" {
 		return nil, fmt.Errorf("GCP doesn't have autodetection yet: MasterIP = %v, IPBase = %v", *flags.MasterIP, *flags.IPBase)
@@ -70,10 +124,46 @@ func NewGCPPodProvider() (provider.PodProvider, error) {
 		ipList.Append(fmt.Sprint(*flags.IPBase + "." + strconv.Itoa(i)))
 	}
 
-	return &gcpPodProvider{
-		config: &conf,
-		ipList: ipList,
-	}, nil
+	s, err := gcp.GetService(conf.AuthFile, conf.Project, conf.Zone, []string{conf.Scope})
+	if err != nil {
+		return nil, fmt.Errorf("can't get gcp service: %v", err)
+	}
+
+	p := &gcpPodProvider{
+		config:      podConf,
+		service:     s,
+		agentPort:   podConf.AgentPort,
+		ipList:      ipList,
+		preemptPods: make(map[string]*common.PodData),
+	}
+
+	go p.preemptWatcher()
+
+	return p, nil
+}
+
+func (v *gcpPodProvider) getConfig() *gcpPodConfig {
+	v.configLock.RLock()
+	defer v.configLock.RUnlock()
+
+	return v.config
+}
+
+// ReloadConfig re-reads gce.json.  Where instances are created and the credentials service was built with keep their
+// old values, the source image, machine sizes and preemptibility can change.
+func (v *gcpPodProvider) ReloadConfig() error {
+	conf, err := loadGCPPodConfig()
+	if err != nil {
+		return err
+	}
+
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+
+	common.KeepFixedFields("gce.json", v.config, conf, "Zone", "Project", "Scope", "AuthFile", "Network", "Subnet", "AgentPort")
+	v.config = conf
+
+	return nil
 }
 
 func (*gcpPodProvider) UpdatePodState(data *common.PodData) {
@@ -82,13 +172,8 @@ func (*gcpPodProvider) UpdatePodState(data *common.PodData) {
 	}
 }
 
-func (p *gcpPodProvider) tagImage(name string) {
-	s, err := gcp.GetService(p.config.AuthFile, p.config.Project, p.config.Zone, []string{p.config.Scope})
-	if err != nil {
-		glog.Errorf("tagImage: failed to tag: %v", name)
-		return
-	}
-	err = s.TagNewInstance(name)
+func (p *gcpPodProvider) tagImage(name string, labels map[string]string) {
+	err := p.service.TagNewInstance(name, labels)
 	if err != nil {
 		glog.Errorf("tagImage: failed: %v", err)
 	}
@@ -97,10 +182,7 @@ func (p *gcpPodProvider) tagImage(name string) {
 func (p *gcpPodProvider) bootSandbox(vm *gcpvm.VM, config *kubeapi.PodSandboxConfig, name string, volumes []*types.Volume) (*common.PodData, error) {
 	cAnno := common.ParseCommonAnnotations(config.Annotations)
 
-	s, err := gcp.GetService(p.config.AuthFile, p.config.Project, p.config.Zone, []string{p.config.Scope})
-	if err != nil {
-		return nil, fmt.Errorf("CreatePodSandbox: failed to get gcp service")
-	}
+	s := p.service
 
 	// Testing
 	attached := make(map[string]string)
@@ -115,20 +197,26 @@ func (p *gcpPodProvider) bootSandbox(vm *gcpvm.VM, config *kubeapi.PodSandboxCon
 
 	ips, err := vm.GetIPs()
 	if err != nil {
+		p.destroyVM(vm)
 		return nil, fmt.Errorf("CreatePodSandbox: error in GetIPs(): %v", err)
 	}
 
-	p.tagImage(vm.Name)
+	p.tagImage(vm.Name, instanceLabels(config))
 
 	glog.Infof("CreatePodSandbox: ips = %v", ips)
 
 	// FIXME: Perhaps better way to choose public vs private ip
 	index := 1
+	if len(ips) <= index || ips[index] == nil {
+		p.destroyVM(vm)
+		return nil, fmt.Errorf("CreatePodSandbox: GetIPs() didn't return a private ip: %v", ips)
+	}
 	podIp := ips[index].String()
 
-	client, err := common.CreateRealClient(podIp)
+	client, err := common.WaitForAgent(podIp, p.agentPort, *flags.VMReadyTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("CreatePodSandbox: error in createClient(): %v", err)
+		p.destroyVM(vm)
+		return nil, fmt.Errorf("CreatePodSandbox: %v", err)
 	}
 
 	providerData := &podData{
@@ -181,33 +269,56 @@ func (p *gcpPodProvider) bootSandbox(vm *gcpvm.VM, config *kubeapi.PodSandboxCon
 	return podData, nil
 }
 
-func (v *gcpPodProvider) RunPodSandbox(req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+// destroyVM tears down an instance that was provisioned but couldn't be turned into a pod, so it isn't leaked
+func (p *gcpPodProvider) destroyVM(vm *gcpvm.VM) {
+	if err := vm.Destroy(); err != nil {
+		glog.Warningf("destroyVM: couldn't destroy %v: %v", vm.Name, err)
+	}
+}
+
+func (v *gcpPodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+	conf := v.getConfig()
+
 	name := "infranetes-" + req.GetConfig().GetMetadata().GetUid()
+
+	machineType, err := common.ResolveSize(conf.MachineSizes, req.Config.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("RunPodSandbox: %v", err)
+	}
+	if machineType == "" {
+		machineType = defaultMachineType
+	}
+
 	podIp := v.ipList.Shift().(string)
 
 	disk := []gcpvm.Disk{{DiskType: "pd-standard", DiskSizeGb: 10, AutoDelete: true}}
 
 	vm := &gcpvm.VM{
 		Name:             name,
-		Zone:             v.config.Zone,
-		MachineType:      "g1-small",
-		SourceImage:      v.config.SourceImage,
+		Zone:             conf.Zone,
+		MachineType:      machineType,
+		SourceImage:      conf.SourceImage,
 		Disks:            disk,
-		Preemptible:      false,
-		Network:          v.config.Network,
-		Subnetwork:       v.config.Subnet,
+		Preemptible:      v.preemptible(req.Config.Annotations),
+		Network:          conf.Network,
+		Subnetwork:       conf.Subnet,
 		UseInternalIP:    false,
-		ImageProjects:    []string{v.config.Project},
-		Project:          v.config.Project,
-		Scopes:           []string{v.config.Scope},
-		AccountFile:      v.config.AuthFile,
+		ImageProjects:    []string{conf.Project},
+		Project:          conf.Project,
+		Scopes:           []string{conf.Scope},
+		AccountFile:      conf.AuthFile,
 		Tags:             []string{"infranetes"},
 		PrivateIPAddress: podIp,
 	}
 
 	if !v.imagePod { // Traditional Pod, but within a VM
 		ret, err := v.bootSandbox(vm, req.Config, podIp, volumes)
-		if err == nil {
+		if err != nil {
+			v.ipList.Append(podIp)
+		} else {
+			if vm.Preemptible {
+				v.watchPreempted(ret, vm.Name)
+			}
 			// FIXME: Google's version of elastic IP handling goes here
 		}
 
@@ -230,7 +341,7 @@ func (v *gcpPodProvider) RunPodSandbox(req *kubeapi.RunPodSandboxRequest, volume
 }
 
 // FIXME: if booting a VM here fails, do we want to fail the whole pod?
-func (v *gcpPodProvider) PreCreateContainer(data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+func (v *gcpPodProvider) PreCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
 	data.BootLock.Lock()
 	defer data.BootLock.Unlock()
 
@@ -280,18 +391,23 @@ func (v *gcpPodProvider) PreCreateContainer(data *common.PodData, req *kubeapi.C
 	data.Client = newPodData.Client
 	data.ProviderData = newPodData.ProviderData
 
+	if vm.Preemptible {
+		v.watchPreempted(data, vm.Name)
+	}
+
 	return nil
 }
-func (v *gcpPodProvider) StopPodSandbox(pdata *common.PodData) {
-	providerData, ok := pdata.ProviderData.(*podData)
-	providerData.lock.Lock()
-	defer providerData.lock.Unlock()
 
+func (v *gcpPodProvider) StopPodSandbox(ctx context.Context, pdata *common.PodData) error {
+	providerData, ok := pdata.ProviderData.(*podData)
 	if !ok {
 		glog.Warningf("StopPodSandbox: couldn't type assert ProviderData to podData")
-		return
+		return nil
 	}
 
+	providerData.lock.Lock()
+	defer providerData.lock.Unlock()
+
 	for _, vol := range providerData.volumes {
 		if vol.MountPoint != "" {
 			err := pdata.Client.UnmountFs(vol.MountPoint)
@@ -306,9 +422,15 @@ func (v *gcpPodProvider) StopPodSandbox(pdata *common.PodData) {
 	}
 
 	providerData.volumes = nil
+
+	return nil
 }
 
 func (v *gcpPodProvider) RemovePodSandbox(data *common.PodData) {
+	if vm, ok := data.VM.(*gcpvm.VM); ok {
+		v.unwatchPreempted(vm.Name)
+	}
+
 	glog.Infof("RemovePodSandbox: release IP: %v", data.Ip)
 
 	v.ipList.Append(data.Ip)
@@ -316,12 +438,19 @@ func (v *gcpPodProvider) RemovePodSandbox(data *common.PodData) {
 
 func (v *gcpPodProvider) PodSandboxStatus(podData *common.PodData) {}
 
+func (v *gcpPodProvider) HealthCheck() error {
+	conf := v.getConfig()
+
+	if _, err := v.service.Service.Zones.Get(conf.Project, conf.Zone).Do(); err != nil {
+		return fmt.Errorf("HealthCheck: couldn't get zone %v: %v", conf.Zone, err)
+	}
+
+	return nil
+}
+
 func (v *gcpPodProvider) ListInstances() ([]*common.PodData, error) {
 	glog.Infof("ListInstances: enter")
-	s, err := gcp.GetService(v.config.AuthFile, v.config.Project, v.config.Zone, []string{v.config.Scope})
-	if err != nil {
-		return nil, fmt.Errorf("ListInstances: GetServices failed: %v", err)
-	}
+	s := v.service
 
 	instances, err := s.ListInstances()
 	if err != nil {
@@ -332,7 +461,7 @@ func (v *gcpPodProvider) ListInstances() ([]*common.PodData, error) {
 	for _, instance := range instances {
 		podIp := instance.NetworkInterfaces[0].NetworkIP
 
-		client, err := common.CreateRealClient(podIp)
+		client, err := common.CreateRealClient(podIp, v.agentPort)
 		if err != nil {
 			return nil, fmt.Errorf("CreatePodSandbox: error in createClient(): %v", err)
 		}
@@ -351,13 +480,17 @@ func (v *gcpPodProvider) ListInstances() ([]*common.PodData, error) {
 
 		vm := &gcpvm.VM{
 			Name:        instance.Name,
-			Zone:        v.config.Zone,
-			Project:     v.config.Project,
-			Scopes:      []string{v.config.Scope},
-			AccountFile: v.config.AuthFile,
+			Zone:        v.getConfig().Zone,
+			Project:     v.getConfig().Project,
+			Scopes:      []string{v.getConfig().Scope},
+			AccountFile: v.getConfig().AuthFile,
 		}
 
-		providerData := &podData{}
+		providerData := &podData{
+			instanceId: &vm.Name,
+			attached:   make(map[string]string),
+			service:    s,
+		}
 
 		v.ipList.FindAndRemove(podIp)
 
@@ -365,12 +498,69 @@ func (v *gcpPodProvider) ListInstances() ([]*common.PodData, error) {
 		booted := true
 		podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
 
+		if instance.Scheduling != nil && instance.Scheduling.Preemptible {
+			v.watchPreempted(podData, instance.Name)
+		}
+
 		podDatas = append(podDatas, podData)
 	}
 
 	return podDatas, nil
 }
 
+func (v *gcpPodProvider) InstanceId(data *common.PodData) string {
+	if vm, ok := data.VM.(*gcpvm.VM); ok {
+		return vm.Name
+	}
+
+	return ""
+}
+
+func (v *gcpPodProvider) GetVMList() ([]string, error) {
+	instances, err := v.service.ListInstances()
+	if err != nil {
+		return nil, fmt.Errorf("GetVMList: %v", err)
+	}
+
+	names := []string{}
+	for _, instance := range instances {
+		names = append(names, instance.Name)
+	}
+
+	return names, nil
+}
+
+func (v *gcpPodProvider) VMName(data *common.PodData) string {
+	return v.InstanceId(data)
+}
+
+// RestorePod mirrors what ListInstances builds for a running instance
+func (v *gcpPodProvider) RestorePod(instanceId string, data *common.PodData) error {
+	conf := v.getConfig()
+
+	if instanceId == "" {
+		return errors.New("RestorePod: no instance name saved")
+	}
+
+	data.VM = &gcpvm.VM{
+		Name:        instanceId,
+		Zone:        conf.Zone,
+		Project:     conf.Project,
+		Scopes:      []string{conf.Scope},
+		AccountFile: conf.AuthFile,
+	}
+	data.ProviderData = &podData{}
+
+	v.ipList.FindAndRemove(data.Ip)
+
+	// what the instance was booted with isn't saved, assume the config hasn't changed since
+	if v.preemptible(data.Annotations) {
+		v.watchPreempted(data, instanceId)
+	}
+
+	return nil
+}
+
 func (p *podData) Attach(vol, device string) (string, error) {
 	glog.Infof("Attach: enter: vol = %v, device = %v", vol, device)
 	p.lock.Lock()
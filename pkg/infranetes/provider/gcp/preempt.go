@@ -0,0 +1,152 @@
+package gcp
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/api/googleapi"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	// how often we check whether any of our preemptible instances were preempted
+	preemptCheckInterval = 30 * time.Second
+
+	preemptibleAnnotation = "infranetes.gcp.preemptible"
+
+	// gce allows 64 labels per instance, 63 characters per key and value
+	maxLabels      = 64
+	maxLabelLength = 63
+)
+
+// preemptible returns whether the pod gets a preemptible instance, the infranetes.gcp.preemptible annotation overrides
+// Preemptible
+func (v *gcpPodProvider) preemptible(annotations map[string]string) bool {
+	switch tmp := annotations[preemptibleAnnotation]; tmp {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "":
+	default:
+		glog.Warningf("preemptible: ignoring invalid %v value %q, must be true or false", preemptibleAnnotation, tmp)
+	}
+
+	return v.getConfig().Preemptible
+}
+
+// labelValue turns s into something gce accepts as a label, lower case letters, digits, _ and - only
+func labelValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, s)
+
+	if len(s) > maxLabelLength {
+		s = s[:maxLabelLength]
+	}
+
+	return s
+}
+
+// instanceLabels are the gce labels put on a pod's instance: its namespace and name, and its own labels as far as they
+// fit.  Keys also have to start with a letter.
+func instanceLabels(config *kubeapi.PodSandboxConfig) map[string]string {
+	labels := map[string]string{
+		"infranetes-namespace": labelValue(config.GetMetadata().GetNamespace()),
+		"infranetes-pod":       labelValue(config.GetMetadata().GetName()),
+	}
+
+	for k, v := range config.GetLabels() {
+		key := labelValue(k)
+		if key == "" || key[0] < 'a' || key[0] > 'z' || strings.HasPrefix(key, "infranetes") {
+			glog.V(2).Infof("instanceLabels: skipping label %v of %v", k, config.GetMetadata().GetName())
+			continue
+		}
+		// one is left for TagNewInstance's infranetes label
+		if len(labels) >= maxLabels-1 {
+			glog.Warningf("instanceLabels: %v has more labels than gce allows, dropping the rest", config.GetMetadata().GetName())
+			break
+		}
+		labels[key] = labelValue(v)
+	}
+
+	return labels
+}
+
+// watchPreempted has checkPreempted keep an eye on a pod running on a preemptible instance
+func (v *gcpPodProvider) watchPreempted(data *common.PodData, name string) {
+	v.preemptLock.Lock()
+	defer v.preemptLock.Unlock()
+
+	v.preemptPods[name] = data
+}
+
+func (v *gcpPodProvider) unwatchPreempted(name string) {
+	v.preemptLock.Lock()
+	defer v.preemptLock.Unlock()
+
+	delete(v.preemptPods, name)
+}
+
+func (v *gcpPodProvider) preemptWatcher() {
+	for range time.Tick(preemptCheckInterval) {
+		v.checkPreempted()
+	}
+}
+
+// checkPreempted marks pods whose preemptible instance was stopped (we never stop them ourselves, so it was preempted)
+// or is gone as not ready, so the kubelet can reschedule them without waiting for the VM to stop answering
+func (v *gcpPodProvider) checkPreempted() {
+	v.preemptLock.Lock()
+	names := []string{}
+	for name := range v.preemptPods {
+		names = append(names, name)
+	}
+	v.preemptLock.Unlock()
+
+	status := make(map[string]string)
+	for _, name := range names {
+		i, err := v.service.Service.Instances.Get(v.getConfig().Project, v.getConfig().Zone, name).Do()
+		if err != nil {
+			if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+				status[name] = "DELETED"
+				continue
+			}
+			glog.Warningf("checkPreempted: couldn't get %v: %v", name, err)
+			continue
+		}
+		if i.Status == "STOPPING" || i.Status == "TERMINATED" {
+			status[name] = i.Status
+		}
+	}
+
+	preempted := make(map[string]*common.PodData)
+	v.preemptLock.Lock()
+	for name := range status {
+		// may have been removed while we were looking
+		if data, ok := v.preemptPods[name]; ok {
+			preempted[name] = data
+			delete(v.preemptPods, name)
+		}
+	}
+	v.preemptLock.Unlock()
+
+	for name, data := range preempted {
+		glog.Warningf("checkPreempted: preemptible instance %v of pod %v was preempted (%v), marking it not ready", name, data.Id, status[name])
+
+		data.Lock()
+		data.PodState = kubeapi.PodSandboxState_SANDBOX_NOTREADY
+		data.Unlock()
+	}
+}
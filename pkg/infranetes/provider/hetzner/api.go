@@ -0,0 +1,323 @@
+/* Minimal client for the parts of the Hetzner Cloud API we need, libretto doesn't support Hetzner */
+
+package hetzner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apcera/libretto/ssh"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+const (
+	apiBase = "https://api.hetzner.cloud/v1"
+
+	// label put on every server we create so ListInstances can find them again
+	infranetesLabel = "infranetes"
+
+	// how long provision waits for a server to be running with a public ip
+	provisionTimeout = 5 * time.Minute
+	pollInterval     = 5 * time.Second
+)
+
+// errNotFound is returned for a 404, i.e. a server that has been deleted
+var errNotFound = errors.New("not found")
+
+type hcloudClient struct {
+	token      string
+	httpClient *http.Client
+}
+
+func newHcloudClient(token string) *hcloudClient {
+	return &hcloudClient{
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type server struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	PublicNet struct {
+		Ipv4 struct {
+			Ip string `json:"ip"`
+		} `json:"ipv4"`
+	} `json:"public_net"`
+	PrivateNet []struct {
+		Ip string `json:"ip"`
+	} `json:"private_net"`
+}
+
+type createServerRequest struct {
+	Name       string            `json:"name"`
+	ServerType string            `json:"server_type"`
+	Image      string            `json:"image"`
+	Location   string            `json:"location"`
+	SshKeys    []string          `json:"ssh_keys,omitempty"`
+	Networks   []int             `json:"networks,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+func (c *hcloudClient) do(method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, apiBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v %v failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%v %v: couldn't read response: %v", method, path, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v %v returned %v: %s", method, path, resp.Status, data)
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%v %v: couldn't parse response: %v", method, path, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *hcloudClient) createServer(req *createServerRequest) (*server, error) {
+	var resp struct {
+		Server server `json:"server"`
+	}
+	if err := c.do("POST", "/servers", req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Server, nil
+}
+
+func (c *hcloudClient) getServer(id int) (*server, error) {
+	var resp struct {
+		Server server `json:"server"`
+	}
+	if err := c.do("GET", "/servers/"+strconv.Itoa(id), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Server, nil
+}
+
+func (c *hcloudClient) deleteServer(id int) error {
+	return c.do("DELETE", "/servers/"+strconv.Itoa(id), nil, nil)
+}
+
+func (c *hcloudClient) serverAction(id int, action string) error {
+	return c.do("POST", "/servers/"+strconv.Itoa(id)+"/actions/"+action, nil, nil)
+}
+
+// listServers returns every server with label, 50 (the most the API returns at once) a page
+func (c *hcloudClient) listServers(label string) ([]server, error) {
+	servers := []server{}
+
+	for page := 1; page != 0; {
+		var resp struct {
+			Servers []server `json:"servers"`
+			Meta    struct {
+				Pagination struct {
+					// null on the last page
+					NextPage int `json:"next_page"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+		path := fmt.Sprintf("/servers?per_page=50&page=%d&label_selector=%v", page, label)
+		if err := c.do("GET", path, nil, &resp); err != nil {
+			return nil, err
+		}
+
+		servers = append(servers, resp.Servers...)
+		page = resp.Meta.Pagination.NextPage
+	}
+
+	return servers, nil
+}
+
+// checkLocation validates the token and the configured location in one go
+func (c *hcloudClient) checkLocation(location string) error {
+	var resp struct {
+		Locations []struct {
+			Name string `json:"name"`
+		} `json:"locations"`
+	}
+	if err := c.do("GET", "/locations?name="+location, nil, &resp); err != nil {
+		return err
+	}
+
+	if len(resp.Locations) == 0 {
+		return fmt.Errorf("unknown location %v", location)
+	}
+
+	return nil
+}
+
+// serverVM implements libretto's VirtualMachine interface on top of hcloudClient so the rest of infranetes can treat it
+// like any other VM
+type serverVM struct {
+	client *hcloudClient
+
+	Id         int
+	Name       string
+	Location   string
+	ServerType string
+	Image      string
+	SshKeys    []string
+	Networks   []int
+}
+
+func (vm *serverVM) GetName() string {
+	return vm.Name
+}
+
+func (vm *serverVM) Provision() error {
+	return vm.provision(context.Background())
+}
+
+// provision creates the server and waits for it to be running and have its public ip.  Leaves vm.Id set if a server
+// was created, even on failure.
+func (vm *serverVM) provision(ctx context.Context) error {
+	req := &createServerRequest{
+		Name:       vm.Name,
+		ServerType: vm.ServerType,
+		Image:      vm.Image,
+		Location:   vm.Location,
+		SshKeys:    vm.SshKeys,
+		Networks:   vm.Networks,
+		Labels:     map[string]string{infranetesLabel: "true"},
+	}
+
+	s, err := vm.client.createServer(req)
+	if err != nil {
+		return fmt.Errorf("Failed to create server: %v", err)
+	}
+	vm.Id = s.Id
+
+	glog.Infof("provision: created server %v (%v) for %v", vm.Id, vm.ServerType, vm.Name)
+
+	for start := time.Now(); time.Since(start) < provisionTimeout; {
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		s, err := vm.client.getServer(vm.Id)
+		if err != nil {
+			return err
+		}
+		if s.Status == "running" && s.PublicNet.Ipv4.Ip != "" {
+			return nil
+		}
+	}
+
+	return lvm.ErrVMBootTimeout
+}
+
+// GetIPs returns the server's public ip first, followed by its ips on any private networks it is attached to
+func (vm *serverVM) GetIPs() ([]net.IP, error) {
+	s, err := vm.client.getServer(vm.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(s.PublicNet.Ipv4.Ip); ip != nil {
+		ips = append(ips, ip)
+	}
+	for _, n := range s.PrivateNet {
+		if ip := net.ParseIP(n.Ip); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, lvm.ErrVMNoIP
+	}
+
+	return ips, nil
+}
+
+// Destroy succeeds for a server that is already gone, as both the manager and RemovePodSandbox can get to it
+func (vm *serverVM) Destroy() error {
+	if err := vm.client.deleteServer(vm.Id); err != nil && err != errNotFound {
+		return err
+	}
+
+	return nil
+}
+
+func (vm *serverVM) GetState() (string, error) {
+	s, err := vm.client.getServer(vm.Id)
+	if err != nil {
+		return lvm.VMUnknown, err
+	}
+
+	switch s.Status {
+	case "initializing", "starting":
+		return lvm.VMStarting, nil
+	case "running":
+		return lvm.VMRunning, nil
+	case "stopping", "off":
+		return lvm.VMHalted, nil
+	}
+
+	return lvm.VMUnknown, nil
+}
+
+func (vm *serverVM) Suspend() error {
+	return lvm.ErrSuspendNotSupported
+}
+
+func (vm *serverVM) Resume() error {
+	return lvm.ErrResumeNotSupported
+}
+
+func (vm *serverVM) Halt() error {
+	return vm.client.serverAction(vm.Id, "shutdown")
+}
+
+func (vm *serverVM) Start() error {
+	return vm.client.serverAction(vm.Id, "poweron")
+}
+
+func (vm *serverVM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	return nil, fmt.Errorf("GetSSH: not supported for hetzner servers")
+}
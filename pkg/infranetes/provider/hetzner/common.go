@@ -0,0 +1,28 @@
+package hetzner
+
+import (
+	icommon "github.com/apporbit/infranetes/pkg/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+)
+
+type hetznerConfig struct {
+	Token string
+	// Location is i.e. fsn1, nbg1 or hel1, ServerType i.e. cx22, Image a name (i.e. ubuntu-22.04) or the id of a
+	// snapshot with the vmserver baked in
+	Location   string
+	ServerType string
+	Image      string
+	// names or ids of ssh keys in the project
+	SshKeys []string
+	// ids of private networks to attach servers to, i.e. to select their address there with IPSelection
+	Networks []int
+
+	Routes []icommon.AddRouteRequest
+
+	// IPSelection picks the server address we dial, and that the pod is given.  Defaults to the public ip.
+	IPSelection common.IPSelector
+
+	// AgentPort is where vmserver listens in the server, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
+}
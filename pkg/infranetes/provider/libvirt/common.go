@@ -0,0 +1,27 @@
+package libvirt
+
+import (
+	icommon "github.com/apporbit/infranetes/pkg/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+)
+
+type libvirtConfig struct {
+	// URI is the libvirt connection, i.e. qemu+ssh://root@kvm1/system
+	URI string
+	// Pool is the storage pool holding BaseVolume, each pod's disk is cloned into it
+	Pool       string
+	BaseVolume string
+	// Network is the libvirt network the pod's NIC is attached to, its DHCP leases are how we find the pod's ip
+	Network string
+
+	CPUs     int
+	MemoryMB int
+
+	Routes []icommon.AddRouteRequest
+
+	IPSelection common.IPSelector
+
+	// AgentPort is where vmserver listens in the domain, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
+}
@@ -0,0 +1,221 @@
+package libvirt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/types"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	// every domain we create is named with this so ListInstances can find them again
+	domainPrefix = "kube-infra-"
+
+	defaultCPUs     = 1
+	defaultMemoryMB = 1024
+)
+
+type podData struct{}
+
+type libvirtPodProvider struct {
+	config *libvirtConfig
+	virsh  *virsh
+}
+
+func init() {
+	provider.PodProviders.RegisterProvider("libvirt", NewLibvirtPodProvider)
+}
+
+func NewLibvirtPodProvider() (provider.PodProvider, error) {
+	var conf libvirtConfig
+
+	file, err := ioutil.ReadFile("libvirt.json")
+	if err != nil {
+		return nil, fmt.Errorf("File error: %v\n", err)
+	}
+
+	if err := json.Unmarshal(file, &conf); err != nil {
+		return nil, fmt.Errorf("couldn't parse libvirt.json: %v", err)
+	}
+
+	if conf.URI == "" || conf.Pool == "" || conf.BaseVolume == "" || conf.Network == "" {
+		msg := fmt.Sprintf("Failed to read in complete config file: conf = %+v", conf)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+
+	if conf.CPUs == 0 {
+		conf.CPUs = defaultCPUs
+	}
+	if conf.MemoryMB == 0 {
+		conf.MemoryMB = defaultMemoryMB
+	}
+
+	if conf.AgentPort == 0 {
+		conf.AgentPort = common.DefaultAgentPort
+	}
+
+	if err := conf.IPSelection.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &libvirtPodProvider{
+		config: &conf,
+		virsh:  &virsh{uri: conf.URI},
+	}
+
+	if err := p.HealthCheck(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (*libvirtPodProvider) UpdatePodState(data *common.PodData) {
+	if data.Booted {
+		data.UpdatePodState()
+	}
+}
+
+// bootConfig is what common.BootSandbox and common.ConnectSandbox need of our config
+func (v *libvirtPodProvider) bootConfig() *common.BootConfig {
+	return &common.BootConfig{
+		IPSelection: v.config.IPSelection,
+		AgentPort:   v.config.AgentPort,
+		Routes:      v.config.Routes,
+	}
+}
+
+func (p *libvirtPodProvider) bootSandbox(vm *domainVM, config *kubeapi.PodSandboxConfig, name string) (*common.PodData, error) {
+	client, podIp, err := common.BootSandbox(vm, func() error {
+		if err := vm.Provision(); err != nil {
+			vm.Destroy()
+			return err
+		}
+		return nil
+	}, config, p.bootConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	providerData := &podData{}
+
+	booted := true
+
+	podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+	return podData, nil
+}
+
+func (v *libvirtPodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+	vm := v.newVM(domainPrefix + req.Config.Metadata.Uid)
+
+	return v.bootSandbox(vm, req.Config, vm.Name)
+}
+
+func (v *libvirtPodProvider) PreCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+	return nil
+}
+
+// StopPodSandbox powers off the domain, it is undefined and its disk deleted when the pod is removed
+func (v *libvirtPodProvider) StopPodSandbox(ctx context.Context, podData *common.PodData) error {
+	if podData.VM == nil {
+		return nil
+	}
+
+	if err := podData.VM.Halt(); err != nil {
+		return fmt.Errorf("StopPodSandbox: couldn't halt %v: %v", podData.VM.GetName(), err)
+	}
+
+	return nil
+}
+
+func (v *libvirtPodProvider) RemovePodSandbox(data *common.PodData) {}
+
+func (v *libvirtPodProvider) PodSandboxStatus(podData *common.PodData) {}
+
+func (v *libvirtPodProvider) HealthCheck() error {
+	if _, err := exec.LookPath("virsh"); err != nil {
+		return fmt.Errorf("HealthCheck: couldn't find virsh: %v", err)
+	}
+
+	if _, err := v.virsh.run("pool-info", v.config.Pool); err != nil {
+		return fmt.Errorf("HealthCheck: %v", err)
+	}
+
+	return nil
+}
+
+// VMExists lets the reconciler drop pods whose domain was undefined behind our back
+func (v *libvirtPodProvider) VMExists(podData *common.PodData) (bool, error) {
+	names, err := v.virsh.listDomains(podData.VM.GetName())
+	if err != nil {
+		return false, fmt.Errorf("VMExists: %v", err)
+	}
+
+	for _, name := range names {
+		if name == podData.VM.GetName() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (v *libvirtPodProvider) ListInstances() ([]*common.PodData, error) {
+	names, err := v.virsh.listDomains(domainPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("ListInstances: %v", err)
+	}
+
+	podDatas := []*common.PodData{}
+	for _, name := range names {
+		vm := v.newVM(name)
+
+		client, podIp, config, err := common.ConnectSandbox(vm, v.bootConfig())
+		if err != nil {
+			glog.Warningf("ListInstances: skipping %v: %v", name, err)
+			continue
+		}
+
+		providerData := &podData{}
+
+		glog.Infof("ListInstances: creating a podData for %v", name)
+		booted := true
+		podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+		podDatas = append(podDatas, podData)
+	}
+
+	return podDatas, nil
+}
+
+func (v *libvirtPodProvider) newVM(name string) *domainVM {
+	return &domainVM{
+		virsh:      v.virsh,
+		Name:       name,
+		Pool:       v.config.Pool,
+		BaseVolume: v.config.BaseVolume,
+		Network:    v.config.Network,
+		CPUs:       v.config.CPUs,
+		MemoryMB:   v.config.MemoryMB,
+	}
+}
+
+func (p *podData) Attach(vol, device string) (string, error) {
+	return "", errors.New("Attach: Not implemented yet")
+}
+
+func (p *podData) NeedMount(vol string) bool {
+	return false
+}
@@ -0,0 +1,291 @@
+/* libvirt domains driven through virsh, as there are no libvirt bindings vendored and libretto doesn't support it */
+
+package libvirt
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/apcera/libretto/ssh"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+const (
+	// how long Provision waits for the domain to get an address
+	provisionTimeout = 5 * time.Minute
+	pollInterval     = 5 * time.Second
+)
+
+type virsh struct {
+	uri string
+}
+
+func (v *virsh) run(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("virsh", append([]string{"-c", v.uri}, args...)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("virsh %v failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// listDomains returns the names of all domains, running or not, that start with prefix
+func (v *virsh) listDomains(prefix string) ([]string, error) {
+	out, err := v.run("list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, name := range strings.Fields(out) {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// domifaddr returns the domain's ipv4 addresses as known to source, "lease" or "agent"
+func (v *virsh) domifaddr(name string, source string) ([]net.IP, error) {
+	out, err := v.run("domifaddr", name, "--source", source)
+	if err != nil {
+		return nil, err
+	}
+
+	// " vnet0      52:54:00:6b:3c:58    ipv4         192.168.122.45/24"
+	ips := []net.IP{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "ipv4" {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(fields[3])
+		if err != nil || ip.IsLoopback() {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+type domainXML struct {
+	XMLName xml.Name `xml:"domain"`
+	Type    string   `xml:"type,attr"`
+	Name    string   `xml:"name"`
+	Memory  struct {
+		Unit  string `xml:"unit,attr"`
+		Value int    `xml:",chardata"`
+	} `xml:"memory"`
+	VCPU int `xml:"vcpu"`
+	OS   struct {
+		Type string `xml:"type"`
+	} `xml:"os"`
+	Devices struct {
+		Disk struct {
+			Type   string `xml:"type,attr"`
+			Device string `xml:"device,attr"`
+			Driver struct {
+				Name string `xml:"name,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"driver"`
+			Source struct {
+				File string `xml:"file,attr"`
+			} `xml:"source"`
+			Target struct {
+				Dev string `xml:"dev,attr"`
+				Bus string `xml:"bus,attr"`
+			} `xml:"target"`
+		} `xml:"disk"`
+		Interface struct {
+			Type   string `xml:"type,attr"`
+			Source struct {
+				Network string `xml:"network,attr"`
+			} `xml:"source"`
+			Model struct {
+				Type string `xml:"type,attr"`
+			} `xml:"model"`
+		} `xml:"interface"`
+		// lets domifaddr fall back to asking qemu-guest-agent when the network has no DHCP
+		Channel struct {
+			Type   string `xml:"type,attr"`
+			Target struct {
+				Type string `xml:"type,attr"`
+				Name string `xml:"name,attr"`
+			} `xml:"target"`
+		} `xml:"channel"`
+		Console struct {
+			Type string `xml:"type,attr"`
+		} `xml:"console"`
+	} `xml:"devices"`
+}
+
+// domainVM implements libretto's VirtualMachine interface for a libvirt domain with a disk cloned from BaseVolume
+type domainVM struct {
+	virsh *virsh
+
+	Name       string
+	Pool       string
+	BaseVolume string
+	Network    string
+	CPUs       int
+	MemoryMB   int
+}
+
+func (vm *domainVM) GetName() string {
+	return vm.Name
+}
+
+func (vm *domainVM) Provision() error {
+	if _, err := vm.virsh.run("vol-clone", "--pool", vm.Pool, vm.BaseVolume, vm.Name); err != nil {
+		return fmt.Errorf("couldn't clone %v: %v", vm.BaseVolume, err)
+	}
+
+	out, err := vm.virsh.run("vol-path", "--pool", vm.Pool, vm.Name)
+	if err != nil {
+		return fmt.Errorf("couldn't find cloned volume: %v", err)
+	}
+
+	def := &domainXML{Type: "kvm", Name: vm.Name, VCPU: vm.CPUs}
+	def.Memory.Unit = "MiB"
+	def.Memory.Value = vm.MemoryMB
+	def.OS.Type = "hvm"
+	def.Devices.Disk.Type = "file"
+	def.Devices.Disk.Device = "disk"
+	def.Devices.Disk.Driver.Name = "qemu"
+	def.Devices.Disk.Driver.Type = "qcow2"
+	def.Devices.Disk.Source.File = strings.TrimSpace(out)
+	def.Devices.Disk.Target.Dev = "vda"
+	def.Devices.Disk.Target.Bus = "virtio"
+	def.Devices.Interface.Type = "network"
+	def.Devices.Interface.Source.Network = vm.Network
+	def.Devices.Interface.Model.Type = "virtio"
+	def.Devices.Channel.Type = "unix"
+	def.Devices.Channel.Target.Type = "virtio"
+	def.Devices.Channel.Target.Name = "org.qemu.guest_agent.0"
+	def.Devices.Console.Type = "pty"
+
+	buf, err := xml.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	// virsh only takes the definition as a file, so hand it /dev/stdin
+	cmd := exec.Command("virsh", "-c", vm.virsh.uri, "define", "/dev/stdin")
+	cmd.Stdin = bytes.NewReader(buf)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("virsh define failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	if err := vm.Start(); err != nil {
+		return err
+	}
+
+	for start := time.Now(); time.Since(start) < provisionTimeout; time.Sleep(pollInterval) {
+		if ips, err := vm.GetIPs(); err == nil && len(ips) > 0 {
+			return nil
+		}
+	}
+
+	return lvm.ErrVMBootTimeout
+}
+
+// GetIPs looks in the network's DHCP leases first and then asks the guest agent
+func (vm *domainVM) GetIPs() ([]net.IP, error) {
+	for _, source := range []string{"lease", "agent"} {
+		if ips, err := vm.virsh.domifaddr(vm.Name, source); err == nil && len(ips) > 0 {
+			return ips, nil
+		}
+	}
+
+	return nil, lvm.ErrVMNoIP
+}
+
+// Destroy removes the domain and its cloned disk
+func (vm *domainVM) Destroy() error {
+	// fails if the domain isn't running, which is fine
+	vm.virsh.run("destroy", vm.Name)
+
+	if _, err := vm.virsh.run("undefine", vm.Name); err != nil {
+		return lvm.WrapErrors(lvm.ErrDeletingVM, err)
+	}
+
+	if _, err := vm.virsh.run("vol-delete", "--pool", vm.Pool, vm.Name); err != nil {
+		return lvm.WrapErrors(lvm.ErrDeletingVM, err)
+	}
+
+	return nil
+}
+
+func (vm *domainVM) GetState() (string, error) {
+	out, err := vm.virsh.run("domstate", vm.Name)
+	if err != nil {
+		return lvm.VMUnknown, lvm.WrapErrors(lvm.ErrVMStateFailed, err)
+	}
+
+	switch strings.TrimSpace(out) {
+	case "running", "idle":
+		return lvm.VMRunning, nil
+	case "shut off", "shutdown", "crashed":
+		return lvm.VMHalted, nil
+	case "paused", "pmsuspended":
+		return lvm.VMSuspended, nil
+	}
+
+	return lvm.VMUnknown, nil
+}
+
+func (vm *domainVM) Suspend() error {
+	if _, err := vm.virsh.run("suspend", vm.Name); err != nil {
+		return lvm.WrapErrors(lvm.ErrSuspendingVM, err)
+	}
+
+	return nil
+}
+
+func (vm *domainVM) Resume() error {
+	if _, err := vm.virsh.run("resume", vm.Name); err != nil {
+		return lvm.WrapErrors(lvm.ErrResumingVM, err)
+	}
+
+	return nil
+}
+
+// Halt powers off the domain without destroying it
+func (vm *domainVM) Halt() error {
+	state, err := vm.GetState()
+	if err != nil {
+		return err
+	}
+	if state == lvm.VMHalted {
+		return nil
+	}
+
+	if _, err := vm.virsh.run("destroy", vm.Name); err != nil {
+		return lvm.WrapErrors(lvm.ErrStoppingVM, err)
+	}
+
+	return nil
+}
+
+func (vm *domainVM) Start() error {
+	if _, err := vm.virsh.run("start", vm.Name); err != nil {
+		return lvm.WrapErrors(lvm.ErrStartingVM, err)
+	}
+
+	return nil
+}
+
+func (vm *domainVM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	return nil, fmt.Errorf("GetSSH: not supported for libvirt domains")
+}
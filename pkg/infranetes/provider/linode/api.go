@@ -0,0 +1,323 @@
+/* Minimal client for the parts of the Linode v4 API we need, libretto doesn't support Linode */
+
+package linode
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apcera/libretto/ssh"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+const (
+	apiBase = "https://api.linode.com/v4"
+
+	// tag put on every instance we create so ListInstances can find them again
+	infranetesTag = "infranetes"
+
+	// how long provision waits for an instance to be running with an ipv4 address
+	provisionTimeout = 5 * time.Minute
+	pollInterval     = 5 * time.Second
+)
+
+// errNotFound is returned for a 404, i.e. an instance that has been deleted
+var errNotFound = errors.New("not found")
+
+// linodes hand out private addresses from 192.168.128.0/17
+var privateNet = &net.IPNet{IP: net.IPv4(192, 168, 128, 0), Mask: net.CIDRMask(17, 32)}
+
+type linodeClient struct {
+	token      string
+	httpClient *http.Client
+}
+
+func newLinodeClient(token string) *linodeClient {
+	return &linodeClient{
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type instance struct {
+	Id     int      `json:"id"`
+	Label  string   `json:"label"`
+	Status string   `json:"status"`
+	Ipv4   []string `json:"ipv4"`
+}
+
+type createInstanceRequest struct {
+	Label          string   `json:"label"`
+	Region         string   `json:"region"`
+	Type           string   `json:"type"`
+	Image          string   `json:"image"`
+	RootPass       string   `json:"root_pass"`
+	AuthorizedKeys []string `json:"authorized_keys,omitempty"`
+	PrivateIp      bool     `json:"private_ip,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+}
+
+func (c *linodeClient) do(method, path string, header map[string]string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, apiBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v %v failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%v %v: couldn't read response: %v", method, path, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v %v returned %v: %s", method, path, resp.Status, data)
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%v %v: couldn't parse response: %v", method, path, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *linodeClient) createInstance(req *createInstanceRequest) (*instance, error) {
+	var i instance
+	if err := c.do("POST", "/linode/instances", nil, req, &i); err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+func (c *linodeClient) getInstance(id int) (*instance, error) {
+	var i instance
+	if err := c.do("GET", "/linode/instances/"+strconv.Itoa(id), nil, nil, &i); err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+func (c *linodeClient) deleteInstance(id int) error {
+	return c.do("DELETE", "/linode/instances/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+func (c *linodeClient) instanceAction(id int, action string) error {
+	return c.do("POST", "/linode/instances/"+strconv.Itoa(id)+"/"+action, nil, struct{}{}, nil)
+}
+
+// listInstances returns every instance with tag, 500 (the most the API returns at once) a page
+func (c *linodeClient) listInstances(tag string) ([]instance, error) {
+	instances := []instance{}
+	filter := map[string]string{"X-Filter": fmt.Sprintf(`{"tags": %q}`, tag)}
+
+	for page, pages := 1, 1; page <= pages; page++ {
+		var resp struct {
+			Data  []instance `json:"data"`
+			Pages int        `json:"pages"`
+		}
+		if err := c.do("GET", fmt.Sprintf("/linode/instances?page_size=500&page=%d", page), filter, nil, &resp); err != nil {
+			return nil, err
+		}
+
+		instances = append(instances, resp.Data...)
+		pages = resp.Pages
+	}
+
+	return instances, nil
+}
+
+func (c *linodeClient) getProfile() error {
+	return c.do("GET", "/profile", nil, nil, nil)
+}
+
+// rootPass is a random root password, linode requires one to deploy an image.  Nobody logs in with it, access is through
+// AuthorizedKeys if at all.
+func rootPass() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// linodeVM implements libretto's VirtualMachine interface on top of linodeClient so the rest of infranetes can treat it
+// like any other VM
+type linodeVM struct {
+	client *linodeClient
+
+	Id             int
+	Name           string
+	Region         string
+	Type           string
+	Image          string
+	AuthorizedKeys []string
+	PrivateIp      bool
+}
+
+func (vm *linodeVM) GetName() string {
+	return vm.Name
+}
+
+func (vm *linodeVM) Provision() error {
+	return vm.provision(context.Background())
+}
+
+// provision creates the instance and waits for it to be running and have an ipv4 address.  Leaves vm.Id set if an
+// instance was created, even on failure.
+func (vm *linodeVM) provision(ctx context.Context) error {
+	pass, err := rootPass()
+	if err != nil {
+		return fmt.Errorf("couldn't generate a root password: %v", err)
+	}
+
+	req := &createInstanceRequest{
+		Label:          vm.Name,
+		Region:         vm.Region,
+		Type:           vm.Type,
+		Image:          vm.Image,
+		RootPass:       pass,
+		AuthorizedKeys: vm.AuthorizedKeys,
+		PrivateIp:      vm.PrivateIp,
+		Tags:           []string{infranetesTag},
+	}
+
+	i, err := vm.client.createInstance(req)
+	if err != nil {
+		return fmt.Errorf("Failed to create instance: %v", err)
+	}
+	vm.Id = i.Id
+
+	glog.Infof("provision: created instance %v (%v) for %v", vm.Id, vm.Type, vm.Name)
+
+	for start := time.Now(); time.Since(start) < provisionTimeout; {
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		i, err := vm.client.getInstance(vm.Id)
+		if err != nil {
+			return err
+		}
+		if i.Status == "running" && len(i.Ipv4) > 0 {
+			return nil
+		}
+	}
+
+	return lvm.ErrVMBootTimeout
+}
+
+// GetIPs returns the instance's public ipv4 address first, followed by its private one if it has one
+func (vm *linodeVM) GetIPs() ([]net.IP, error) {
+	i, err := vm.client.getInstance(vm.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var public, private []net.IP
+	for _, a := range i.Ipv4 {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		if privateNet.Contains(ip) {
+			private = append(private, ip)
+		} else {
+			public = append(public, ip)
+		}
+	}
+
+	ips := append(public, private...)
+	if len(ips) == 0 {
+		return nil, lvm.ErrVMNoIP
+	}
+
+	return ips, nil
+}
+
+// Destroy succeeds for an instance that is already gone, as both the manager and RemovePodSandbox can get to it
+func (vm *linodeVM) Destroy() error {
+	if err := vm.client.deleteInstance(vm.Id); err != nil && err != errNotFound {
+		return err
+	}
+
+	return nil
+}
+
+func (vm *linodeVM) GetState() (string, error) {
+	i, err := vm.client.getInstance(vm.Id)
+	if err != nil {
+		return lvm.VMUnknown, err
+	}
+
+	switch i.Status {
+	case "provisioning", "booting", "rebooting":
+		return lvm.VMStarting, nil
+	case "running":
+		return lvm.VMRunning, nil
+	case "offline", "shutting_down", "stopped":
+		return lvm.VMHalted, nil
+	}
+
+	return lvm.VMUnknown, nil
+}
+
+func (vm *linodeVM) Suspend() error {
+	return lvm.ErrSuspendNotSupported
+}
+
+func (vm *linodeVM) Resume() error {
+	return lvm.ErrResumeNotSupported
+}
+
+func (vm *linodeVM) Halt() error {
+	return vm.client.instanceAction(vm.Id, "shutdown")
+}
+
+func (vm *linodeVM) Start() error {
+	return vm.client.instanceAction(vm.Id, "boot")
+}
+
+func (vm *linodeVM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	return nil, fmt.Errorf("GetSSH: not supported for linodes")
+}
@@ -0,0 +1,28 @@
+package linode
+
+import (
+	icommon "github.com/apporbit/infranetes/pkg/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+)
+
+type linodeConfig struct {
+	Token  string
+	Region string
+	// Type is the instance type (i.e. g6-standard-2), Image the image deployed on it (i.e. linode/ubuntu22.04 or a
+	// private/123 image with the vmserver baked in)
+	Type           string
+	Image          string
+	AuthorizedKeys []string
+
+	// PrivateIp gives each instance a private address too, i.e. to select it with IPSelection
+	PrivateIp bool
+
+	Routes []icommon.AddRouteRequest
+
+	// IPSelection picks the instance address we dial, and that the pod is given.  Defaults to the public ip.
+	IPSelection common.IPSelector
+
+	// AgentPort is where vmserver listens in the instance, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
+}
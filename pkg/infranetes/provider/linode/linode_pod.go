@@ -0,0 +1,272 @@
+package linode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/types"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	maxLabelLen = 64
+)
+
+type podData struct {
+	instanceId int
+	lock       sync.Mutex
+	// the instance was deleted by RemovePodSandbox
+	removed bool
+}
+
+type linodePodProvider struct {
+	config *linodeConfig
+	client *linodeClient
+}
+
+func init() {
+	provider.PodProviders.RegisterProvider("linode", NewLinodePodProvider)
+}
+
+func NewLinodePodProvider() (provider.PodProvider, error) {
+	var conf linodeConfig
+
+	file, err := ioutil.ReadFile("linode.json")
+	if err != nil {
+		return nil, fmt.Errorf("File error: %v\n", err)
+	}
+
+	if err := json.Unmarshal(file, &conf); err != nil {
+		return nil, fmt.Errorf("couldn't parse linode.json: %v", err)
+	}
+
+	if conf.Token == "" || conf.Region == "" || conf.Type == "" || conf.Image == "" {
+		msg := fmt.Sprintf("Failed to read in complete config file: conf = %+v", conf)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+
+	if conf.AgentPort == 0 {
+		conf.AgentPort = common.DefaultAgentPort
+	}
+	if conf.IPSelection.Prefer == "" && conf.IPSelection.CIDR == "" {
+		conf.IPSelection.Prefer = "public"
+	}
+	if err := conf.IPSelection.Validate(); err != nil {
+		return nil, err
+	}
+
+	client := newLinodeClient(conf.Token)
+
+	glog.Infof("Validating Linode Credentials")
+	if err := client.getProfile(); err != nil {
+		msg := fmt.Sprintf("Failed to validate Linode Credentials: %v", err)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+	glog.Infof("Validated Credentials")
+
+	return &linodePodProvider{
+		config: &conf,
+		client: client,
+	}, nil
+}
+
+func (*linodePodProvider) UpdatePodState(data *common.PodData) {
+	if data.Booted {
+		data.UpdatePodState()
+	}
+}
+
+// bootConfig is what common.BootSandbox and common.ConnectSandbox need of our config
+func (v *linodePodProvider) bootConfig() *common.BootConfig {
+	return &common.BootConfig{
+		IPSelection: v.config.IPSelection,
+		AgentPort:   v.config.AgentPort,
+		Routes:      v.config.Routes,
+	}
+}
+
+func (p *linodePodProvider) bootSandbox(ctx context.Context, vm *linodeVM, config *kubeapi.PodSandboxConfig, name string) (*common.PodData, error) {
+	client, podIp, err := common.BootSandbox(vm, func() error {
+		if err := vm.provision(ctx); err != nil {
+			if vm.Id != 0 {
+				vm.Destroy()
+			}
+			return err
+		}
+		return nil
+	}, config, p.bootConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	providerData := &podData{instanceId: vm.Id}
+
+	booted := true
+
+	podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+	return podData, nil
+}
+
+func (v *linodePodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+	vm := v.newVM(linodeLabel(req.Config.Metadata))
+
+	return v.bootSandbox(ctx, vm, req.Config, vm.Name)
+}
+
+// linodeLabel is common.VMName without the repeated dashes linode doesn't allow in labels
+func linodeLabel(meta *kubeapi.PodSandboxMetadata) string {
+	label := common.VMName(meta, maxLabelLen)
+	for strings.Contains(label, "--") {
+		label = strings.Replace(label, "--", "-", -1)
+	}
+
+	return label
+}
+
+func (v *linodePodProvider) PreCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+	return nil
+}
+
+func (v *linodePodProvider) StopPodSandbox(ctx context.Context, podData *common.PodData) error {
+	return nil
+}
+
+// RemovePodSandbox makes sure the instance is gone, the manager only destroys the VM of booted pods and we don't want
+// to keep paying for one that is left behind
+func (v *linodePodProvider) RemovePodSandbox(data *common.PodData) {
+	providerData, ok := data.ProviderData.(*podData)
+	if !ok {
+		return
+	}
+
+	providerData.lock.Lock()
+	defer providerData.lock.Unlock()
+
+	if providerData.removed {
+		return
+	}
+
+	if err := v.client.deleteInstance(providerData.instanceId); err != nil && err != errNotFound {
+		glog.Warningf("RemovePodSandbox: couldn't delete instance %v of %v: %v", providerData.instanceId, data.Id, err)
+		return
+	}
+
+	providerData.removed = true
+}
+
+func (v *linodePodProvider) PodSandboxStatus(podData *common.PodData) {}
+
+func (v *linodePodProvider) HealthCheck() error {
+	if err := v.client.getProfile(); err != nil {
+		return fmt.Errorf("HealthCheck: %v", err)
+	}
+
+	return nil
+}
+
+// VMExists lets the reconciler drop pods whose instance was deleted behind our back
+func (v *linodePodProvider) VMExists(podData *common.PodData) (bool, error) {
+	vm, ok := podData.VM.(*linodeVM)
+	if !ok {
+		return true, nil
+	}
+
+	if _, err := v.client.getInstance(vm.Id); err == errNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("VMExists: %v", err)
+	}
+
+	return true, nil
+}
+
+func (v *linodePodProvider) ListInstances() ([]*common.PodData, error) {
+	instances, err := v.client.listInstances(infranetesTag)
+	if err != nil {
+		return nil, fmt.Errorf("ListInstances: %v", err)
+	}
+
+	podDatas := []*common.PodData{}
+	for _, i := range instances {
+		if i.Status != "running" {
+			glog.Infof("ListInstances: skipping %v as it is %v", i.Label, i.Status)
+			continue
+		}
+
+		vm := v.newVM(i.Label)
+		vm.Id = i.Id
+
+		client, podIp, config, err := common.ConnectSandbox(vm, v.bootConfig())
+		if err != nil {
+			glog.Warningf("ListInstances: skipping %v: %v", i.Label, err)
+			continue
+		}
+
+		name := i.Label
+
+		providerData := &podData{instanceId: i.Id}
+
+		glog.Infof("ListInstances: creating a podData for %v", name)
+		booted := true
+		podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+		podDatas = append(podDatas, podData)
+	}
+
+	return podDatas, nil
+}
+
+func (v *linodePodProvider) GetVMList() ([]string, error) {
+	instances, err := v.client.listInstances(infranetesTag)
+	if err != nil {
+		return nil, fmt.Errorf("GetVMList: %v", err)
+	}
+
+	names := []string{}
+	for _, i := range instances {
+		names = append(names, i.Label)
+	}
+
+	return names, nil
+}
+
+func (v *linodePodProvider) VMName(podData *common.PodData) string {
+	if podData.VM == nil {
+		return ""
+	}
+
+	return podData.VM.GetName()
+}
+
+func (v *linodePodProvider) newVM(name string) *linodeVM {
+	return &linodeVM{
+		client:         v.client,
+		Name:           name,
+		Region:         v.config.Region,
+		Type:           v.config.Type,
+		Image:          v.config.Image,
+		AuthorizedKeys: v.config.AuthorizedKeys,
+		PrivateIp:      v.config.PrivateIp,
+	}
+}
+
+func (p *podData) Attach(vol, device string) (string, error) {
+	return "", errors.New("Attach: Not implemented yet")
+}
+
+func (p *podData) NeedMount(vol string) bool {
+	return false
+}
@@ -2,6 +2,9 @@ package provider
 
 import (
 	"fmt"
+	"sort"
+
+	"golang.org/x/net/context"
 
 	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
 	"github.com/apporbit/infranetes/pkg/infranetes/types"
@@ -10,12 +13,107 @@ import (
 )
 
 type PodProvider interface {
-	RunPodSandbox(req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error)
-	StopPodSandbox(podData *common.PodData)
+	// ctx is the kubelet's request context, providers should give up on slow cloud operations when it is done
+	RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error)
+	StopPodSandbox(ctx context.Context, podData *common.PodData) error
 	RemovePodSandbox(podData *common.PodData)
 	PodSandboxStatus(podData *common.PodData)
-	PreCreateContainer(*common.PodData, *kubeapi.CreateContainerRequest, func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error
+	PreCreateContainer(context.Context, *common.PodData, *kubeapi.CreateContainerRequest, func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error
 	ListInstances() ([]*common.PodData, error)
+	// HealthCheck returns an error if the provider can't reach the infrastructure (i.e. cloud API) backing it
+	HealthCheck() error
+}
+
+// PodRestorer is implemented by pod providers that can rebuild a pod's VM from the instance id saved in the manager's
+// state file
+type PodRestorer interface {
+	InstanceId(podData *common.PodData) string
+	// RestorePod fills in the VM and ProviderData of podData, everything else has been restored by the manager
+	RestorePod(instanceId string, podData *common.PodData) error
+}
+
+// PodResumer is implemented by pod providers that can keep a stopped pod's VM (i.e. a stopped EC2 instance) and start it
+// back up for the pod's next sandbox instead of booting a new VM
+type PodResumer interface {
+	// ResumePodSandbox starts stopped's VM again and turns stopped, in place, into the sandbox for req, a later attempt
+	// of the same pod.  Returns false if stopped's VM can't be resumed, a new sandbox is created instead.
+	ResumePodSandbox(ctx context.Context, stopped *common.PodData, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (bool, error)
+}
+
+// Shutdowner is implemented by pod providers that hold on to cloud resources outside of any pod (i.e. a pool of idle
+// VMs) which need to be released when infranetes exits
+type Shutdowner interface {
+	Shutdown()
+}
+
+// ConfigReloader is implemented by pod providers that can re-read their config file without a restart, on SIGHUP.  The
+// new config applies to sandboxes created after it, running pods are left as they are.
+type ConfigReloader interface {
+	ReloadConfig() error
+}
+
+// PodCIDRUpdater is implemented by pod providers that need the node's pod CIDR (i.e. to set up routes to pod ips).  It is
+// called whenever the kubelet hands us a new one.
+type PodCIDRUpdater interface {
+	UpdatePodCIDR(cidr string) error
+}
+
+// VMChecker is implemented by pod providers that can tell a VM that was deleted out from under us (i.e. terminated in
+// the cloud console) from one that is merely unreachable, so the reconciler can drop its pod
+type VMChecker interface {
+	VMExists(podData *common.PodData) (bool, error)
+}
+
+// VMLister is implemented by pod providers that can list their VMs in the cloud without connecting to them, so the
+// debug endpoint can show VMs no pod is using (i.e. leaked by a crash)
+type VMLister interface {
+	// GetVMList returns the names (or ids) of the VMs the provider created
+	GetVMList() ([]string, error)
+	// VMName is what GetVMList calls the pod's VM, "" if it has none
+	VMName(podData *common.PodData) string
+}
+
+// OrphanRemover is implemented by pod providers that can find a sandbox's VM in the cloud from the sandbox id alone, so
+// removing a sandbox the manager doesn't know (i.e. RunPodSandbox failed after the VM was created) doesn't leak the VM
+type OrphanRemover interface {
+	// RemoveOrphan destroys the VM of sandbox id if there is one, returning false if there isn't
+	RemoveOrphan(id string) (bool, error)
+}
+
+// DestroyErrorExplainer is implemented by pod providers whose VMs can refuse to be destroyed on purpose (i.e. EC2
+// termination protection), to turn that error into one saying what needs to be done
+type DestroyErrorExplainer interface {
+	ExplainDestroyError(podData *common.PodData, err error) error
+}
+
+// Capabilities are the optional operations a pod provider's pods support.  StopPodSandbox isn't one of them, the kubelet
+// won't remove a pod it couldn't stop, so the manager always stops a pod's containers.
+type Capabilities struct {
+	ExecSync    bool
+	Exec        bool
+	Attach      bool
+	PortForward bool
+}
+
+// AllCapabilities is what pod providers that don't implement CapabilityReporter are assumed to support
+var AllCapabilities = Capabilities{
+	ExecSync:    true,
+	Exec:        true,
+	Attach:      true,
+	PortForward: true,
+}
+
+// CapabilityReporter is implemented by pod providers whose pods can't do everything, so the manager fails those requests
+// with codes.Unimplemented instead of however the VM happens to fail them
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// RegistryAuther is implemented by image providers whose images are pulled by docker inside the VM, so the credentials
+// the kubelet pulled an image with can be handed to vmserver when creating a container from it
+type RegistryAuther interface {
+	// RegistryAuth returns the encoded credentials image was pulled with, "" if none were needed
+	RegistryAuth(image string) string
 }
 
 type ImageProvider interface {
@@ -23,6 +121,7 @@ type ImageProvider interface {
 	ImageStatus(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)
 	PullImage(req *kubeapi.PullImageRequest) (*kubeapi.PullImageResponse, error)
 	RemoveImage(req *kubeapi.RemoveImageRequest) (*kubeapi.RemoveImageResponse, error)
+	ImageFsInfo(req *kubeapi.ImageFsInfoRequest) (*kubeapi.ImageFsInfoResponse, error)
 
 	Translate(spec *kubeapi.ImageSpec) (string, error)
 
@@ -67,6 +166,28 @@ func (c imgProviderRegistry) RegisterProvider(name string, provider func() (Imag
 	return nil
 }
 
+// List returns the names of the registered pod providers, sorted
+func (p podProviderRegistry) List() []string {
+	names := []string{}
+	for name := range p.podProviderMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// List returns the names of the registered image providers, sorted
+func (c imgProviderRegistry) List() []string {
+	names := []string{}
+	for name := range c.imgProviderMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 func (p podProviderRegistry) findProvider(name string) (func() (PodProvider, error), error) {
 	if provider, ok := p.podProviderMap[name]; ok == true {
 		return provider, nil
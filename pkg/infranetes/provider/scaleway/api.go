@@ -0,0 +1,339 @@
+/* Minimal client for the parts of the Scaleway Instance API we need, libretto doesn't support Scaleway */
+
+package scaleway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apcera/libretto/ssh"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+const (
+	apiBase = "https://api.scaleway.com/instance/v1/zones/"
+
+	// tag put on every server we create so ListInstances can find them again
+	infranetesTag = "infranetes"
+
+	// how long provision waits for a server to be running with a public ip
+	provisionTimeout = 5 * time.Minute
+	pollInterval     = 5 * time.Second
+)
+
+// errNotFound is returned for a 404, i.e. a server that has been deleted
+var errNotFound = errors.New("not found")
+
+type scwClient struct {
+	secretKey  string
+	zone       string
+	httpClient *http.Client
+}
+
+func newScwClient(secretKey, zone string) *scwClient {
+	return &scwClient{
+		secretKey:  secretKey,
+		zone:       zone,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type volume struct {
+	Id string `json:"id"`
+}
+
+type server struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	PublicIp *struct {
+		Address string `json:"address"`
+	} `json:"public_ip"`
+	PrivateIp *string `json:"private_ip"`
+	// keyed by their index on the server, "0" is the root volume
+	Volumes map[string]volume `json:"volumes"`
+}
+
+type createServerRequest struct {
+	Name              string   `json:"name"`
+	CommercialType    string   `json:"commercial_type"`
+	Image             string   `json:"image"`
+	Project           string   `json:"project,omitempty"`
+	Organization      string   `json:"organization,omitempty"`
+	Tags              []string `json:"tags,omitempty"`
+	DynamicIpRequired bool     `json:"dynamic_ip_required"`
+}
+
+func (c *scwClient) do(method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, apiBase+c.zone+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", c.secretKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v %v failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%v %v: couldn't read response: %v", method, path, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v %v returned %v: %s", method, path, resp.Status, data)
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%v %v: couldn't parse response: %v", method, path, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *scwClient) createServer(req *createServerRequest) (*server, error) {
+	var resp struct {
+		Server server `json:"server"`
+	}
+	if err := c.do("POST", "/servers", req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Server, nil
+}
+
+func (c *scwClient) getServer(id string) (*server, error) {
+	var resp struct {
+		Server server `json:"server"`
+	}
+	if err := c.do("GET", "/servers/"+id, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Server, nil
+}
+
+func (c *scwClient) deleteServer(id string) error {
+	return c.do("DELETE", "/servers/"+id, nil, nil)
+}
+
+func (c *scwClient) deleteVolume(id string) error {
+	return c.do("DELETE", "/volumes/"+id, nil, nil)
+}
+
+func (c *scwClient) serverAction(id string, action string) error {
+	return c.do("POST", "/servers/"+id+"/action", map[string]string{"action": action}, nil)
+}
+
+func (c *scwClient) listServers(tag string) ([]server, error) {
+	var resp struct {
+		Servers []server `json:"servers"`
+	}
+	if err := c.do("GET", "/servers?per_page=100&tags="+tag, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Servers, nil
+}
+
+// checkAccess validates the secret key, and that it can see the project or organization owner filters on (i.e.
+// "project=<id>"), in one go
+func (c *scwClient) checkAccess(owner string) error {
+	var resp struct {
+		Servers []server `json:"servers"`
+	}
+
+	return c.do("GET", "/servers?per_page=1&"+owner, nil, &resp)
+}
+
+// destroyServer deletes the server and its volumes, which Scaleway would otherwise keep (and bill for).  Succeeds for a
+// server that is already gone.
+func (c *scwClient) destroyServer(id string) error {
+	s, err := c.getServer(id)
+	if err == errNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// terminate stops the server and deletes it along with its volumes, but can only be done to a running one
+	if s.State == "running" {
+		if err := c.serverAction(id, "terminate"); err != nil && err != errNotFound {
+			return err
+		}
+		return nil
+	}
+
+	if err := c.deleteServer(id); err != nil && err != errNotFound {
+		return err
+	}
+	for _, v := range s.Volumes {
+		if err := c.deleteVolume(v.Id); err != nil && err != errNotFound {
+			return fmt.Errorf("deleted server %v but not its volume %v: %v", id, v.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// serverVM implements libretto's VirtualMachine interface on top of scwClient so the rest of infranetes can treat it
+// like any other VM
+type serverVM struct {
+	client *scwClient
+
+	Id             string
+	Name           string
+	CommercialType string
+	Image          string
+	Project        string
+	Organization   string
+}
+
+func (vm *serverVM) GetName() string {
+	return vm.Name
+}
+
+func (vm *serverVM) Provision() error {
+	return vm.provision(context.Background())
+}
+
+// provision creates the server, powers it on and waits for it to be running and have its public ip.  Leaves vm.Id set
+// if a server was created, even on failure.
+func (vm *serverVM) provision(ctx context.Context) error {
+	req := &createServerRequest{
+		Name:              vm.Name,
+		CommercialType:    vm.CommercialType,
+		Image:             vm.Image,
+		Project:           vm.Project,
+		Organization:      vm.Organization,
+		Tags:              []string{infranetesTag},
+		DynamicIpRequired: true,
+	}
+
+	s, err := vm.client.createServer(req)
+	if err != nil {
+		return fmt.Errorf("Failed to create server: %v", err)
+	}
+	vm.Id = s.Id
+
+	glog.Infof("provision: created server %v (%v) for %v", vm.Id, vm.CommercialType, vm.Name)
+
+	// unlike other clouds, servers are created stopped
+	if err := vm.client.serverAction(vm.Id, "poweron"); err != nil {
+		return fmt.Errorf("Failed to power on server: %v", err)
+	}
+
+	for start := time.Now(); time.Since(start) < provisionTimeout; {
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		s, err := vm.client.getServer(vm.Id)
+		if err != nil {
+			return err
+		}
+		if s.State == "running" && s.PublicIp != nil && s.PublicIp.Address != "" {
+			return nil
+		}
+	}
+
+	return lvm.ErrVMBootTimeout
+}
+
+// GetIPs returns the server's public ip first, followed by its private one if it has one
+func (vm *serverVM) GetIPs() ([]net.IP, error) {
+	s, err := vm.client.getServer(vm.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	if s.PublicIp != nil {
+		if ip := net.ParseIP(s.PublicIp.Address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if s.PrivateIp != nil {
+		if ip := net.ParseIP(*s.PrivateIp); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, lvm.ErrVMNoIP
+	}
+
+	return ips, nil
+}
+
+// Destroy succeeds for a server that is already gone, as both the manager and RemovePodSandbox can get to it
+func (vm *serverVM) Destroy() error {
+	return vm.client.destroyServer(vm.Id)
+}
+
+func (vm *serverVM) GetState() (string, error) {
+	s, err := vm.client.getServer(vm.Id)
+	if err != nil {
+		return lvm.VMUnknown, err
+	}
+
+	switch s.State {
+	case "starting":
+		return lvm.VMStarting, nil
+	case "running":
+		return lvm.VMRunning, nil
+	case "stopping", "stopped", "stopped in place":
+		return lvm.VMHalted, nil
+	}
+
+	return lvm.VMUnknown, nil
+}
+
+func (vm *serverVM) Suspend() error {
+	return lvm.ErrSuspendNotSupported
+}
+
+func (vm *serverVM) Resume() error {
+	return lvm.ErrResumeNotSupported
+}
+
+func (vm *serverVM) Halt() error {
+	return vm.client.serverAction(vm.Id, "poweroff")
+}
+
+func (vm *serverVM) Start() error {
+	return vm.client.serverAction(vm.Id, "poweron")
+}
+
+func (vm *serverVM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	return nil, fmt.Errorf("GetSSH: not supported for scaleway servers")
+}
@@ -0,0 +1,37 @@
+package scaleway
+
+import (
+	icommon "github.com/apporbit/infranetes/pkg/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+)
+
+type scalewayConfig struct {
+	// servers are created in ProjectId, or in the default project of OrganizationId if it isn't set
+	ProjectId      string
+	OrganizationId string
+	// AccessKey is only logged, to tell which key is in use, the API only needs SecretKey
+	AccessKey string
+	SecretKey string
+	// Zone is i.e. fr-par-1 or nl-ams-1, CommercialType i.e. DEV1-S, Image the id of an image with the vmserver baked in
+	Zone           string
+	CommercialType string
+	Image          string
+
+	Routes []icommon.AddRouteRequest
+
+	// IPSelection picks the server address we dial, and that the pod is given.  Defaults to the public ip.
+	IPSelection common.IPSelector
+
+	// AgentPort is where vmserver listens in the server, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
+}
+
+// owner is the filter for the servers of the configured project, or organization
+func (c *scalewayConfig) owner() string {
+	if c.ProjectId != "" {
+		return "project=" + c.ProjectId
+	}
+
+	return "organization=" + c.OrganizationId
+}
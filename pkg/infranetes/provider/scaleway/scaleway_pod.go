@@ -0,0 +1,260 @@
+package scaleway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/types"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	maxServerNameLen = 63
+)
+
+type podData struct {
+	serverId string
+	lock     sync.Mutex
+	// the server was deleted by RemovePodSandbox
+	removed bool
+}
+
+type scalewayPodProvider struct {
+	config *scalewayConfig
+	client *scwClient
+}
+
+func init() {
+	provider.PodProviders.RegisterProvider("scaleway", NewScalewayPodProvider)
+}
+
+func NewScalewayPodProvider() (provider.PodProvider, error) {
+	var conf scalewayConfig
+
+	file, err := ioutil.ReadFile("scaleway.json")
+	if err != nil {
+		return nil, fmt.Errorf("File error: %v\n", err)
+	}
+
+	if err := json.Unmarshal(file, &conf); err != nil {
+		return nil, fmt.Errorf("couldn't parse scaleway.json: %v", err)
+	}
+
+	if conf.SecretKey == "" || (conf.ProjectId == "" && conf.OrganizationId == "") || conf.Zone == "" || conf.CommercialType == "" || conf.Image == "" {
+		msg := fmt.Sprintf("Failed to read in complete config file: conf = %+v", conf)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+
+	if conf.AgentPort == 0 {
+		conf.AgentPort = common.DefaultAgentPort
+	}
+	if conf.IPSelection.Prefer == "" && conf.IPSelection.CIDR == "" {
+		conf.IPSelection.Prefer = "public"
+	}
+	if err := conf.IPSelection.Validate(); err != nil {
+		return nil, err
+	}
+
+	client := newScwClient(conf.SecretKey, conf.Zone)
+
+	glog.Infof("Validating Scaleway Credentials (access key %v)", conf.AccessKey)
+	if err := client.checkAccess(conf.owner()); err != nil {
+		msg := fmt.Sprintf("Failed to validate Scaleway Credentials: %v", err)
+		glog.Info(msg)
+		return nil, errors.New(msg)
+	}
+	glog.Infof("Validated Credentials")
+
+	return &scalewayPodProvider{
+		config: &conf,
+		client: client,
+	}, nil
+}
+
+func (*scalewayPodProvider) UpdatePodState(data *common.PodData) {
+	if data.Booted {
+		data.UpdatePodState()
+	}
+}
+
+// bootConfig is what common.BootSandbox and common.ConnectSandbox need of our config
+func (v *scalewayPodProvider) bootConfig() *common.BootConfig {
+	return &common.BootConfig{
+		IPSelection: v.config.IPSelection,
+		AgentPort:   v.config.AgentPort,
+		Routes:      v.config.Routes,
+	}
+}
+
+func (p *scalewayPodProvider) bootSandbox(ctx context.Context, vm *serverVM, config *kubeapi.PodSandboxConfig, name string) (*common.PodData, error) {
+	client, podIp, err := common.BootSandbox(vm, func() error {
+		if err := vm.provision(ctx); err != nil {
+			if vm.Id != "" {
+				vm.Destroy()
+			}
+			return err
+		}
+		return nil
+	}, config, p.bootConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	providerData := &podData{serverId: vm.Id}
+
+	booted := true
+
+	podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+	return podData, nil
+}
+
+func (v *scalewayPodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, volumes []*types.Volume) (*common.PodData, error) {
+	vm := v.newVM(common.VMName(req.Config.Metadata, maxServerNameLen))
+
+	return v.bootSandbox(ctx, vm, req.Config, vm.Name)
+}
+
+func (v *scalewayPodProvider) PreCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+	return nil
+}
+
+func (v *scalewayPodProvider) StopPodSandbox(ctx context.Context, podData *common.PodData) error {
+	return nil
+}
+
+// RemovePodSandbox makes sure the server and its volumes are gone, the manager only destroys the VM of booted pods and
+// we don't want to keep paying for one that is left behind
+func (v *scalewayPodProvider) RemovePodSandbox(data *common.PodData) {
+	providerData, ok := data.ProviderData.(*podData)
+	if !ok {
+		return
+	}
+
+	providerData.lock.Lock()
+	defer providerData.lock.Unlock()
+
+	if providerData.removed {
+		return
+	}
+
+	if err := v.client.destroyServer(providerData.serverId); err != nil {
+		glog.Warningf("RemovePodSandbox: couldn't delete server %v of %v: %v", providerData.serverId, data.Id, err)
+		return
+	}
+
+	providerData.removed = true
+}
+
+func (v *scalewayPodProvider) PodSandboxStatus(podData *common.PodData) {}
+
+func (v *scalewayPodProvider) HealthCheck() error {
+	if err := v.client.checkAccess(v.config.owner()); err != nil {
+		return fmt.Errorf("HealthCheck: %v", err)
+	}
+
+	return nil
+}
+
+// VMExists lets the reconciler drop pods whose server was deleted behind our back
+func (v *scalewayPodProvider) VMExists(podData *common.PodData) (bool, error) {
+	vm, ok := podData.VM.(*serverVM)
+	if !ok {
+		return true, nil
+	}
+
+	if _, err := v.client.getServer(vm.Id); err == errNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("VMExists: %v", err)
+	}
+
+	return true, nil
+}
+
+func (v *scalewayPodProvider) ListInstances() ([]*common.PodData, error) {
+	servers, err := v.client.listServers(infranetesTag)
+	if err != nil {
+		return nil, fmt.Errorf("ListInstances: %v", err)
+	}
+
+	podDatas := []*common.PodData{}
+	for _, s := range servers {
+		if s.State != "running" {
+			glog.Infof("ListInstances: skipping %v as it is %v", s.Name, s.State)
+			continue
+		}
+
+		vm := v.newVM(s.Name)
+		vm.Id = s.Id
+
+		client, podIp, config, err := common.ConnectSandbox(vm, v.bootConfig())
+		if err != nil {
+			glog.Warningf("ListInstances: skipping %v: %v", s.Name, err)
+			continue
+		}
+
+		name := s.Name
+
+		providerData := &podData{serverId: s.Id}
+
+		glog.Infof("ListInstances: creating a podData for %v", name)
+		booted := true
+		podData := common.NewPodData(vm, name, config.Metadata, config.Annotations, config.Labels, podIp, config.Linux, client, booted, providerData)
+
+		podDatas = append(podDatas, podData)
+	}
+
+	return podDatas, nil
+}
+
+func (v *scalewayPodProvider) GetVMList() ([]string, error) {
+	servers, err := v.client.listServers(infranetesTag)
+	if err != nil {
+		return nil, fmt.Errorf("GetVMList: %v", err)
+	}
+
+	names := []string{}
+	for _, s := range servers {
+		names = append(names, s.Name)
+	}
+
+	return names, nil
+}
+
+func (v *scalewayPodProvider) VMName(podData *common.PodData) string {
+	if podData.VM == nil {
+		return ""
+	}
+
+	return podData.VM.GetName()
+}
+
+func (v *scalewayPodProvider) newVM(name string) *serverVM {
+	return &serverVM{
+		client:         v.client,
+		Name:           name,
+		CommercialType: v.config.CommercialType,
+		Image:          v.config.Image,
+		Project:        v.config.ProjectId,
+		Organization:   v.config.OrganizationId,
+	}
+}
+
+func (p *podData) Attach(vol, device string) (string, error) {
+	return "", errors.New("Attach: Not implemented yet")
+}
+
+func (p *podData) NeedMount(vol string) bool {
+	return false
+}
@@ -0,0 +1,128 @@
+package virtualbox
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/apcera/libretto/virtualmachine/virtualbox"
+	"github.com/apcera/util/uuid"
+)
+
+const (
+	// CloneMode values, unset imports VMSrc as an appliance for every pod
+	cloneFull   = "full"
+	cloneLinked = "linked"
+
+	// the snapshot of VMSrc linked clones are made from, taken if VMSrc doesn't have it yet
+	defaultCloneSnapshot = "infranetes-base"
+
+	// every clone's name starts with clonePrefix, so ones left behind by a crash can be found again
+	clonePrefix = "infranetes-clone-"
+)
+
+// listed VMs look like "name" {uuid}
+var listVMRegexp = regexp.MustCompile(`^"(.*)" \{[0-9a-fA-F-]+\}$`)
+
+func validateCloneMode(mode string) error {
+	switch mode {
+	case "", cloneFull, cloneLinked:
+		return nil
+	}
+
+	return fmt.Errorf("unknown CloneMode %q, must be %v or %v", mode, cloneFull, cloneLinked)
+}
+
+// cloneVM creates and registers vm as a clone of the registered VM src, instead of importing it.  Linked clones share
+// src's disks as of snapshot, so each pod only writes its own differencing disk.
+func cloneVM(vm *virtualbox.VM, mode string, src string, snapshot string) error {
+	if src == "" {
+		return fmt.Errorf("no VMSrc to clone")
+	}
+
+	vm.Name = fmt.Sprintf("%s%s", clonePrefix, uuid.Variant4())
+
+	args := []string{"clonevm", src, "--name", vm.Name, "--register"}
+	if mode == cloneLinked {
+		if err := ensureSnapshot(src, snapshot); err != nil {
+			return err
+		}
+		args = append(args, "--snapshot", snapshot, "--options", "link")
+	}
+
+	// cloning the same source at once trips over its lock, the same as importing does
+	importLock.Lock()
+	defer importLock.Unlock()
+
+	if _, err := vboxManage(args...); err != nil {
+		return fmt.Errorf("couldn't clone %v: %v", src, err)
+	}
+
+	glog.Infof("cloneVM: created %v clone %v of %v", mode, vm.Name, src)
+
+	return nil
+}
+
+// ensureSnapshot takes snapshot of src if it doesn't have it yet, linked clones need one to share
+func ensureSnapshot(src string, snapshot string) error {
+	importLock.Lock()
+	defer importLock.Unlock()
+
+	if _, err := vboxManage("snapshot", src, "showvminfo", snapshot); err == nil {
+		return nil
+	}
+
+	glog.Infof("ensureSnapshot: taking snapshot %v of %v for linked clones", snapshot, src)
+	if _, err := vboxManage("snapshot", src, "take", snapshot); err != nil {
+		return fmt.Errorf("couldn't take snapshot %v of %v: %v", snapshot, src, err)
+	}
+
+	return nil
+}
+
+// isClone is true for the VMs cloneVM creates
+func isClone(name string) bool {
+	return strings.HasPrefix(name, clonePrefix)
+}
+
+// deleteClone powers off and deletes the clone name along with its disks.  Succeeds for a clone that is already gone.
+func deleteClone(name string) error {
+	if _, err := vboxManage("showvminfo", name); err != nil {
+		return nil
+	}
+
+	// fails for a clone that isn't running, which is fine
+	vboxManage("controlvm", name, "poweroff")
+
+	if _, err := vboxManage("unregistervm", name, "--delete"); err != nil {
+		return fmt.Errorf("couldn't delete clone %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// cleanupClones deletes every clone there is.  Pods' VMs aren't imported again on restart, so at startup they are all
+// left over from before.
+func cleanupClones() {
+	out, err := vboxManage("list", "vms")
+	if err != nil {
+		glog.Warningf("cleanupClones: couldn't list VMs: %v", err)
+		return
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		m := listVMRegexp.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil || !isClone(m[1]) {
+			continue
+		}
+
+		glog.Infof("cleanupClones: deleting orphaned clone %v", m[1])
+		if err := deleteClone(m[1]); err != nil {
+			glog.Warningf("cleanupClones: %v", err)
+		}
+	}
+}
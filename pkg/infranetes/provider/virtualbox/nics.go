@@ -0,0 +1,137 @@
+package virtualbox
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/apcera/libretto/virtualmachine/virtualbox"
+)
+
+// nicConfig is one of the VM's network adapters, in the order they are given in virtualbox.json
+type nicConfig struct {
+	// Backing is "bridged", "nat", "hostonly" or "intnet"
+	Backing string
+	// Device is the host interface for bridged and hostonly NICs and the network name for intnet ones
+	Device string
+	// Primary marks the NIC infranetes dials the vmserver on, without one IPSelection picks the address
+	Primary bool
+}
+
+func validateNICs(nics []nicConfig) error {
+	if len(nics) == 0 {
+		return errors.New("no NICs configured")
+	}
+	// VirtualBox adapters are --nic1 through --nic8
+	if len(nics) > 8 {
+		return fmt.Errorf("at most 8 NICs are supported, not %d", len(nics))
+	}
+
+	primaries := 0
+	for i, nic := range nics {
+		switch nic.Backing {
+		case "nat":
+		case "bridged", "hostonly", "intnet":
+			if nic.Device == "" {
+				return fmt.Errorf("NIC %d: %v needs a Device", i+1, nic.Backing)
+			}
+		default:
+			return fmt.Errorf("NIC %d: unknown Backing %q, must be bridged, nat, hostonly or intnet", i+1, nic.Backing)
+		}
+
+		if nic.Primary {
+			primaries++
+		}
+	}
+
+	if primaries > 1 {
+		return errors.New("only one NIC can be Primary")
+	}
+
+	return nil
+}
+
+// librettoNICs converts nics to what libretto understands, false if it can't handle all of them
+func librettoNICs(nics []nicConfig) ([]virtualbox.NIC, bool) {
+	ret := []virtualbox.NIC{}
+	for i, nic := range nics {
+		switch nic.Backing {
+		case "nat":
+			ret = append(ret, virtualbox.NIC{Idx: i + 1, Backing: virtualbox.Nat})
+		case "bridged":
+			ret = append(ret, virtualbox.NIC{Idx: i + 1, Backing: virtualbox.Bridged, BackingDevice: nic.Device})
+		default:
+			return nil, false
+		}
+	}
+
+	return ret, true
+}
+
+// addNIC configures adapter idx of an imported, powered off, VM
+func addNIC(name string, idx int, nic nicConfig) error {
+	args := []string{"modifyvm", name, fmt.Sprintf("--nic%d", idx), nic.Backing}
+	switch nic.Backing {
+	case "bridged":
+		args = append(args, fmt.Sprintf("--bridgeadapter%d", idx), nic.Device)
+	case "hostonly":
+		args = append(args, fmt.Sprintf("--hostonlyadapter%d", idx), nic.Device)
+	case "intnet":
+		args = append(args, fmt.Sprintf("--intnet%d", idx), nic.Device)
+	}
+
+	_, err := vboxManage(args...)
+	return err
+}
+
+// primaryIP returns the address guest additions report for the primary NIC, or nil if there is none.  Guests number
+// their interfaces from 0 in adapter order.
+func primaryIP(name string, nics []nicConfig) (net.IP, error) {
+	for i, nic := range nics {
+		if !nic.Primary {
+			continue
+		}
+
+		ip, out, err := guestIP(name, i)
+		if err != nil {
+			return nil, err
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("primary NIC %d has no address: %v", i+1, out)
+		}
+
+		return ip, nil
+	}
+
+	return nil, nil
+}
+
+// guestIPs returns the addresses guest additions report for the VM's NICs, skipping those that don't have one yet.
+// Unlike libretto's GetIPs it doesn't try to start the VM or wait, so it can be polled.
+func guestIPs(name string, nics []nicConfig) ([]net.IP, error) {
+	var ips []net.IP
+	for i := range nics {
+		ip, _, err := guestIP(name, i)
+		if err != nil {
+			return nil, err
+		}
+		if ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips, nil
+}
+
+// guestIP returns the address of the guest's interface i, nil if it has none, along with what VBoxManage said
+func guestIP(name string, i int) (net.IP, string, error) {
+	out, err := vboxManage("guestproperty", "get", name, fmt.Sprintf("/VirtualBox/GuestInfo/Net/%d/V4/IP", i))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// output is "Value: 1.2.3.4" or "No value set!"
+	out = strings.TrimSpace(out)
+	return net.ParseIP(strings.TrimSpace(strings.TrimPrefix(out, "Value:"))), out, nil
+}
@@ -2,11 +2,23 @@ package virtualbox
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
 
 	"github.com/apcera/libretto/virtualmachine/virtualbox"
+	"github.com/apcera/util/uuid"
 
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
 	"github.com/apporbit/infranetes/pkg/infranetes/types"
@@ -14,21 +26,80 @@ import (
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
+const (
+	cpusAnnotation   = "infranetes.virtualbox.cpus"
+	memoryAnnotation = "infranetes.virtualbox.memorymb"
+
+	minCPUs     = 1
+	maxCPUs     = 32
+	minMemoryMB = 256
+
+	// waitForIP polls every ipPollInterval at first, doubling up to ipPollMaxInterval
+	defaultIPWaitSeconds = 120
+	ipPollInterval       = time.Second
+	ipPollMaxInterval    = 10 * time.Second
+)
+
 type vboxProvider struct {
-	netDevice string
-	vmSrc     string
+	// swapped by ReloadConfig, read it with getConfig()
+	configLock sync.RWMutex
+	config     *vboxConfig
 }
 
+// VBoxManage import isn't safe to run concurrently, libretto serializes it the same way
+var importLock sync.Mutex
+
 func init() {
 	provider.PodProviders.RegisterProvider("virtualbox", NewVBoxProvider)
 }
 
 type vboxConfig struct {
+	// NetDevice is the host interface of the VM's single bridged NIC when NICs isn't given
 	NetDevice string
+	NICs      []nicConfig
 	VMSrc     string
+
+	// CloneMode "full" or "linked" has VMSrc name a registered VM that every pod gets a clone of, rather than an
+	// appliance that is imported for every pod.  Linked clones are made from VMSrc's CloneSnapshot, defaulting to
+	// defaultCloneSnapshot, which is taken if VMSrc doesn't have it.
+	CloneMode     string
+	CloneSnapshot string
+
+	// IPSelection picks which NIC's address we dial, i.e. the host-only one when there is also a NAT NIC, unless one
+	// of the NICs is marked Primary
+	IPSelection common.IPSelector
+
+	// CPUs and MemoryMB resize the VM, 0 keeps what VMSrc was built with.  They can be overridden per pod with the
+	// infranetes.virtualbox.cpus and infranetes.virtualbox.memorymb annotations
+	CPUs     int
+	MemoryMB int
+
+	// IPWaitSeconds is how long a booted VM has to get a usable address (i.e. a DHCP lease) before its pod fails,
+	// defaults to defaultIPWaitSeconds
+	IPWaitSeconds int
+
+	// NetworkMode overrides the network namespace pods are reported to be in, common.NetworkModeHost or
+	// common.NetworkModePod.  Unset reports what each pod asked for.
+	NetworkMode string
+
+	// AgentPort is where vmserver listens in the VM, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
 }
 
-func NewVBoxProvider() (provider.PodProvider, error) {
+func validateResources(cpus, memoryMB int) error {
+	if cpus != 0 && (cpus < minCPUs || cpus > maxCPUs) {
+		return fmt.Errorf("CPUs must be between %d and %d, not %d", minCPUs, maxCPUs, cpus)
+	}
+	if memoryMB != 0 && memoryMB < minMemoryMB {
+		return fmt.Errorf("MemoryMB must be at least %d, not %d", minMemoryMB, memoryMB)
+	}
+
+	return nil
+}
+
+// loadVBoxConfig reads and validates virtualbox.json, filling in the defaults
+func loadVBoxConfig() (*vboxConfig, error) {
 	var conf vboxConfig
 
 	file, err := ioutil.ReadFile("virtualbox.json")
@@ -38,10 +109,75 @@ func NewVBoxProvider() (provider.PodProvider, error) {
 
 	json.Unmarshal(file, &conf)
 
-	return &vboxProvider{
-		netDevice: conf.NetDevice,
-		vmSrc:     conf.VMSrc,
-	}, nil
+	if conf.AgentPort == 0 {
+		conf.AgentPort = common.DefaultAgentPort
+	}
+	if conf.IPWaitSeconds <= 0 {
+		conf.IPWaitSeconds = defaultIPWaitSeconds
+	}
+	if conf.CloneSnapshot == "" {
+		conf.CloneSnapshot = defaultCloneSnapshot
+	}
+
+	if err := validateCloneMode(conf.CloneMode); err != nil {
+		return nil, fmt.Errorf("virtualbox.json: %v", err)
+	}
+
+	if err := conf.IPSelection.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateResources(conf.CPUs, conf.MemoryMB); err != nil {
+		return nil, fmt.Errorf("virtualbox.json: %v", err)
+	}
+	if err := common.ValidateNetworkMode(conf.NetworkMode); err != nil {
+		return nil, fmt.Errorf("virtualbox.json: %v", err)
+	}
+
+	if len(conf.NICs) == 0 {
+		conf.NICs = []nicConfig{{Backing: "bridged", Device: conf.NetDevice}}
+	}
+	if err := validateNICs(conf.NICs); err != nil {
+		return nil, fmt.Errorf("virtualbox.json: %v", err)
+	}
+
+	return &conf, nil
+}
+
+func NewVBoxProvider() (provider.PodProvider, error) {
+	conf, err := loadVBoxConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// even without CloneMode now, there may be clones from when it was set
+	cleanupClones()
+
+	return &vboxProvider{config: conf}, nil
+}
+
+func (v *vboxProvider) getConfig() *vboxConfig {
+	v.configLock.RLock()
+	defer v.configLock.RUnlock()
+
+	return v.config
+}
+
+// ReloadConfig re-reads virtualbox.json, VMs booted after it get the new VMSrc, NICs and sizes.  AgentPort keeps its old
+// value, the running VMs' vmservers listen on it.
+func (v *vboxProvider) ReloadConfig() error {
+	conf, err := loadVBoxConfig()
+	if err != nil {
+		return err
+	}
+
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+
+	common.KeepFixedFields("virtualbox.json", v.config, conf, "AgentPort")
+	v.config = conf
+
+	return nil
 }
 
 func (p *vboxProvider) SetBootAtRun(boot bool) {}
@@ -50,53 +186,256 @@ func (*vboxProvider) UpdatePodState(cPodData *common.PodData) {
 	cPodData.UpdatePodState()
 }
 
-func (v *vboxProvider) RunPodSandbox(req *kubeapi.RunPodSandboxRequest, voluems []*types.Volume) (*common.PodData, error) {
-	config := virtualbox.Config{
-		NICs: []virtualbox.NIC{
-			{Idx: 1, Backing: virtualbox.Bridged, BackingDevice: v.netDevice},
-		},
-	}
+func (v *vboxProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, voluems []*types.Volume) (*common.PodData, error) {
+	conf := v.getConfig()
+
+	vm := &virtualbox.VM{Src: conf.VMSrc}
 
-	vm := &virtualbox.VM{Src: v.vmSrc,
-		Config: config,
+	cpus, memoryMB, err := conf.resources(req.Config.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("CreatePodSandbox: %v", err)
 	}
 
-	if err := vm.Provision(); err != nil {
+	if err := provision(vm, conf, cpus, memoryMB); err != nil {
 		return nil, fmt.Errorf("Failed to Provision: %v", err)
 	}
 
-	ips, err := vm.GetIPs()
+	selected, err := conf.waitForIP(ctx, vm)
 	if err != nil {
-		return nil, fmt.Errorf("CreatePodSandbox: error in GetIPs(): %v", err)
+		vm.Destroy()
+		return nil, fmt.Errorf("CreatePodSandbox: %v", err)
 	}
+	ip := selected.String()
 
-	ip := ips[0].String()
-
-	client, err := common.CreateRealClient(ip)
+	client, err := common.WaitForAgent(ip, conf.AgentPort, *flags.VMReadyTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("CreatePodSandbox: error in createClient(): %v", err)
+		vm.Destroy()
+		return nil, fmt.Errorf("CreatePodSandbox: %v", err)
 	}
 
 	name := vm.GetName()
 	booted := true
 	podData := common.NewPodData(vm, name, req.Config.Metadata, req.Config.Annotations, req.Config.Labels, ip, req.Config.Linux, client, booted, nil)
+	podData.NetworkMode = conf.NetworkMode
 
 	return podData, nil
 }
 
-func (v *vboxProvider) PreCreateContainer(podData *common.PodData, req *kubeapi.CreateContainerRequest, f func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+// waitForIP returns the address to dial the VM's vmserver on, polling with backoff for up to IPWaitSeconds as the guest
+// often doesn't have a DHCP lease yet when libretto's GetIPs gives up on it
+func (c *vboxConfig) waitForIP(ctx context.Context, vm *virtualbox.VM) (net.IP, error) {
+	ipWait := time.Duration(c.IPWaitSeconds) * time.Second
+	deadline := time.Now().Add(ipWait)
+	interval := ipPollInterval
+
+	ips, err := vm.GetIPs()
+	for {
+		var selected net.IP
+		if err == nil {
+			selected, err = c.selectIP(vm.Name, ips)
+		}
+		if err == nil {
+			return selected, nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return nil, fmt.Errorf("%v had no usable address within %v: %v", vm.Name, ipWait, err)
+		}
+
+		glog.Infof("waitForIP: %v has no usable address yet, retrying in %v: %v", vm.Name, interval, err)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up waiting for an address for %v: %v", vm.Name, ctx.Err())
+		}
+
+		if interval *= 2; interval > ipPollMaxInterval {
+			interval = ipPollMaxInterval
+		}
+
+		ips, err = guestIPs(vm.Name, c.NICs)
+	}
+}
+
+// selectIP picks the primary NIC's address, or if there is no primary NIC one of ips with IPSelection
+func (c *vboxConfig) selectIP(name string, ips []net.IP) (net.IP, error) {
+	selected, err := primaryIP(name, c.NICs)
+	if err != nil || selected != nil {
+		return selected, err
+	}
+
+	return c.IPSelection.Select(ips)
+}
+
+// resources returns the cpus and memory the pod's VM should have, 0 meaning leave it as is.
+// The generic infranetes.cpu and infranetes.memory annotations are used as is, rounded up to whole cpus and at least
+// minMemoryMB, and the virtualbox specific ones override them.
+func (c *vboxConfig) resources(annotations map[string]string) (int, int, error) {
+	cpus := c.CPUs
+	memoryMB := c.MemoryMB
+
+	r, err := common.ParseResources(annotations)
+	if err != nil {
+		return 0, 0, err
+	}
+	if r.MilliCPUs > 0 {
+		cpus = int((r.MilliCPUs + 999) / 1000)
+	}
+	if r.MemoryMB > 0 {
+		memoryMB = int(r.MemoryMB)
+		if memoryMB < minMemoryMB {
+			memoryMB = minMemoryMB
+		}
+	}
+
+	if val, ok := annotations[cpusAnnotation]; ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad %v annotation %q: %v", cpusAnnotation, val, err)
+		}
+		cpus = n
+	}
+
+	if val, ok := annotations[memoryAnnotation]; ok {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad %v annotation %q: %v", memoryAnnotation, val, err)
+		}
+		memoryMB = n
+	}
+
+	if err := validateResources(cpus, memoryMB); err != nil {
+		return 0, 0, err
+	}
+
+	return cpus, memoryMB, nil
+}
+
+// provision does what vm.Provision() does, but resizes the VM and sets up the NICs libretto doesn't know about
+// between importing (or cloning) and booting it, as libretto's Provision() boots it straight away
+func provision(vm *virtualbox.VM, conf *vboxConfig, cpus int, memoryMB int) error {
+	nics := conf.NICs
+
+	if conf.CloneMode != "" {
+		if err := cloneVM(vm, conf.CloneMode, conf.VMSrc, conf.CloneSnapshot); err != nil {
+			return err
+		}
+	} else {
+		if lnics, ok := librettoNICs(nics); ok && cpus == 0 && memoryMB == 0 {
+			vm.Config = virtualbox.Config{NICs: lnics}
+			return vm.Provision()
+		}
+
+		if err := importVM(vm); err != nil {
+			return err
+		}
+	}
+
+	if cpus != 0 || memoryMB != 0 {
+		args := []string{"modifyvm", vm.Name}
+		if cpus != 0 {
+			args = append(args, "--cpus", strconv.Itoa(cpus))
+		}
+		if memoryMB != 0 {
+			args = append(args, "--memory", strconv.Itoa(memoryMB))
+		}
+		if _, err := vboxManage(args...); err != nil {
+			vm.Destroy()
+			return err
+		}
+	}
+
+	if err := virtualbox.DeleteNICs(vm); err != nil {
+		vm.Destroy()
+		return err
+	}
+	for i, nic := range nics {
+		if err := addNIC(vm.Name, i+1, nic); err != nil {
+			vm.Destroy()
+			return err
+		}
+	}
+
+	// GetIPs() boots the VM and waits for it to report its addresses
+	if _, err := vm.GetIPs(); err != nil {
+		vm.Destroy()
+		return err
+	}
+
+	return nil
+}
+
+// importVM imports vm.Src as the appliance of a new VM
+func importVM(vm *virtualbox.VM) error {
+	if vm.Src == "" {
+		return errors.New("no VMSrc to import")
+	}
+	src, err := filepath.Abs(vm.Src)
+	if err != nil {
+		return err
+	}
+	vm.Src = src
+
+	if vm.Name == "" {
+		vm.Name = fmt.Sprintf("vm-%s", uuid.Variant4())
+	}
+
+	importLock.Lock()
+	_, err = vboxManage("import", vm.Src, "--vsys", "0", "--vmname", vm.Name)
+	importLock.Unlock()
+
+	return err
+}
+
+func vboxManage(args ...string) (string, error) {
+	out, err := exec.Command("VBoxManage", args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("VBoxManage %v failed: %v: %s", args[0], err, out)
+	}
+
+	return string(out), nil
+}
+
+func (v *vboxProvider) PreCreateContainer(ctx context.Context, podData *common.PodData, req *kubeapi.CreateContainerRequest, f func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
 	return nil
 }
 
-func (v *vboxProvider) StopPodSandbox(podData *common.PodData) {
+// StopPodSandbox powers off the VM, a stopped sandbox shouldn't keep running its VM until it is removed
+func (v *vboxProvider) StopPodSandbox(ctx context.Context, podData *common.PodData) error {
+	if podData.VM == nil {
+		return nil
+	}
+
+	if err := podData.VM.Halt(); err != nil {
+		return fmt.Errorf("StopPodSandbox: couldn't halt %v: %v", podData.VM.GetName(), err)
+	}
+
+	return nil
 }
 
+// RemovePodSandbox makes sure a cloned VM is gone along with its disks, the manager only destroys the VMs of booted
+// pods
 func (v *vboxProvider) RemovePodSandbox(podData *common.PodData) {
+	if podData.VM == nil || !isClone(podData.VM.GetName()) {
+		return
+	}
+
+	if err := deleteClone(podData.VM.GetName()); err != nil {
+		glog.Warningf("RemovePodSandbox: %v", err)
+	}
 }
 
 func (v *vboxProvider) PodSandboxStatus(podData *common.PodData) {
 }
 
+func (v *vboxProvider) HealthCheck() error {
+	if _, err := exec.LookPath("VBoxManage"); err != nil {
+		return fmt.Errorf("HealthCheck: couldn't find VBoxManage: %v", err)
+	}
+
+	return nil
+}
+
 func (v *vboxProvider) ListInstances() ([]*common.PodData, error) {
 	return []*common.PodData{}, nil
 }
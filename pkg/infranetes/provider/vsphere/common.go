@@ -1,7 +1,8 @@
 package vsphere
 
 import (
-	"github.com/apporbit/infranetes/pkg/common"
+	icommon "github.com/apporbit/infranetes/pkg/common"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
 )
 
 type vsphereConfig struct {
@@ -15,5 +16,16 @@ type vsphereConfig struct {
 	Insecure   bool
 
 	Template string
-	Routes   []common.AddRouteRequest
+	Routes   []icommon.AddRouteRequest
+
+	// SshUser and SshPassword log into the template, both default to ubuntu
+	SshUser     string
+	SshPassword string
+
+	// IPSelection picks the VM address we dial, and that the pod is given.  Defaults to the first one reported.
+	IPSelection common.IPSelector
+
+	// AgentPort is where vmserver listens in the VM, i.e. when a firewall only lets some other port through.
+	// Defaults to common.DefaultAgentPort.
+	AgentPort int
 }
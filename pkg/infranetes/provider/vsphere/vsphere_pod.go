@@ -8,10 +8,12 @@ import (
 	"strings"
 
 	"github.com/golang/glog"
+	"golang.org/x/net/context"
 
 	"github.com/apcera/libretto/ssh"
 	vsvm "github.com/apcera/libretto/virtualmachine/vsphere"
 
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider"
 	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
 	"github.com/apporbit/infranetes/pkg/infranetes/types"
@@ -45,6 +47,21 @@ func NewAWSPodProvider() (provider.PodProvider, error) {
 		return nil, fmt.Errorf(msg)
 	}
 
+	if conf.AgentPort == 0 {
+		conf.AgentPort = common.DefaultAgentPort
+	}
+
+	if err := conf.IPSelection.Validate(); err != nil {
+		return nil, err
+	}
+
+	if conf.SshUser == "" {
+		conf.SshUser = "ubuntu"
+	}
+	if conf.SshPassword == "" {
+		conf.SshPassword = "ubuntu"
+	}
+
 	glog.Infof("Validating Vsphere Credentials")
 	err = verifyCreds(conf.Host, conf.Username, conf.Password, conf.Insecure)
 	if err != nil {
@@ -77,21 +94,26 @@ func (p *vspherePodProvider) bootSandbox(vm *vsvm.VM, config *kubeapi.PodSandbox
 	// 3. Extract IP Info
 	ips, err := vm.GetIPs()
 	if err != nil {
+		vm.Destroy()
 		return nil, fmt.Errorf("CreatePodSandbox: error in GetIPs(): %v", err)
 	}
 
 	glog.Infof("CreatePodSandbox: ips = %v", ips)
 
-	// FIXME: Need to learn from practic which is the correct index
-	index := 0
-	podIp := ips[index].String()
+	ip, err := p.config.IPSelection.Select(ips)
+	if err != nil {
+		vm.Destroy()
+		return nil, fmt.Errorf("CreatePodSandbox: %v", err)
+	}
+	podIp := ip.String()
 
 	glog.Infof("CreatePodSandbox: podIp = %v", podIp)
 
 	// 4. Connect to VMServer in VM
-	client, err := common.CreateRealClient(podIp)
+	client, err := common.WaitForAgent(podIp, p.config.AgentPort, *flags.VMReadyTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("CreatePodSandbox: error in createClient(): %v", err)
+		vm.Destroy()
+		return nil, fmt.Errorf("CreatePodSandbox: %v", err)
 	}
 
 	// 5. Setup Instance / VM Correctly
@@ -144,19 +166,21 @@ func (p *vspherePodProvider) bootSandbox(vm *vsvm.VM, config *kubeapi.PodSandbox
 	return podData, nil
 }
 
-func (v *vspherePodProvider) RunPodSandbox(req *kubeapi.RunPodSandboxRequest, voluems []*types.Volume) (*common.PodData, error) {
+func (v *vspherePodProvider) RunPodSandbox(ctx context.Context, req *kubeapi.RunPodSandboxRequest, voluems []*types.Volume) (*common.PodData, error) {
 	podIp := ""
 	vm := v.createVM(req.Config, podIp)
 
 	return v.bootSandbox(vm, req.Config, vm.Name)
 }
 
-func (v *vspherePodProvider) PreCreateContainer(data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
+func (v *vspherePodProvider) PreCreateContainer(ctx context.Context, data *common.PodData, req *kubeapi.CreateContainerRequest, imageStatus func(req *kubeapi.ImageStatusRequest) (*kubeapi.ImageStatusResponse, error)) error {
 	//FIXME: image support to be added
 	return nil
 }
 
-func (v *vspherePodProvider) StopPodSandbox(podData *common.PodData) {}
+func (v *vspherePodProvider) StopPodSandbox(ctx context.Context, podData *common.PodData) error {
+	return nil
+}
 
 func (v *vspherePodProvider) RemovePodSandbox(data *common.PodData) {
 	glog.Infof("RemovePodSandbox: release IP: %v", data.Ip)
@@ -166,6 +190,10 @@ func (v *vspherePodProvider) RemovePodSandbox(data *common.PodData) {
 
 func (v *vspherePodProvider) PodSandboxStatus(podData *common.PodData) {}
 
+func (v *vspherePodProvider) HealthCheck() error {
+	return verifyCreds(v.config.Host, v.config.Username, v.config.Password, v.config.Insecure)
+}
+
 func (v *vspherePodProvider) ListInstances() ([]*common.PodData, error) {
 	vms, err := listVMs(v.config.Host, v.config.Username, v.config.Password, v.config.Datacenter, v.config.Insecure)
 	if err != nil {
@@ -180,7 +208,7 @@ func (v *vspherePodProvider) ListInstances() ([]*common.PodData, error) {
 			continue
 		}
 
-		client, err := common.CreateRealClient(podIp)
+		client, err := common.CreateRealClient(podIp, v.config.AgentPort)
 
 		podIp, err = client.GetPodIP()
 		if err != nil {
@@ -234,8 +262,8 @@ func (v *vspherePodProvider) createVM(config *kubeapi.PodSandboxConfig, podIp st
 		UseLinkedClones: true,
 
 		Credentials: ssh.Credentials{
-			SSHUser:     "ubuntu",
-			SSHPassword: "ubuntu",
+			SSHUser:     v.config.SshUser,
+			SSHPassword: v.config.SshPassword,
 		},
 		Destination: vsvm.Destination{
 			DestinationType: vsvm.DestinationTypeHost,
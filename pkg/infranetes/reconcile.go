@@ -0,0 +1,93 @@
+package infranetes
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// reconcile checks every pod each interval, so a dead VM is noticed without waiting for the kubelet to ask about it
+func (m *Manager) reconcile(interval time.Duration) {
+	glog.Infof("reconcile: checking pods every %v", interval)
+
+	for range time.Tick(interval) {
+		m.reconcileOnce()
+	}
+}
+
+func (m *Manager) reconcileOnce() {
+	evicted := false
+
+	for id, podData := range m.copyVMMap() {
+		if m.checkPod(podData) {
+			m.evictPod(id, podData)
+			evicted = true
+		}
+	}
+
+	if evicted {
+		m.saveState()
+	}
+}
+
+// checkPod marks the pod not ready if its vmserver stopped answering, and returns true if its VM no longer exists
+func (m *Manager) checkPod(podData *common.PodData) bool {
+	podData.Lock()
+	defer podData.Unlock()
+
+	// not booted pods have no VM yet, and a removed one has no client left to check
+	if !podData.Booted || podData.Client == nil {
+		return false
+	}
+
+	if podData.PodState != kubeapi.PodSandboxState_SANDBOX_NOTREADY {
+		podData.RefreshPodState()
+		if podData.PodState == kubeapi.PodSandboxState_SANDBOX_NOTREADY {
+			glog.Warningf("checkPod: %v stopped answering, marked it not ready", podData.Id)
+		}
+	}
+
+	// only bother the cloud about VMs that have stopped answering
+	checker, ok := m.podProvider.(provider.VMChecker)
+	if !ok || podData.PodState != kubeapi.PodSandboxState_SANDBOX_NOTREADY {
+		return false
+	}
+
+	exists, err := checker.VMExists(podData)
+	if err != nil {
+		glog.Warningf("checkPod: couldn't tell if the VM of %v exists: %v", podData.Id, err)
+		return false
+	}
+
+	return !exists
+}
+
+// evictPod forgets a pod whose VM is gone, the kubelet will see the sandbox disappear and recreate it
+func (m *Manager) evictPod(id string, podData *common.PodData) {
+	glog.Warningf("evictPod: VM of %v is gone, removing the pod", id)
+
+	podData.Lock()
+	// the kubelet may have removed it since checkPod looked at it
+	if podData.Client == nil {
+		podData.Unlock()
+		return
+	}
+	// the VM is gone, there is nothing to destroy
+	if shared, _ := m.removeFromSharedVM(podData, false); !shared {
+		podData.RemovePod()
+		m.podProvider.RemovePodSandbox(podData)
+	}
+	meta := podData.Metadata
+	podData.Unlock()
+
+	m.vmMapLock.Lock()
+	defer m.vmMapLock.Unlock()
+
+	m.removeSandboxLocked(id, meta)
+	m.forgetVolumes(meta.Uid)
+}
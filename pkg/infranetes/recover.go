@@ -0,0 +1,48 @@
+/* Panic recovery for the grpc server, so a bug in one request fails that request instead of the whole manager */
+
+package infranetes
+
+import (
+	"path"
+	"runtime/debug"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+)
+
+// recoverUnary turns a panic in a unary handler into a codes.Internal error.  Goroutines the handler started are on
+// their own, a panic in one of those still takes the manager down.
+func recoverUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(info.FullMethod, req, r)
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// recoverStream is recoverUnary for streaming handlers, which have no single request to log
+func recoverStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(info.FullMethod, nil, r)
+		}
+	}()
+
+	return handler(srv, ss)
+}
+
+// recovered logs the panic r of method along with req and where it happened, and returns the error the client gets
+func recovered(method string, req interface{}, r interface{}) error {
+	glog.Errorf("%v: recovered from panic: %v, req = %+v\n%s", method, r, req, debug.Stack())
+
+	// the handler didn't get to observe() its failure
+	operationErrors.WithLabelValues(path.Base(method), *flags.PodProvider).Inc()
+
+	return grpc.Errorf(codes.Internal, "%v: internal error: %v", path.Base(method), r)
+}
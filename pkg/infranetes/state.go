@@ -0,0 +1,205 @@
+package infranetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider"
+	"github.com/apporbit/infranetes/pkg/infranetes/provider/common"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// savedPod is what we persist about a sandbox so it can be picked back up after a restart
+type savedPod struct {
+	Id          string
+	Ip          string
+	ClientIp    string `json:",omitempty"`
+	ClientPort  int    `json:",omitempty"`
+	InstanceId  string
+	Metadata    *kubeapi.PodSandboxMetadata
+	Annotations map[string]string
+	Labels      map[string]string
+	Linux       *kubeapi.LinuxPodSandboxConfig
+	CreatedAt   int64
+	PodState    kubeapi.PodSandboxState
+}
+
+// newStateStore returns the store picked by --state-store, nil if sandboxes aren't to be persisted
+func newStateStore() (common.StateStore, error) {
+	switch *flags.StateStore {
+	case "file":
+		if *flags.StateFile == "" {
+			return nil, nil
+		}
+		return common.NewFileStateStore(*flags.StateFile), nil
+	case "etcd":
+		endpoints := []string{}
+		for _, e := range strings.Split(*flags.StateEtcd, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				endpoints = append(endpoints, e)
+			}
+		}
+		store, err := common.NewEtcdStateStore(endpoints, *flags.StatePrefix)
+		if err != nil {
+			return nil, fmt.Errorf("--state-store=etcd: %v", err)
+		}
+		return store, nil
+	}
+
+	return nil, fmt.Errorf("unknown --state-store %v", *flags.StateStore)
+}
+
+// saveState writes out every booted sandbox whose record changed since it was last saved, and deletes the ones that
+// are gone.  Pods that haven't booted yet (i.e. image pods waiting on their container) aren't saved, they have no VM to
+// reconnect to.
+func (m *Manager) saveState() {
+	if m.store == nil {
+		return
+	}
+
+	restorer, _ := m.podProvider.(provider.PodRestorer)
+
+	pods := make(map[string]*savedPod)
+	for _, podData := range m.copyVMMap() {
+		// the owner of a shared VM stands for it, see sharedVM
+		if m.isGuest(podData) {
+			continue
+		}
+
+		podData.RLock()
+		if podData.Booted && podData.Client != nil {
+			saved := &savedPod{
+				Id:          podData.Id,
+				Ip:          podData.Ip,
+				Metadata:    podData.Metadata,
+				Annotations: podData.Annotations,
+				Labels:      podData.Labels,
+				Linux:       podData.Linux,
+				CreatedAt:   podData.CreatedAt,
+				PodState:    podData.PodState,
+			}
+			// the vmserver isn't always reached on the pod's ip, see common.IPSelector
+			if ip := podData.Client.IP(); ip != podData.Ip {
+				saved.ClientIp = ip
+			}
+			if port := podData.Client.Port(); port != common.DefaultAgentPort {
+				saved.ClientPort = port
+			}
+			if restorer != nil {
+				saved.InstanceId = restorer.InstanceId(podData)
+			}
+			pods[saved.Id] = saved
+		}
+		podData.RUnlock()
+	}
+
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+
+	for id, saved := range pods {
+		data, err := json.Marshal(saved)
+		if err != nil {
+			glog.Warningf("saveState: couldn't marshal %v: %v", id, err)
+			continue
+		}
+
+		if bytes.Equal(data, m.saved[id]) {
+			continue
+		}
+
+		if err := m.store.Save(id, data); err != nil {
+			glog.Warningf("saveState: couldn't save %v: %v", id, err)
+			continue
+		}
+		m.saved[id] = data
+	}
+
+	for id := range m.saved {
+		if _, ok := pods[id]; ok {
+			continue
+		}
+
+		if err := m.store.Delete(id); err != nil {
+			glog.Warningf("saveState: couldn't delete %v: %v", id, err)
+			continue
+		}
+		delete(m.saved, id)
+	}
+}
+
+// restoreState reloads the sandboxes saved by saveState that importSandboxes didn't already find, redialing their
+// vmservers by ip.  Providers that can't rebuild a VM from its instance id (i.e. don't implement PodRestorer) are skipped.
+func (m *Manager) restoreState() {
+	if m.store == nil {
+		return
+	}
+
+	records, err := m.store.Load()
+	if err != nil {
+		glog.Warningf("restoreState: couldn't load saved sandboxes: %v", err)
+		return
+	}
+
+	restorer, ok := m.podProvider.(provider.PodRestorer)
+	if !ok {
+		glog.Warningf("restoreState: %v pod provider can't restore pods, ignoring %v saved sandboxes", *flags.PodProvider, len(records))
+		return
+	}
+
+	for id, data := range records {
+		var saved savedPod
+		if err := json.Unmarshal(data, &saved); err != nil {
+			glog.Warningf("restoreState: couldn't parse saved %v: %v", id, err)
+			continue
+		}
+
+		// so the next saveState deletes it if it doesn't come back
+		m.stateLock.Lock()
+		m.saved[id] = data
+		m.stateLock.Unlock()
+
+		if _, err := m.getPodData(saved.Id); err == nil {
+			glog.Infof("restoreState: %v was already imported from the provider", saved.Id)
+			continue
+		}
+
+		clientIp := saved.Ip
+		if saved.ClientIp != "" {
+			clientIp = saved.ClientIp
+		}
+
+		clientPort := common.DefaultAgentPort
+		if saved.ClientPort != 0 {
+			clientPort = saved.ClientPort
+		}
+
+		client, err := common.CreateRealClient(clientIp, clientPort)
+		if err != nil {
+			glog.Warningf("restoreState: couldn't reconnect to %v at %v: %v", saved.Id, clientIp, err)
+			continue
+		}
+
+		booted := true
+		podData := common.NewPodData(nil, saved.Id, saved.Metadata, saved.Annotations, saved.Labels, saved.Ip, saved.Linux, client, booted, nil)
+		podData.CreatedAt = saved.CreatedAt
+		podData.PodState = saved.PodState
+
+		if err := restorer.RestorePod(saved.InstanceId, podData); err != nil {
+			glog.Warningf("restoreState: couldn't restore %v: %v", saved.Id, err)
+			client.Close()
+			continue
+		}
+
+		glog.Infof("restoreState: restored %v (%v)", saved.Id, saved.Ip)
+
+		m.vmMapLock.Lock()
+		m.addSandboxLocked(podData)
+		m.vmMapLock.Unlock()
+	}
+}
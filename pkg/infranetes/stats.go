@@ -0,0 +1,98 @@
+/* CPU usage rates, derived from the cumulative counters ListContainerStats gets from the vmservers */
+
+package infranetes
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/apporbit/infranetes/cmd/infranetes/flags"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	// a rate is over at least this long, samples closer to the one before it are compared to that sample's predecessor
+	cpuRateWindow = 10 * time.Second
+	// samples of containers that haven't been listed for this long are dropped, i.e. ones that were removed while
+	// only filtered lists came in
+	cpuSampleTTL = 5 * time.Minute
+)
+
+var containerCPUUsage = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "infranetes",
+		Name:      "container_cpu_usage_cores",
+		Help:      "CPU cores a container used between its last ListContainerStats samples.",
+	},
+	[]string{"pod_sandbox_id", "container_id", "provider"},
+)
+
+func init() {
+	prometheus.MustRegister(containerCPUUsage)
+}
+
+// cpuSample is the cumulative usage a rate is computed from
+type cpuSample struct {
+	sandboxId string
+	// nanoseconds, as reported by the vmserver
+	timestamp int64
+	usage     uint64
+	// when the container was last listed
+	seen time.Time
+}
+
+// recordCPU takes a container's stats as its latest sample, returning its usage in cores since the sample before it,
+// false if there isn't one to compare to yet or the counter went backwards (i.e. the container was restarted).  The
+// stats themselves are left alone, CRI has the kubelet expect the cumulative counter.
+func (m *Manager) recordCPU(sandboxId string, stats *kubeapi.ContainerStats, now time.Time) (float64, bool) {
+	id := stats.GetAttributes().GetId()
+	cpu := stats.GetCpu()
+	if id == "" || cpu.GetTimestamp() == 0 || cpu.GetUsageCoreNanoSeconds() == nil {
+		return 0, false
+	}
+
+	sample := &cpuSample{
+		sandboxId: sandboxId,
+		timestamp: cpu.GetTimestamp(),
+		usage:     cpu.GetUsageCoreNanoSeconds().GetValue(),
+		seen:      now,
+	}
+
+	m.cpuSampleLock.Lock()
+	defer m.cpuSampleLock.Unlock()
+
+	prev, ok := m.cpuSamples[id]
+	if !ok || sample.usage < prev.usage || sample.timestamp <= prev.timestamp {
+		m.cpuSamples[id] = sample
+		return 0, false
+	}
+
+	rate := float64(sample.usage-prev.usage) / float64(sample.timestamp-prev.timestamp)
+
+	// keep the older sample until the window has passed, so back to back lists don't give a rate over milliseconds
+	if time.Duration(sample.timestamp-prev.timestamp) >= cpuRateWindow {
+		m.cpuSamples[id] = sample
+	} else {
+		prev.seen = now
+	}
+
+	containerCPUUsage.WithLabelValues(sandboxId, id, *flags.PodProvider).Set(rate)
+
+	return rate, true
+}
+
+// pruneCPUSamples drops the samples of containers that weren't seen since cpuSampleTTL before now and, if listed isn't
+// nil, i.e. after an unfiltered list, of those not in it
+func (m *Manager) pruneCPUSamples(listed map[string]bool, now time.Time) {
+	m.cpuSampleLock.Lock()
+	defer m.cpuSampleLock.Unlock()
+
+	for id, sample := range m.cpuSamples {
+		if now.Sub(sample.seen) > cpuSampleTTL || (listed != nil && !listed[id]) {
+			delete(m.cpuSamples, id)
+			containerCPUUsage.DeleteLabelValues(sample.sandboxId, id, *flags.PodProvider)
+		}
+	}
+}
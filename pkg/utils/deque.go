@@ -111,3 +111,16 @@ func (d *Deque) FindAndRemove(item interface{}) {
 		}
 	}
 }
+
+func (d *Deque) Contains(item interface{}) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	for e := d.deque.Front(); e != nil; e = e.Next() {
+		if e.Value == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -77,6 +77,9 @@ func (d *dockerProvider) CreateContainer(req *kubeapi.CreateContainerRequest) (*
 	config := req.Config
 	podSandboxID := req.GetPodSandboxId()
 
+	// has to come out before the annotations are turned into labels
+	registryAuth := common.PopRegistryAuth(config)
+
 	sharedPaths, err := processSharedPaths(config.Annotations)
 	if err != nil {
 		return nil, fmt.Errorf("ContainerCreate Failed: %v", err)
@@ -98,7 +101,7 @@ func (d *dockerProvider) CreateContainer(req *kubeapi.CreateContainerRequest) (*
 	}
 
 	if image != "" {
-		pullresp, err := d.client.ImagePull(context.Background(), image, dockertypes.ImagePullOptions{})
+		pullresp, err := d.client.ImagePull(context.Background(), image, dockertypes.ImagePullOptions{RegistryAuth: registryAuth})
 		if err != nil {
 			return nil, fmt.Errorf("ImagePull Failed (%v)\n", err)
 		}
@@ -270,6 +273,10 @@ func (d *dockerProvider) ListContainers(req *kubeapi.ListContainersRequest) (*ku
 			f.Add("id", contId)
 		}
 
+		if req.Filter.PodSandboxId != "" {
+			f.AddLabel(podSandboxIDLabel, req.Filter.PodSandboxId)
+		}
+
 		if req.Filter.State != nil {
 			opts.Filter.Add("status", common.ToDockerContainerStatus(req.Filter.GetState().State))
 		}
@@ -81,9 +81,7 @@ func (p *dockerProvider) CreateExec(id string, opts dockertypes.ExecConfig) (*do
 	return &resp, nil
 }
 
-func (p *dockerProvider) StartExec(startExec string, opts dockertypes.ExecStartCheck, sopts StreamOptions) error {
-	ctx, cancel := getCancelableContext()
-	defer cancel()
+func (p *dockerProvider) StartExec(ctx context.Context, startExec string, opts dockertypes.ExecStartCheck, sopts StreamOptions) error {
 	if opts.Detach {
 		err := p.client.ContainerExecStart(ctx, startExec, opts)
 		if ctxErr := contextError(ctx); ctxErr != nil {
@@ -204,7 +202,27 @@ func (p *dockerProvider) ExecInContainer(container *dockertypes.ContainerJSON, c
 		ErrorStream:  stderr,
 		RawTerminal:  tty,
 	}
-	err = p.StartExec(execObj.ID, startOpts, streamOpts)
+
+	ctx, cancel := getCancelableContext()
+	defer cancel()
+
+	if timeout > 0 {
+		// docker has no way to kill an exec'd process, so on timeout we drop the hijacked connection which
+		// closes the process' stdio and return to the caller
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- p.StartExec(ctx, execObj.ID, startOpts, streamOpts)
+		}()
+
+		select {
+		case err = <-errCh:
+		case <-time.After(timeout):
+			cancel()
+			return operationTimeout{err: fmt.Errorf("exec of %v in %s didn't complete within %v", cmd, container.ID, timeout)}
+		}
+	} else {
+		err = p.StartExec(ctx, execObj.ID, startOpts, streamOpts)
+	}
 	if err != nil {
 		return err
 	}
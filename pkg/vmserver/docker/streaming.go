@@ -62,12 +62,17 @@ func (r *streamingRuntime) Attach(containerID string, in io.Reader, out, errw io
 		return fmt.Errorf("Attach: err = %v", err)
 	}
 
-	_, err = checkContainerStatus(r.client, cont)
+	container, err := checkContainerStatus(r.client, cont)
 	if err != nil {
 		glog.Infof("Attach: checkContainerStatus failed: %v", err)
 		return err
 	}
 
+	// CRI requires the tty setting of an attach to match how the container was created
+	if container.Config != nil && container.Config.Tty != tty {
+		return fmt.Errorf("Attach: tty = %v doesn't match container %v's tty setting", tty, containerID)
+	}
+
 	err = attachContainer(r.client, cont, in, out, errw, tty, resize)
 
 	glog.Infof("Attach (exit): err = %v", err)
@@ -77,9 +82,11 @@ func (r *streamingRuntime) Attach(containerID string, in io.Reader, out, errw io
 func attachContainer(client libdocker.Interface, containerID string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
 	// Have to start this before the call to client.AttachToContainer because client.AttachToContainer is a blocking
 	// call :-( Otherwise, resize events don't get processed and the terminal never resizes.
-	kubecontainer.HandleResizing(resize, func(size remotecommand.TerminalSize) {
-		client.ResizeContainerTTY(containerID, int(size.Height), int(size.Width))
-	})
+	if tty {
+		kubecontainer.HandleResizing(resize, func(size remotecommand.TerminalSize) {
+			client.ResizeContainerTTY(containerID, int(size.Height), int(size.Width))
+		})
+	}
 
 	// TODO(random-liu): Do we really use the *Logs* field here?
 	opts := dockertypes.ContainerAttachOptions{
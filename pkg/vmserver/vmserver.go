@@ -147,7 +147,7 @@ func (m *VMserver) ListPodSandbox(ctx context.Context, req *kubeapi.ListPodSandb
 }
 
 func (m *VMserver) CreateContainer(ctx context.Context, req *kubeapi.CreateContainerRequest) (*kubeapi.CreateContainerResponse, error) {
-	glog.Infof("CreateContainer: req = %+v", req)
+	glog.Infof("CreateContainer: req = %+v", common.RedactRegistryAuth(req))
 
 	resp, err := m.contProvider.CreateContainer(req)
 
@@ -265,11 +265,126 @@ func (m *VMserver) GetMetrics(ctx context.Context, req *common.GetMetricsRequest
 	return resp, err
 }
 
-// TODO
 func (m *VMserver) ContainerStats(ctx context.Context, req *kubeapi.ContainerStatsRequest) (*kubeapi.ContainerStatsResponse, error) {
-	return nil, fmt.Errorf("Not implemented")
+	glog.Infof("ContainerStats: req = %+v", req)
+
+	stats, err := m.containerStats(req.GetContainerId())
+	if err != nil {
+		return nil, fmt.Errorf("ContainerStats: %v", err)
+	}
+
+	resp := &kubeapi.ContainerStatsResponse{Stats: stats}
+
+	glog.Infof("ContainerStats: resp = %+v", resp)
+
+	return resp, nil
 }
 
 func (m *VMserver) ListContainerStats(ctx context.Context, req *kubeapi.ListContainerStatsRequest) (*kubeapi.ListContainerStatsResponse, error) {
-	return nil, fmt.Errorf("Not implemented")
+	glog.V(10).Infof("ListContainerStats: req = %+v", req)
+
+	listReq := &kubeapi.ListContainersRequest{}
+	if filter := req.GetFilter(); filter != nil {
+		listReq.Filter = &kubeapi.ContainerFilter{
+			Id:            filter.GetId(),
+			PodSandboxId:  filter.GetPodSandboxId(),
+			LabelSelector: filter.GetLabelSelector(),
+		}
+	}
+
+	containers, err := m.contProvider.ListContainers(listReq)
+	if err != nil {
+		return nil, fmt.Errorf("ListContainerStats: ListContainers failed: %v", err)
+	}
+
+	results := []*kubeapi.ContainerStats{}
+	for _, c := range containers.GetContainers() {
+		if c.GetState() != kubeapi.ContainerState_CONTAINER_RUNNING {
+			continue
+		}
+
+		stats, err := m.containerStats(c.GetId())
+		if err != nil {
+			glog.Warningf("ListContainerStats: skipping %v: %v", c.GetId(), err)
+			continue
+		}
+
+		results = append(results, stats)
+	}
+
+	resp := &kubeapi.ListContainerStatsResponse{Stats: results}
+
+	glog.V(10).Infof("ListContainerStats: resp = %+v", resp)
+
+	return resp, nil
+}
+
+// containerStats looks the container up in cadvisor by its docker id and converts the latest sample.  Anything cadvisor
+// didn't give us is left nil rather than reported as 0.
+func (m *VMserver) containerStats(id string) (*kubeapi.ContainerStats, error) {
+	_, contId, err := common.ParseContainer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := m.contProvider.ContainerStatus(&kubeapi.ContainerStatusRequest{ContainerId: id})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get status of %v: %v", id, err)
+	}
+
+	options := cadvisorapiv2.RequestOptions{
+		IdType:    cadvisorapiv2.TypeDocker,
+		Count:     1,
+		Recursive: false,
+	}
+
+	infos, err := m.cadvisor.GetContainerInfoV2(contId, options)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get cadvisor info for %v: %v", id, err)
+	}
+
+	stats := &kubeapi.ContainerStats{
+		Attributes: &kubeapi.ContainerAttributes{
+			Id:          id,
+			Metadata:    status.GetStatus().GetMetadata(),
+			Labels:      status.GetStatus().GetLabels(),
+			Annotations: status.GetStatus().GetAnnotations(),
+		},
+	}
+
+	for _, info := range infos {
+		if len(info.Stats) == 0 {
+			continue
+		}
+
+		cstat := info.Stats[len(info.Stats)-1]
+		timestamp := cstat.Timestamp.UnixNano()
+
+		// CRI wants cumulative usage, the kubelet derives nanocores from consecutive samples
+		if info.Spec.HasCpu && cstat.Cpu != nil {
+			stats.Cpu = &kubeapi.CpuUsage{
+				Timestamp:            timestamp,
+				UsageCoreNanoSeconds: &kubeapi.UInt64Value{Value: cstat.Cpu.Usage.Total},
+			}
+		}
+
+		if info.Spec.HasMemory && cstat.Memory != nil {
+			stats.Memory = &kubeapi.MemoryUsage{
+				Timestamp:       timestamp,
+				WorkingSetBytes: &kubeapi.UInt64Value{Value: cstat.Memory.WorkingSet},
+			}
+		}
+
+		if fs := cstat.Filesystem; fs != nil && fs.BaseUsageBytes != nil {
+			stats.WritableLayer = &kubeapi.FilesystemUsage{
+				Timestamp: timestamp,
+				UsedBytes: &kubeapi.UInt64Value{Value: *fs.BaseUsageBytes},
+			}
+			if fs.InodeUsage != nil {
+				stats.WritableLayer.InodesUsed = &kubeapi.UInt64Value{Value: *fs.InodeUsage}
+			}
+		}
+	}
+
+	return stats, nil
 }